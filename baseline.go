@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattes/migrate/database"
+)
+
+// ErrHasVersion is returned by Baseline if the database already has a version.
+type ErrHasVersion struct {
+	Version int
+}
+
+// Error implements the error interface.
+func (e ErrHasVersion) Error() string {
+	return fmt.Sprintf("database already has version %v, can't baseline", e.Version)
+}
+
+// Baseline marks all migrations up to and including version as applied,
+// without running them. Use it to start using migrate with an existing
+// database, that already has the schema of version.
+// If the database keeps a migration history, an entry is recorded for
+// each up migration. Baseline returns ErrHasVersion if the database
+// already has a version.
+func (m *Migrate) Baseline(version uint) error {
+	return m.BaselineContext(context.Background(), version)
+}
+
+// BaselineContext is like Baseline.
+func (m *Migrate) BaselineContext(ctx context.Context, version uint) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+
+	curVersion, dirty, err := m.databaseVersion(ctx)
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	if dirty {
+		return m.unlockErr(ErrDirty{curVersion})
+	}
+
+	if curVersion != database.NilVersion {
+		return m.unlockErr(ErrHasVersion{curVersion})
+	}
+
+	if err := m.versionExists(ctx, version); err != nil {
+		return m.unlockErr(err)
+	}
+
+	err = m.atomic(ctx, func() error {
+		if err := m.baselineHistory(ctx, version); err != nil {
+			return err
+		}
+		return m.databaseSetVersion(ctx, int(version), false)
+	})
+	return m.unlockErr(err)
+}
+
+// baselineHistory records all up migrations up to and including version
+// in the migration history, if the database driver keeps one.
+func (m *Migrate) baselineHistory(ctx context.Context, version uint) error {
+	h, ok := m.databaseDrv.(database.HistoryDriver)
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	v, err := m.sourceFirst(ctx)
+	for err == nil && v <= version {
+		if err := m.baselineEntry(ctx, h, v, now); err != nil {
+			return err
+		}
+		v, err = m.sourceNext(ctx, v)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// baselineEntry records the up migration for version, if any,
+// in the migration history.
+func (m *Migrate) baselineEntry(ctx context.Context, h database.HistoryDriver, version uint, now time.Time) error {
+	r, identifier, err := m.sourceReadUp(ctx, version)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sum, err := checksum(r)
+	if err != nil {
+		return err
+	}
+
+	m.logVerbosePrintf("Baseline %v/u %v\n", version, identifier)
+
+	return m.databaseAppendHistory(ctx, h, database.HistoryEntry{
+		Version:    version,
+		Direction:  database.DirectionUp,
+		Identifier: identifier,
+		Checksum:   sum,
+		StartedAt:  now,
+		FinishedAt: now,
+		AppliedBy:  m.appliedBy(),
+	})
+}
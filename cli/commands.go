@@ -1,11 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
 	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/source"
 	_ "github.com/mattes/migrate/database/stub" // TODO remove again
 	_ "github.com/mattes/migrate/source/file"
-	"os"
-	"fmt"
 )
 
 func createCmd(dir string, timestamp int64, name string, ext string) {
@@ -71,6 +80,67 @@ func downCmd(m *migrate.Migrate, limit int) {
 	}
 }
 
+func gotoPlanCmd(m *migrate.Migrate, v uint, printBodies bool) {
+	migrations, err := m.PlanMigrate(v)
+	printPlan(migrations, err, printBodies)
+}
+
+func upPlanCmd(m *migrate.Migrate, limit int, printBodies bool) {
+	if limit >= 0 {
+		migrations, err := m.PlanSteps(limit)
+		printPlan(migrations, err, printBodies)
+	} else {
+		migrations, err := m.PlanUp()
+		printPlan(migrations, err, printBodies)
+	}
+}
+
+func downPlanCmd(m *migrate.Migrate, limit int, printBodies bool) {
+	if limit >= 0 {
+		migrations, err := m.PlanSteps(-limit)
+		printPlan(migrations, err, printBodies)
+	} else {
+		migrations, err := m.PlanDown()
+		printPlan(migrations, err, printBodies)
+	}
+}
+
+func printPlan(migrations []*migrate.Migration, err error, printBodies bool) {
+	if err != nil {
+		if err != migrate.ErrNoChange {
+			log.fatalErr(err)
+		} else {
+			log.Println(err)
+		}
+		return
+	}
+
+	for _, migr := range migrations {
+		if log.json {
+			log.event(newMigrationEvent("plan", migr))
+			continue
+		}
+
+		if migr.Repeatable {
+			log.Printf("%v\n", migr.LogString())
+		} else {
+			target := fmt.Sprint(migr.TargetVersion)
+			if migr.TargetVersion == database.NilVersion {
+				target = "nil"
+			}
+			log.Printf("%v (=> %v)\n", migr.LogString(), target)
+		}
+
+		if printBodies && migr.Body != nil {
+			body, err := ioutil.ReadAll(migr.BufferedBody)
+			if err != nil {
+				log.fatalErr(err)
+			}
+			log.Printf("%s\n\n", bytes.TrimRight(body, "\n"))
+		}
+	}
+}
+
 func dropCmd(m *migrate.Migrate) {
 	if err := m.Drop(); err != nil {
 		log.fatalErr(err)
@@ -83,7 +153,207 @@ func forceCmd(m *migrate.Migrate, v int) {
 	}
 }
 
+func baselineCmd(m *migrate.Migrate, v uint) {
+	if err := m.Baseline(v); err != nil {
+		log.fatalErr(err)
+	}
+}
+
+// unlockCmd prints who holds the database lock, and removes it if force is set.
+func unlockCmd(m *migrate.Migrate, force bool) {
+	var lock *database.LockInfo
+	var err error
+	if force {
+		lock, err = m.ForceUnlock()
+	} else {
+		lock, err = m.LockHolder()
+	}
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	if log.json {
+		e := lockEvent{Event: "lock", Held: lock != nil, Removed: force && lock != nil}
+		if lock != nil {
+			e.Hostname = lock.Hostname
+			e.Pid = lock.Pid
+			e.AcquiredAt = &lock.AcquiredAt
+			e.HeartbeatAt = &lock.HeartbeatAt
+		}
+		log.event(e)
+		return
+	}
+
+	switch {
+	case lock == nil:
+		log.Println("Not locked")
+	case force:
+		log.Printf("Removed lock held by %v\n", lock)
+	default:
+		log.Printf("Locked by %v\n", lock)
+		log.Println("Use unlock -force to remove the lock if no migration is running")
+	}
+}
+
+func dumpCmd(m *migrate.Migrate, path string) {
+	if path == "" {
+		if err := m.Dump(os.Stdout); err != nil {
+			log.fatalErr(err)
+		}
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.fatalErr(err)
+	}
+	if err := m.Dump(f); err != nil {
+		f.Close()
+		log.fatalErr(err)
+	}
+	if err := f.Close(); err != nil {
+		log.fatalErr(err)
+	}
+}
+
+// squashCmd migrates the database to version to and replaces all migrations
+// in dir up to and including version with the schema dump of the database.
+// Files are named and parsed with the parser of sourceURL.
+// The replaced files are moved to archive, or removed if archive is empty,
+// once the squashed file is written.
+func squashCmd(m *migrate.Migrate, sourceURL string, dir string, to uint, archive string) {
+	parser, err := source.ParserFromURL(sourceURL)
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	ext := ".sql"
+	names := make([]string, 0)
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		migrs, err := parser.Parse(fi.Name())
+		if err != nil || len(migrs) == 0 || migrs[0].Direction == source.Repeatable || migrs[0].Version > to {
+			continue
+		}
+		for _, migr := range migrs {
+			if migr.Version == to && migr.Direction == source.Up {
+				ext = filepath.Ext(fi.Name())
+			}
+		}
+		names = append(names, fi.Name())
+	}
+
+	name := squashedName(parser, to, ext)
+	if len(name) == 0 {
+		log.fatal("error: squash doesn't support the migration filename format of the source")
+	}
+
+	version, dirty, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		log.fatalErr(err)
+	}
+	if dirty {
+		log.fatalErr(migrate.ErrDirty{Version: int(version)})
+	}
+	if err == nil && version > to {
+		log.fatalf("error: database version %v is past %v, use a scratch database to squash\n", version, to)
+	}
+
+	if err := m.Migrate(to); err != nil && err != migrate.ErrNoChange {
+		log.fatalErr(err)
+	}
+
+	var squashed bytes.Buffer
+	fmt.Fprintf(&squashed, "%v\n-- all migrations up to version %v, squashed\n\n", migrate.SquashedHeader, to)
+	if err := m.Dump(&squashed); err != nil {
+		log.fatalErr(err)
+	}
+
+	filename := filepath.Join(dir, name)
+	if err := writeFileAtomic(filename, squashed.Bytes()); err != nil {
+		log.fatalErr(err)
+	}
+
+	if len(archive) > 0 {
+		if err := os.MkdirAll(archive, 0755); err != nil {
+			log.fatalErr(err)
+		}
+	}
+	squashedFiles := 0
+	for _, n := range names {
+		// a previously squashed file was just replaced
+		if n == name {
+			continue
+		}
+		if len(archive) > 0 {
+			err = os.Rename(filepath.Join(dir, n), filepath.Join(archive, n))
+		} else {
+			err = os.Remove(filepath.Join(dir, n))
+		}
+		if err != nil {
+			log.fatalErr(err)
+		}
+		squashedFiles++
+	}
+	log.Printf("Squashed %v files into %v\n", squashedFiles, filename)
+}
+
+// squashedName returns the name of the squashed up migration for version
+// to, in the first filename format parser reads as just that migration.
+// It returns an empty string if there is none.
+func squashedName(parser source.Parser, to uint, ext string) string {
+	for _, format := range []string{"%v_squashed.up%v", "V%v__squashed%v"} {
+		name := fmt.Sprintf(format, to, ext)
+		migrs, err := parser.Parse(name)
+		if err == nil && len(migrs) == 1 && migrs[0].Version == to && migrs[0].Direction == source.Up {
+			return name
+		}
+	}
+	return ""
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and
+// renames it to filename, so filename is either written completely or
+// not at all.
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filename); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
 func versionCmd(m *migrate.Migrate) {
+	if log.json {
+		v, dirty := log.version(m)
+		log.event(versionEvent{Event: "version", Version: v, Dirty: dirty})
+		return
+	}
+
 	v, dirty, err := m.Version()
 	if err != nil {
 		log.fatalErr(err)
@@ -94,3 +364,127 @@ func versionCmd(m *migrate.Migrate) {
 		log.Println(v)
 	}
 }
+
+func historyCmd(m *migrate.Migrate) {
+	history, err := m.History()
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	if log.json {
+		for _, h := range history {
+			log.event(historyEvent{
+				Event:      "history",
+				Version:    h.Version,
+				Direction:  string(h.Direction),
+				Identifier: h.Identifier,
+				Checksum:   h.Checksum,
+				StartedAt:  h.StartedAt,
+				DurationMs: milliseconds(h.Duration),
+				AppliedBy:  h.AppliedBy,
+			})
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDIRECTION\tIDENTIFIER\tSTARTED AT\tDURATION\tAPPLIED BY\tCHECKSUM")
+	for _, h := range history {
+		checksum := h.Checksum
+		if len(checksum) > 12 {
+			checksum = checksum[:12]
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", h.Version, h.Direction, h.Identifier,
+			h.StartedAt.Format(time.RFC3339), h.Duration, h.AppliedBy, checksum)
+	}
+	w.Flush()
+	log.Printf("%s", buf.String())
+}
+
+func statusCmd(m *migrate.Migrate) {
+	statuses, err := m.Status()
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	if log.json {
+		for _, s := range statuses {
+			log.event(newStatusEvent(s))
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tIDENTIFIER\tUP\tDOWN\tSTATUS")
+	for _, s := range statuses {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", s.Version, s.Identifier, yesNo(s.HasUp), yesNo(s.HasDown), statusString(s))
+	}
+	w.Flush()
+	log.Printf("%s", buf.String())
+}
+
+// statusString describes the state of a migration for statusCmd.
+func statusString(s migrate.MigrationStatus) string {
+	strs := []string{"pending"}
+	if s.Applied {
+		strs[0] = "applied"
+	}
+	if s.Current {
+		strs = append(strs, "current")
+	}
+	if s.Dirty {
+		strs = append(strs, "dirty")
+	}
+	if s.Missing {
+		strs = append(strs, "missing in source")
+	}
+	return strings.Join(strs, ", ")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func redoCmd(m *migrate.Migrate, limit int) {
+	if err := m.Redo(limit); err != nil {
+		if err != migrate.ErrNoChange {
+			log.fatalErr(err)
+		} else {
+			log.Println(err)
+		}
+	}
+}
+
+func verifyReversibleCmd(m *migrate.Migrate) {
+	if err := m.VerifyReversible(); err != nil {
+		if err != migrate.ErrNoChange {
+			log.fatalErr(err)
+		} else {
+			log.Println(err)
+		}
+		return
+	}
+	log.Println("ok")
+}
+
+func validateCmd(m *migrate.Migrate) {
+	if err := m.Validate(); err != nil {
+		if e, ok := err.(migrate.ErrChecksumMismatch); ok {
+			for _, mm := range e.Mismatches {
+				if len(mm.Current) == 0 {
+					log.Printf("%v %v: missing in source\n", mm.Version, mm.Identifier)
+				} else {
+					log.Printf("%v %v: checksum %v, applied %v\n", mm.Version, mm.Identifier, mm.Current, mm.Recorded)
+				}
+			}
+			log.fatal("error: applied migrations changed in source")
+		}
+		log.fatalErr(err)
+	}
+	log.Println("ok")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/mattes/migrate"
+)
+
+// hookCommands are shell commands run around migrations,
+// empty commands are skipped.
+type hookCommands struct {
+	beforeAll  string
+	beforeEach string
+	afterEach  string
+	afterAll   string
+	onError    string
+}
+
+// hooks returns migrate.Hooks running the commands with sh -c.
+// Details are passed in MIGRATE_* environment variables.
+func (h hookCommands) hooks() migrate.Hooks {
+	hooks := migrate.Hooks{}
+
+	if h.beforeAll != "" {
+		hooks.BeforeAll = func() error {
+			return runHook("before-all", h.beforeAll)
+		}
+	}
+
+	if h.beforeEach != "" {
+		hooks.BeforeEach = func(migr *migrate.Migration) error {
+			return runHook("before-each", h.beforeEach, migrationEnv(migr)...)
+		}
+	}
+
+	if h.afterEach != "" {
+		hooks.AfterEach = func(migr *migrate.Migration, readTime, runTime time.Duration) error {
+			return runHook("after-each", h.afterEach, append(migrationEnv(migr),
+				fmt.Sprintf("MIGRATE_READ_MS=%v", milliseconds(readTime)),
+				fmt.Sprintf("MIGRATE_RUN_MS=%v", milliseconds(runTime)),
+				fmt.Sprintf("MIGRATE_BYTES_READ=%v", migr.BytesRead))...)
+		}
+	}
+
+	if h.afterAll != "" {
+		hooks.AfterAll = func(count int, duration time.Duration) error {
+			return runHook("after-all", h.afterAll,
+				fmt.Sprintf("MIGRATE_COUNT=%v", count),
+				fmt.Sprintf("MIGRATE_DURATION_MS=%v", milliseconds(duration)))
+		}
+	}
+
+	if h.onError != "" {
+		hooks.OnError = func(migr *migrate.Migration, err error) {
+			env := []string{"MIGRATE_ERROR=" + err.Error()}
+			if migr != nil {
+				env = append(env, migrationEnv(migr)...)
+			}
+			if err := runHook("on-error", h.onError, env...); err != nil {
+				log.Println("error:", err)
+			}
+		}
+	}
+
+	return hooks
+}
+
+// runHook runs command with sh -c. Its output is written to stderr,
+// so it doesn't mix with -format json output.
+func runHook(name string, command string, env ...string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v hook failed: %v", name, err)
+	}
+	return nil
+}
+
+func migrationEnv(migr *migrate.Migration) []string {
+	return []string{
+		fmt.Sprintf("MIGRATE_VERSION=%v", migr.Version),
+		fmt.Sprintf("MIGRATE_TARGET_VERSION=%v", migr.TargetVersion),
+		fmt.Sprintf("MIGRATE_DIRECTION=%v", migrationDirection(migr)),
+		fmt.Sprintf("MIGRATE_IDENTIFIER=%v", migr.Identifier),
+	}
+}
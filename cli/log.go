@@ -1,17 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	logpkg "log"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/database"
 )
 
 type Log struct {
 	verbose bool
+
+	// json writes one JSON object per line to stdout instead of text
+	json bool
 }
 
 func (l *Log) Printf(format string, v ...interface{}) {
-	if l.verbose {
+	if l.json {
+		l.event(messageEvent{"log", strings.TrimSpace(fmt.Sprintf(format, v...))})
+	} else if l.verbose {
 		logpkg.Printf(format, v...)
 	} else {
 		fmt.Fprintf(os.Stderr, format, v...)
@@ -19,7 +31,9 @@ func (l *Log) Printf(format string, v ...interface{}) {
 }
 
 func (l *Log) Println(args ...interface{}) {
-	if l.verbose {
+	if l.json {
+		l.event(messageEvent{"log", strings.TrimSpace(fmt.Sprintln(args...))})
+	} else if l.verbose {
 		logpkg.Println(args...)
 	} else {
 		fmt.Fprintln(os.Stderr, args...)
@@ -31,15 +45,313 @@ func (l *Log) Verbose() bool {
 }
 
 func (l *Log) fatalf(format string, v ...interface{}) {
-	l.Printf(format, v...)
+	if l.json {
+		l.event(errorEvent{Event: "error", Error: errorMessage(fmt.Sprintf(format, v...))})
+	} else {
+		l.Printf(format, v...)
+	}
 	os.Exit(1)
 }
 
 func (l *Log) fatal(args ...interface{}) {
-	l.Println(args...)
+	if l.json {
+		l.event(errorEvent{Event: "error", Error: errorMessage(fmt.Sprintln(args...))})
+	} else {
+		l.Println(args...)
+	}
 	os.Exit(1)
 }
 
 func (l *Log) fatalErr(err error) {
+	failed, dbErr := causes(err)
+
+	if l.json {
+		e := errorEvent{Event: "error", Error: err.Error()}
+		if failed != nil {
+			e.Version, e.Direction, e.Identifier = &failed.Version, string(failed.Direction), failed.Identifier
+		}
+		if dbErr != nil {
+			e.Line, e.Column = dbErr.Line, dbErr.Column
+		}
+		l.event(e)
+		os.Exit(1)
+	}
+
+	// print the failing part of the query instead of the whole query
+	if dbErr != nil && dbErr.Line > 0 {
+		if snippet := querySnippet(dbErr.Query, dbErr.Line, dbErr.Column); len(snippet) > 0 {
+			if failed != nil {
+				l.Printf("error: migration %v/%v %v failed in %v: %v\n",
+					failed.Version, failed.Direction, failed.Identifier, dbErr.Position(), dbErr.OrigErr)
+			} else if len(dbErr.Err) == 0 {
+				l.Printf("error: %v in %v\n", dbErr.OrigErr, dbErr.Position())
+			} else {
+				l.Printf("error: %v in %v (details: %v)\n", dbErr.Err, dbErr.Position(), dbErr.OrigErr)
+			}
+			l.Printf("%s", snippet)
+			os.Exit(1)
+		}
+	}
+
 	l.fatal("error:", err)
 }
+
+// causes unwraps err and returns the failed migration and
+// the database error that caused it, if any.
+func causes(err error) (failed *migrate.ErrMigrationFailed, dbErr *database.Error) {
+	for err != nil {
+		switch e := err.(type) {
+		case migrate.ErrMigrationFailed:
+			failed = &e
+			err = e.Err
+		case migrate.MultiError:
+			// the first error is the cause, i.e. followed by an unlock error
+			if len(e.Errs) == 0 {
+				return failed, nil
+			}
+			err = e.Errs[0]
+		case *database.Error:
+			return failed, e
+		default:
+			return failed, nil
+		}
+	}
+	return failed, nil
+}
+
+// StartMigration implements migrate.MigrationLogger.
+func (l *Log) StartMigration(migr *migrate.Migration) {
+	if l.json {
+		l.event(newMigrationEvent("start", migr))
+	}
+}
+
+// FinishMigration implements migrate.MigrationLogger.
+func (l *Log) FinishMigration(migr *migrate.Migration, readTime, runTime time.Duration) {
+	if !l.json {
+		if l.verbose {
+			l.Printf("Finished %v (read %v, ran %v)\n", migr.LogString(), readTime, runTime)
+		} else {
+			l.Printf("%v (%v)\n", migr.LogString(), readTime+runTime)
+		}
+		return
+	}
+
+	l.event(finishEvent{
+		migrationEvent: newMigrationEvent("finish", migr),
+		ReadMs:         milliseconds(readTime),
+		RunMs:          milliseconds(runTime),
+		BytesRead:      migr.BytesRead,
+	})
+}
+
+// finished prints a summary with the current version of the
+// database after command has finished.
+func (l *Log) finished(command string, m *migrate.Migrate, startTime time.Time) {
+	if !l.json {
+		if l.verbose {
+			l.Println("Finished after", time.Now().Sub(startTime))
+		}
+		return
+	}
+
+	version, dirty := l.version(m)
+	l.event(summaryEvent{
+		Event:      "summary",
+		Command:    command,
+		Version:    version,
+		Dirty:      dirty,
+		DurationMs: milliseconds(time.Now().Sub(startTime)),
+	})
+}
+
+// version returns the current version of the database,
+// or nil if there is none.
+func (l *Log) version(m *migrate.Migrate) (version *uint, dirty bool) {
+	v, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		return nil, false
+	} else if err != nil {
+		l.fatalErr(err)
+	}
+	return &v, dirty
+}
+
+// event writes e as a single line of JSON to stdout.
+func (l *Log) event(e interface{}) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	os.Stdout.Write(append(b, '\n'))
+}
+
+type messageEvent struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+type errorEvent struct {
+	Event      string `json:"event"`
+	Error      string `json:"error"`
+	Version    *uint  `json:"version,omitempty"`
+	Direction  string `json:"direction,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	Line       uint   `json:"line,omitempty"`
+	Column     uint   `json:"column,omitempty"`
+}
+
+type migrationEvent struct {
+	Event         string `json:"event"`
+	Version       uint   `json:"version"`
+	TargetVersion *int   `json:"target_version"`
+	Direction     string `json:"direction"`
+	Identifier    string `json:"identifier"`
+}
+
+func newMigrationEvent(event string, migr *migrate.Migration) migrationEvent {
+	e := migrationEvent{
+		Event:      event,
+		Version:    migr.Version,
+		Direction:  migrationDirection(migr),
+		Identifier: migr.Identifier,
+	}
+	if migr.TargetVersion != database.NilVersion {
+		target := migr.TargetVersion
+		e.TargetVersion = &target
+	}
+	return e
+}
+
+// migrationDirection returns up, down or repeatable.
+func migrationDirection(migr *migrate.Migration) string {
+	if migr.Repeatable {
+		return string(database.DirectionRepeatable)
+	}
+	if migr.TargetVersion < int(migr.Version) {
+		return string(database.DirectionDown)
+	}
+	return string(database.DirectionUp)
+}
+
+type finishEvent struct {
+	migrationEvent
+	ReadMs    float64 `json:"read_ms"`
+	RunMs     float64 `json:"run_ms"`
+	BytesRead int64   `json:"bytes_read"`
+}
+
+type versionEvent struct {
+	Event   string `json:"event"`
+	Version *uint  `json:"version"`
+	Dirty   bool   `json:"dirty"`
+}
+
+type lockEvent struct {
+	Event       string     `json:"event"`
+	Held        bool       `json:"held"`
+	Removed     bool       `json:"removed"`
+	Hostname    string     `json:"hostname,omitempty"`
+	Pid         int        `json:"pid,omitempty"`
+	AcquiredAt  *time.Time `json:"acquired_at,omitempty"`
+	HeartbeatAt *time.Time `json:"heartbeat_at,omitempty"`
+}
+
+type summaryEvent struct {
+	Event      string  `json:"event"`
+	Command    string  `json:"command"`
+	Version    *uint   `json:"version"`
+	Dirty      bool    `json:"dirty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+type historyEvent struct {
+	Event      string    `json:"event"`
+	Version    uint      `json:"version"`
+	Direction  string    `json:"direction"`
+	Identifier string    `json:"identifier"`
+	Checksum   string    `json:"checksum"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs float64   `json:"duration_ms"`
+	AppliedBy  string    `json:"applied_by"`
+}
+
+type statusEvent struct {
+	Event      string     `json:"event"`
+	Version    uint       `json:"version"`
+	Identifier string     `json:"identifier"`
+	HasUp      bool       `json:"has_up"`
+	HasDown    bool       `json:"has_down"`
+	Applied    bool       `json:"applied"`
+	AppliedAt  *time.Time `json:"applied_at"`
+	Current    bool       `json:"current"`
+	Dirty      bool       `json:"dirty"`
+	Missing    bool       `json:"missing"`
+}
+
+func newStatusEvent(s migrate.MigrationStatus) statusEvent {
+	e := statusEvent{
+		Event:      "status",
+		Version:    s.Version,
+		Identifier: s.Identifier,
+		HasUp:      s.HasUp,
+		HasDown:    s.HasDown,
+		Applied:    s.Applied,
+		Current:    s.Current,
+		Dirty:      s.Dirty,
+		Missing:    s.Missing,
+	}
+	if !s.AppliedAt.IsZero() {
+		e.AppliedAt = &s.AppliedAt
+	}
+	return e
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// errorMessage strips the "error: " prefix of messages passed to fatal.
+func errorMessage(msg string) string {
+	return strings.TrimPrefix(strings.TrimSpace(msg), "error: ")
+}
+
+// querySnippet returns the lines of query around line with line numbers
+// and a caret pointing at column, if known.
+func querySnippet(query []byte, line, column uint) string {
+	lines := strings.Split(strings.Replace(string(query), "\r\n", "\n", -1), "\n")
+	if line == 0 || int(line) > len(lines) {
+		return ""
+	}
+
+	first, last := int(line)-2, int(line)+2
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := len(fmt.Sprint(last))
+
+	var b bytes.Buffer
+	for n := first; n <= last; n++ {
+		fmt.Fprintf(&b, "%*d | %s\n", width, n, lines[n-1])
+
+		if n == int(line) && column > 0 {
+			// keep tabs, so the caret lines up
+			indent := make([]rune, 0)
+			for i, r := range []rune(lines[n-1]) {
+				if i >= int(column)-1 {
+					break
+				}
+				if r == '\t' {
+					indent = append(indent, '\t')
+				} else {
+					indent = append(indent, ' ')
+				}
+			}
+			fmt.Fprintf(&b, "%*s | %s^\n", width, "", string(indent))
+		}
+	}
+	return b.String()
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
@@ -20,11 +21,29 @@ func main() {
 	helpPtr := flag.Bool("help", false, "")
 	versionPtr := flag.Bool("version", false, "")
 	verbosePtr := flag.Bool("verbose", false, "")
+	formatPtr := flag.String("format", "text", "")
 	prefetchPtr := flag.Uint("prefetch", 10, "")
 	lockTimeoutPtr := flag.Uint("lock-timeout", 15, "")
+	skipValidationPtr := flag.Bool("skip-validation", false, "")
+	allowOutOfOrderPtr := flag.Bool("allow-out-of-order", false, "")
 	pathPtr := flag.String("path", "", "")
-	databasePtr := flag.String("database", "", "")
+	databases := urlsFlag{}
+	flag.Var(&databases, "database", "")
+	databaseFilePtr := flag.String("database-file", "", "")
+	continueOnErrorPtr := flag.Bool("continue-on-error", false, "")
 	sourcePtr := flag.String("source", "", "")
+	hookBeforeAllPtr := flag.String("hook-before-all", "", "")
+	hookBeforeEachPtr := flag.String("hook-before-each", "", "")
+	hookAfterEachPtr := flag.String("hook-after-each", "", "")
+	hookAfterAllPtr := flag.String("hook-after-all", "", "")
+	hookOnErrorPtr := flag.String("hook-on-error", "", "")
+	vars := varsFlag{}
+	flag.Var(vars, "var", "")
+	strictVarsPtr := flag.Bool("strict-vars", false, "")
+	schemasPtr := flag.String("schemas", "", "")
+	schemasPatternPtr := flag.String("schemas-pattern", "", "")
+	schemasQueryPtr := flag.String("schemas-query", "", "")
+	parallelPtr := flag.Int("parallel", 1, "")
 
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr,
@@ -34,22 +53,75 @@ func main() {
 Options:
   -source          Location of the migrations (driver://url)
   -path            Shorthand for -source=file://path 
-  -database        Run migrations against this database (driver://url)
+  -database        Run migrations against this database (driver://url),
+                   can be repeated to run up, down, goto or version for each
+  -database-file FILE
+                   Run up, down, goto or version for each database in FILE,
+                   one URL per line
+  -continue-on-error
+                   Keep migrating the other databases if one fails
   -prefetch N      Number of migrations to load in advance before executing (default 10)
-  -lock-timeout N  Allow N seconds to acquire database lock (default 15)
+  -lock-timeout N  Allow N seconds to acquire database lock (default 15). While
+                   another process holds the lock, migrate waits for it
+  -skip-validation Don't check applied migrations for changes before migrating up
+  -allow-out-of-order
+                   Apply missing migrations below the current version when migrating up
+  -hook-before-all CMD, -hook-before-each CMD, -hook-after-each CMD,
+  -hook-after-all CMD, -hook-on-error CMD
+                   Run shell command CMD before or after all or each migration,
+                   or if migrating fails. Details are passed in MIGRATE_*
+                   environment variables, see README
+  -var NAME=VALUE  Replace ${NAME} and {{.NAME}} in migrations with VALUE,
+                   can be repeated. -var NAME takes VALUE from the environment
+  -strict-vars     Fail on variables in migrations that aren't set with -var
+  -schemas LIST, -schemas-pattern P, -schemas-query Q
+                   Run up, down, goto or version for each schema in the comma
+                   separated LIST, matching the LIKE pattern P or returned by
+                   query Q, each with its own migrations table (postgres only)
+  -parallel N      Migrate N schemas or databases at the same time (default 1)
   -verbose         Print verbose logging
+  -format F        Output format, text or json (default text). json writes
+                   one event per line to stdout
   -version         Print version
   -help            Print usage
 
 Commands:
   create [-ext E] [-dir D] NAME
                Create a set of timestamped up/down migrations titled NAME, in directory D with extension E
-  goto V       Migrate to version V
-  up [N]       Apply all or N up migrations
-  down [N]     Apply all or N down migrations
+  goto [-dry-run] V
+               Migrate to version V
+  up [-dry-run] [-atomic] [-dump-after FILE] [N]
+               Apply all or N up migrations
+  down [-dry-run] [-dump-after FILE] [N]
+               Apply all or N down migrations
+  redo [N]     Roll back the last N (default 1) migrations and apply them again
+  verify-reversible
+               Apply all migrations one at a time, rolling back and reapplying
+               each one to check its down migration (use a scratch database)
   drop         Drop everyting inside database
   force V      Set version V but don't run migration (ignores dirty state)
+  baseline V   Mark all migrations up to V as applied without running them
+               (only if the database has no version yet)
   version      Print current migration version
+  status       List applied and pending migrations of the source, and applied
+               migrations that are missing in the source
+  unlock [-force]
+               Print who holds the database lock, and remove it with -force,
+               i.e. after a crash (only for databases with a lock table)
+  dump [FILE]  Write the database schema to FILE or stdout
+  squash -scratch -to V [-archive DIR]
+               Migrate the database to V and replace all migrations up to V
+               with V_squashed.up.sql, created from the schema dump. -scratch
+               confirms that the database is a scratch database. Squashed
+               files are removed or moved to DIR
+  history      Print the migration history (if enabled for the database)
+  validate     Check applied migrations for changes in the source (requires history)
+
+  -dry-run prints the migrations that would run, without running them.
+  Add -print-bodies to print the migration bodies, too.
+  -atomic applies all migrations in a single transaction, so either all
+  of them are applied or none (postgres and sqlite3 only).
+  -dump-after writes the database schema to FILE after migrating, like dump.
 `)
 	}
 
@@ -57,6 +129,13 @@ Commands:
 
 	// initialize logger
 	log.verbose = *verbosePtr
+	switch *formatPtr {
+	case "text":
+	case "json":
+		log.json = true
+	default:
+		log.fatalf("error: unknown format %v, use text or json\n", *formatPtr)
+	}
 
 	// show cli version
 	if *versionPtr {
@@ -75,19 +154,58 @@ Commands:
 		*sourcePtr = fmt.Sprintf("file://%v", *pathPtr)
 	}
 
+	configure := func(m *migrate.Migrate) {
+		m.Log = log
+		m.PrefetchMigrations = *prefetchPtr
+		m.LockTimeout = time.Duration(int64(*lockTimeoutPtr)) * time.Second
+		m.SkipValidation = *skipValidationPtr
+		m.AllowOutOfOrder = *allowOutOfOrderPtr
+		m.Vars = vars
+		m.StrictVars = *strictVarsPtr
+		m.Hooks = hookCommands{
+			beforeAll:  *hookBeforeAllPtr,
+			beforeEach: *hookBeforeEachPtr,
+			afterEach:  *hookAfterEachPtr,
+			afterAll:   *hookAfterAllPtr,
+			onError:    *hookOnErrorPtr,
+		}.hooks()
+	}
+
+	if *databaseFilePtr != "" {
+		urls, err := readURLs(*databaseFilePtr)
+		if err != nil {
+			log.fatal("error:", err)
+		}
+		databases = append(databases, urls...)
+	}
+
+	// run the command for each schema instead
+	if *schemasPtr != "" || *schemasPatternPtr != "" || *schemasQueryPtr != "" {
+		if len(databases) > 1 {
+			log.fatal("error: -schemas can only be used with a single database")
+		}
+		schemas := parseSchemas(*schemasPtr, *schemasPatternPtr, *schemasQueryPtr)
+		schemasCmd(*sourcePtr, databases.first(), schemas, *parallelPtr, configure, flag.Args())
+		return
+	}
+
+	// run the command for each database instead
+	if len(databases) > 1 || *databaseFilePtr != "" {
+		databasesCmd(*sourcePtr, databases, *parallelPtr, !*continueOnErrorPtr, configure, flag.Args())
+		return
+	}
+
 	// initialize migrate
 	// don't catch migraterErr here and let each command decide
 	// how it wants to handle the error
-	migrater, migraterErr := migrate.New(*sourcePtr, *databasePtr)
+	migrater, migraterErr := migrate.New(*sourcePtr, databases.first())
 	defer func() {
 		if migraterErr == nil {
 			migrater.Close()
 		}
 	}()
 	if migraterErr == nil {
-		migrater.Log = log
-		migrater.PrefetchMigrations = *prefetchPtr
-		migrater.LockTimeout = time.Duration(int64(*lockTimeoutPtr)) * time.Second
+		configure(migrater)
 
 		// handle Ctrl+c
 		signals := make(chan os.Signal, 1)
@@ -133,47 +251,92 @@ Commands:
 			log.fatalErr(migraterErr)
 		}
 
-		if flag.Arg(1) == "" {
+		gotoFlagSet, dryRunPtr, printBodiesPtr := newDryRunFlagSet("goto")
+		gotoFlagSet.Parse(flag.Args()[1:])
+
+		if gotoFlagSet.Arg(0) == "" {
 			log.fatal("error: please specify version argument V")
 		}
 
-		v, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		v, err := strconv.ParseUint(gotoFlagSet.Arg(0), 10, 64)
 		if err != nil {
 			log.fatal("error: can't read version argument V")
 		}
 
-		gotoCmd(migrater, uint(v))
-
-		if log.verbose {
-			log.Println("Finished after", time.Now().Sub(startTime))
+		if *dryRunPtr {
+			gotoPlanCmd(migrater, uint(v), *printBodiesPtr)
+		} else {
+			gotoCmd(migrater, uint(v))
 		}
 
+		log.finished(flag.Arg(0), migrater, startTime)
+
 	case "up":
 		if migraterErr != nil {
 			log.fatalErr(migraterErr)
 		}
 
+		upFlagSet, dryRunPtr, printBodiesPtr := newDryRunFlagSet("up")
+		atomicPtr := upFlagSet.Bool("atomic", false, "Apply all migrations in a single transaction")
+		dumpAfterPtr := upFlagSet.String("dump-after", "", "Dump the schema to this file after migrating")
+		upFlagSet.Parse(flag.Args()[1:])
+
 		limit := -1
-		if flag.Arg(1) != "" {
-			n, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if upFlagSet.Arg(0) != "" {
+			n, err := strconv.ParseUint(upFlagSet.Arg(0), 10, 64)
 			if err != nil {
 				log.fatal("error: can't read limit argument N")
 			}
 			limit = int(n)
 		}
 
-		upCmd(migrater, limit)
-
-		if log.verbose {
-			log.Println("Finished after", time.Now().Sub(startTime))
+		if *dryRunPtr {
+			upPlanCmd(migrater, limit, *printBodiesPtr)
+		} else {
+			migrater.Atomic = *atomicPtr
+			upCmd(migrater, limit)
+			if *dumpAfterPtr != "" {
+				dumpCmd(migrater, *dumpAfterPtr)
+			}
 		}
 
+		log.finished(flag.Arg(0), migrater, startTime)
+
 	case "down":
 		if migraterErr != nil {
 			log.fatalErr(migraterErr)
 		}
 
+		downFlagSet, dryRunPtr, printBodiesPtr := newDryRunFlagSet("down")
+		dumpAfterPtr := downFlagSet.String("dump-after", "", "Dump the schema to this file after migrating")
+		downFlagSet.Parse(flag.Args()[1:])
+
 		limit := -1
+		if downFlagSet.Arg(0) != "" {
+			n, err := strconv.ParseUint(downFlagSet.Arg(0), 10, 64)
+			if err != nil {
+				log.fatal("error: can't read limit argument N")
+			}
+			limit = int(n)
+		}
+
+		if *dryRunPtr {
+			downPlanCmd(migrater, limit, *printBodiesPtr)
+		} else {
+			downCmd(migrater, limit)
+			if *dumpAfterPtr != "" {
+				dumpCmd(migrater, *dumpAfterPtr)
+			}
+		}
+
+		log.finished(flag.Arg(0), migrater, startTime)
+
+	case "redo":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		limit := 1
 		if flag.Arg(1) != "" {
 			n, err := strconv.ParseUint(flag.Arg(1), 10, 64)
 			if err != nil {
@@ -182,12 +345,19 @@ Commands:
 			limit = int(n)
 		}
 
-		downCmd(migrater, limit)
+		redoCmd(migrater, limit)
 
-		if log.verbose {
-			log.Println("Finished after", time.Now().Sub(startTime))
+		log.finished(flag.Arg(0), migrater, startTime)
+
+	case "verify-reversible":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
 		}
 
+		verifyReversibleCmd(migrater)
+
+		log.finished(flag.Arg(0), migrater, startTime)
+
 	case "drop":
 		if migraterErr != nil {
 			log.fatalErr(migraterErr)
@@ -195,9 +365,7 @@ Commands:
 
 		dropCmd(migrater)
 
-		if log.verbose {
-			log.Println("Finished after", time.Now().Sub(startTime))
-		}
+		log.finished(flag.Arg(0), migrater, startTime)
 
 	case "force":
 		if migraterErr != nil {
@@ -219,10 +387,76 @@ Commands:
 
 		forceCmd(migrater, int(v))
 
-		if log.verbose {
-			log.Println("Finished after", time.Now().Sub(startTime))
+		log.finished(flag.Arg(0), migrater, startTime)
+
+	case "baseline":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		if flag.Arg(1) == "" {
+			log.fatal("error: please specify version argument V")
+		}
+
+		v, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if err != nil {
+			log.fatal("error: can't read version argument V")
+		}
+
+		baselineCmd(migrater, uint(v))
+
+		log.finished(flag.Arg(0), migrater, startTime)
+
+	case "squash":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		squashFlagSet := flag.NewFlagSet("squash", flag.ExitOnError)
+		toPtr := squashFlagSet.String("to", "", "Squash all migrations up to and including this version")
+		archivePtr := squashFlagSet.String("archive", "", "Move squashed files to this directory instead of removing them")
+		scratchPtr := squashFlagSet.Bool("scratch", false, "Confirm that the database is a scratch database to migrate to V")
+		squashFlagSet.Parse(flag.Args()[1:])
+
+		if !*scratchPtr {
+			log.fatal("error: squash migrates the database to V, confirm that it is a scratch database with -scratch")
 		}
 
+		if *toPtr == "" {
+			log.fatal("error: please specify version with -to V")
+		}
+		v, err := strconv.ParseUint(*toPtr, 10, 64)
+		if err != nil {
+			log.fatal("error: can't read version -to V")
+		}
+
+		surl, err := url.Parse(*sourcePtr)
+		if err != nil || surl.Scheme != "file" {
+			log.fatal("error: squash requires a file source (-path or -source file://)")
+		}
+
+		squashCmd(migrater, *sourcePtr, surl.Host+surl.Path, uint(v), *archivePtr)
+
+		log.finished(flag.Arg(0), migrater, startTime)
+
+	case "unlock":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		unlockFlagSet := flag.NewFlagSet("unlock", flag.ExitOnError)
+		forcePtr := unlockFlagSet.Bool("force", false, "Remove the lock regardless of who holds it")
+		unlockFlagSet.Parse(flag.Args()[1:])
+
+		unlockCmd(migrater, *forcePtr)
+
+	case "dump":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		dumpCmd(migrater, flag.Arg(1))
+
 	case "version":
 		if migraterErr != nil {
 			log.fatalErr(migraterErr)
@@ -230,8 +464,37 @@ Commands:
 
 		versionCmd(migrater)
 
+	case "status":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		statusCmd(migrater)
+
+	case "history":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		historyCmd(migrater)
+
+	case "validate":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		validateCmd(migrater)
+
 	default:
 		flag.Usage()
 		os.Exit(0)
 	}
 }
+
+// newDryRunFlagSet returns a flag set for commands that support -dry-run.
+func newDryRunFlagSet(name string) (flagSet *flag.FlagSet, dryRun *bool, printBodies *bool) {
+	flagSet = flag.NewFlagSet(name, flag.ExitOnError)
+	dryRun = flagSet.Bool("dry-run", false, "Print the migrations that would run, but don't run them")
+	printBodies = flagSet.Bool("print-bodies", false, "Print the migration bodies, too (with -dry-run)")
+	return flagSet, dryRun, printBodies
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	nurl "net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/database"
+)
+
+// prefixLog prefixes log messages with the schema or
+// database they belong to.
+type prefixLog struct {
+	*Log
+	prefix string
+}
+
+func (l *prefixLog) Printf(format string, v ...interface{}) {
+	l.Log.Printf("[%v] "+format, append([]interface{}{l.prefix}, v...)...)
+}
+
+func (l *prefixLog) FinishMigration(migr *migrate.Migration, readTime, runTime time.Duration) {
+	if l.verbose {
+		l.Printf("Finished %v (read %v, ran %v)\n", migr.LogString(), readTime, runTime)
+	} else {
+		l.Printf("%v (%v)\n", migr.LogString(), readTime+runTime)
+	}
+}
+
+// multiCommand returns a function that runs the command in args
+// against a single schema or database.
+func multiCommand(args []string) (func(context.Context, *migrate.Migrate) error, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("please specify a command")
+	}
+
+	switch args[0] {
+	case "up", "down":
+		if len(args) > 2 {
+			return nil, fmt.Errorf("%v takes at most one argument N", args[0])
+		}
+		sign := 1
+		if args[0] == "down" {
+			sign = -1
+		}
+		if len(args) == 2 {
+			n, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("can't read limit argument N")
+			}
+			return func(ctx context.Context, m *migrate.Migrate) error {
+				return m.StepsContext(ctx, sign*int(n))
+			}, nil
+		}
+		if sign < 0 {
+			return func(ctx context.Context, m *migrate.Migrate) error {
+				return m.DownContext(ctx)
+			}, nil
+		}
+		return func(ctx context.Context, m *migrate.Migrate) error {
+			return m.UpContext(ctx)
+		}, nil
+
+	case "goto":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("please specify version argument V")
+		}
+		v, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("can't read version argument V")
+		}
+		return func(ctx context.Context, m *migrate.Migrate) error {
+			return m.MigrateContext(ctx, uint(v))
+		}, nil
+
+	case "version":
+		// the version of each schema or database is reported anyway
+		return func(ctx context.Context, m *migrate.Migrate) error {
+			return nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%v is not supported for multiple schemas or databases, use up, down, goto or version", args[0])
+}
+
+// multiRun returns the function called by ForEachSchema and ForEachDatabase.
+// It configures the Migrate instance and runs the command in args.
+func multiRun(ctx context.Context, configure func(*migrate.Migrate), args []string) func(string, *migrate.Migrate) error {
+	run, err := multiCommand(args)
+	if err != nil {
+		log.fatal("error:", err)
+	}
+
+	return func(name string, m *migrate.Migrate) error {
+		configure(m)
+		m.Log = nil
+		if !log.json {
+			m.Log = &prefixLog{Log: log, prefix: redactURL(name)}
+		}
+
+		err := run(ctx, m)
+		if err == migrate.ErrNoChange {
+			return nil
+		}
+		return err
+	}
+}
+
+// multiContext returns a context that is cancelled on Ctrl+c,
+// so no more schemas or databases are started.
+func multiContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT)
+	go func() {
+		for range signals {
+			log.Println("Stopping after the running migrations ...")
+			cancel()
+			return
+		}
+	}()
+	return ctx, cancel
+}
+
+// schemasCmd runs the command in args against each schema selected by
+// schemas and reports the result per schema. configure is called
+// for the Migrate instance of each schema.
+func schemasCmd(sourceURL, databaseURL string, schemas migrate.Schemas, parallel int, configure func(*migrate.Migrate), args []string) {
+	ctx, cancel := multiContext()
+	defer cancel()
+
+	startTime := time.Now()
+	results, err := migrate.ForEachSchemaContext(ctx, sourceURL, databaseURL, schemas, parallel, multiRun(ctx, configure, args))
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	reports := make([]multiReport, len(results))
+	for i, r := range results {
+		reports[i] = multiReport{"schema", r.Schema, r.Err, r.Version, r.Dirty, r.Duration}
+	}
+	report("schemas", reports, startTime)
+}
+
+// databasesCmd runs the command in args against each of databaseURLs and
+// reports the result per database. configure is called for the Migrate
+// instance of each database.
+func databasesCmd(sourceURL string, databaseURLs []string, parallel int, failFast bool, configure func(*migrate.Migrate), args []string) {
+	ctx, cancel := multiContext()
+	defer cancel()
+
+	startTime := time.Now()
+	results, err := migrate.ForEachDatabaseContext(ctx, sourceURL, databaseURLs, parallel, failFast, multiRun(ctx, configure, args))
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	reports := make([]multiReport, len(results))
+	for i, r := range results {
+		reports[i] = multiReport{"database", redactURL(r.DatabaseUrl), r.Err, r.Version, r.Dirty, r.Duration}
+	}
+	report("databases", reports, startTime)
+}
+
+// multiReport is the result of a single schema or database.
+type multiReport struct {
+	event    string
+	name     string
+	err      error
+	version  int
+	dirty    bool
+	duration time.Duration
+}
+
+type multiEvent struct {
+	Event      string  `json:"event"`
+	Schema     string  `json:"schema,omitempty"`
+	Database   string  `json:"database,omitempty"`
+	Version    *int    `json:"version"`
+	Dirty      bool    `json:"dirty"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// report prints the result of each schema or database, and
+// exits with status 1 if any of them failed.
+func report(kind string, reports []multiReport, startTime time.Time) {
+	failed := 0
+	for _, r := range reports {
+		if r.err != nil {
+			failed++
+		}
+
+		if log.json {
+			e := multiEvent{Event: r.event, Dirty: r.dirty, DurationMs: milliseconds(r.duration)}
+			if r.event == "schema" {
+				e.Schema = r.name
+			} else {
+				e.Database = r.name
+			}
+			if r.version != database.NilVersion {
+				v := r.version
+				e.Version = &v
+			}
+			if r.err != nil {
+				e.Error = r.err.Error()
+			}
+			log.event(e)
+			continue
+		}
+
+		version := "nil"
+		if r.version != database.NilVersion {
+			version = strconv.Itoa(r.version)
+		}
+		if r.dirty {
+			version += " (dirty)"
+		}
+		if r.err != nil {
+			log.Printf("%v: error: %v (version %v)\n", r.name, r.err, version)
+		} else {
+			log.Printf("%v: ok, version %v (%v)\n", r.name, version, r.duration)
+		}
+	}
+
+	if !log.json {
+		log.Printf("%v %v, %v failed, after %v\n", len(reports), kind, failed, time.Now().Sub(startTime))
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseSchemas returns the schemas selected by the -schemas flags.
+func parseSchemas(names, pattern, query string) migrate.Schemas {
+	schemas := migrate.Schemas{Pattern: pattern, Query: query}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			schemas.Names = append(schemas.Names, name)
+		}
+	}
+	return schemas
+}
+
+// urlsFlag collects repeated -database flags.
+type urlsFlag []string
+
+func (u *urlsFlag) String() string {
+	return strings.Join(*u, ",")
+}
+
+func (u *urlsFlag) Set(s string) error {
+	*u = append(*u, s)
+	return nil
+}
+
+// first returns the first URL, or an empty string.
+func (u urlsFlag) first() string {
+	if len(u) == 0 {
+		return ""
+	}
+	return u[0]
+}
+
+// readURLs returns the URLs in file, one per line.
+// Empty lines and lines starting with # are skipped.
+func readURLs(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	urls := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// redactURL replaces the password in url, if any.
+func redactURL(url string) string {
+	u, err := nurl.Parse(url)
+	if err != nil || u.User == nil {
+		return url
+	}
+	if _, ok := u.User.Password(); !ok {
+		return url
+	}
+	u.User = nurl.UserPassword(u.User.Username(), "xxxxx")
+	return u.String()
+}
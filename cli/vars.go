@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// varsFlag collects repeated -var name=value flags. A flag without
+// a value takes it from the environment variable name.
+type varsFlag map[string]string
+
+func (v varsFlag) String() string {
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(v))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+v[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v varsFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		value, ok := os.LookupEnv(s)
+		if !ok {
+			return fmt.Errorf("environment variable %v is not set", s)
+		}
+		v[s] = value
+		return nil
+	}
+	if i == 0 {
+		return fmt.Errorf("missing name in %v", s)
+	}
+	v[s[:i]] = s[i+1:]
+	return nil
+}
@@ -0,0 +1,121 @@
+package migrate
+
+import (
+	"context"
+	"io"
+
+	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/source"
+)
+
+// The following helpers call the context-aware functions of the database
+// and source drivers if they implement database.DriverContext,
+// database.HistoryDriverContext or source.DriverContext and fall back
+// to the plain functions otherwise.
+
+func (m *Migrate) databaseLock(ctx context.Context) error {
+	if d, ok := m.databaseDrv.(database.DriverContext); ok {
+		return d.LockContext(ctx)
+	}
+	return m.databaseDrv.Lock()
+}
+
+func (m *Migrate) databaseRun(ctx context.Context, migration io.Reader) error {
+	if d, ok := m.databaseDrv.(database.DriverContext); ok {
+		return d.RunContext(ctx, migration)
+	}
+	return m.databaseDrv.Run(migration)
+}
+
+func (m *Migrate) databaseSetVersion(ctx context.Context, version int, dirty bool) error {
+	if d, ok := m.databaseDrv.(database.DriverContext); ok {
+		return d.SetVersionContext(ctx, version, dirty)
+	}
+	return m.databaseDrv.SetVersion(version, dirty)
+}
+
+func (m *Migrate) databaseVersion(ctx context.Context) (version int, dirty bool, err error) {
+	if d, ok := m.databaseDrv.(database.DriverContext); ok {
+		return d.VersionContext(ctx)
+	}
+	return m.databaseDrv.Version()
+}
+
+func (m *Migrate) databaseDrop(ctx context.Context) error {
+	if d, ok := m.databaseDrv.(database.DriverContext); ok {
+		return d.DropContext(ctx)
+	}
+	return m.databaseDrv.Drop()
+}
+
+func (m *Migrate) databaseAppendHistory(ctx context.Context, h database.HistoryDriver, entry database.HistoryEntry) error {
+	if d, ok := h.(database.HistoryDriverContext); ok {
+		return d.AppendHistoryContext(ctx, entry)
+	}
+	return h.AppendHistory(entry)
+}
+
+func (m *Migrate) databaseHistory(ctx context.Context, h database.HistoryDriver) ([]database.HistoryEntry, error) {
+	if d, ok := h.(database.HistoryDriverContext); ok {
+		return d.HistoryContext(ctx)
+	}
+	return h.History()
+}
+
+func (m *Migrate) sourceDrvFirst(ctx context.Context) (version uint, err error) {
+	if d, ok := m.sourceDrv.(source.DriverContext); ok {
+		return d.FirstContext(ctx)
+	}
+	return m.sourceDrv.First()
+}
+
+func (m *Migrate) sourceDrvPrev(ctx context.Context, version uint) (prevVersion uint, err error) {
+	if d, ok := m.sourceDrv.(source.DriverContext); ok {
+		return d.PrevContext(ctx, version)
+	}
+	return m.sourceDrv.Prev(version)
+}
+
+func (m *Migrate) sourceDrvNext(ctx context.Context, version uint) (nextVersion uint, err error) {
+	if d, ok := m.sourceDrv.(source.DriverContext); ok {
+		return d.NextContext(ctx, version)
+	}
+	return m.sourceDrv.Next(version)
+}
+
+func (m *Migrate) sourceDrvReadUp(ctx context.Context, version uint) (r io.ReadCloser, identifier string, err error) {
+	if d, ok := m.sourceDrv.(source.DriverContext); ok {
+		return d.ReadUpContext(ctx, version)
+	}
+	return m.sourceDrv.ReadUp(version)
+}
+
+func (m *Migrate) sourceDrvReadDown(ctx context.Context, version uint) (r io.ReadCloser, identifier string, err error) {
+	if d, ok := m.sourceDrv.(source.DriverContext); ok {
+		return d.ReadDownContext(ctx, version)
+	}
+	return m.sourceDrv.ReadDown(version)
+}
+
+// The following helpers merge the source with the Go migrations,
+// see RegisterGoMigration.
+
+func (m *Migrate) sourceFirst(ctx context.Context) (version uint, err error) {
+	return m.mergedFirst(ctx)
+}
+
+func (m *Migrate) sourcePrev(ctx context.Context, version uint) (prevVersion uint, err error) {
+	return m.mergedPrev(ctx, version)
+}
+
+func (m *Migrate) sourceNext(ctx context.Context, version uint) (nextVersion uint, err error) {
+	return m.mergedNext(ctx, version)
+}
+
+func (m *Migrate) sourceReadUp(ctx context.Context, version uint) (r io.ReadCloser, identifier string, err error) {
+	return m.mergedRead(ctx, version, true)
+}
+
+func (m *Migrate) sourceReadDown(ctx context.Context, version uint) (r io.ReadCloser, identifier string, err error) {
+	return m.mergedRead(ctx, version, false)
+}
@@ -1,6 +1,7 @@
 package cassandra
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/gocql/gocql"
 	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/database/sqlsplit"
 )
 
 func init() {
@@ -18,7 +20,7 @@ func init() {
 }
 
 var DefaultMigrationsTable = "schema_migrations"
-var dbLocked = false
+var DefaultLockTable = "schema_lock"
 
 var (
 	ErrNilConfig     = fmt.Errorf("no config")
@@ -29,11 +31,19 @@ var (
 type Config struct {
 	MigrationsTable string
 	KeyspaceName    string
+
+	// LockTable is the name of the table which maintains the migration lock.
+	LockTable string
+
+	// LockStaleAfter is the time after which the lock expires if its
+	// holder crashed. It defaults to database.DefaultLockStaleAfter.
+	LockStaleAfter time.Duration
 }
 
 type Cassandra struct {
 	session  *gocql.Session
 	isLocked bool
+	lock     *database.LockTable
 
 	// Open and WithInstance need to guarantee that config is never nil
 	config *Config
@@ -55,9 +65,25 @@ func (p *Cassandra) Open(url string) (database.Driver, error) {
 		migrationsTable = DefaultMigrationsTable
 	}
 
-	p.config = &Config{
-		KeyspaceName:    u.Path,
-		MigrationsTable: migrationsTable,
+	lockTable := u.Query().Get("x-lock-table")
+	if len(lockTable) == 0 {
+		lockTable = DefaultLockTable
+	}
+
+	var lockStaleAfter time.Duration
+	if s := u.Query().Get("x-lock-stale-after"); len(s) > 0 {
+		if lockStaleAfter, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Cassandra{
+		config: &Config{
+			KeyspaceName:    u.Path,
+			MigrationsTable: migrationsTable,
+			LockTable:       lockTable,
+			LockStaleAfter:  lockStaleAfter,
+		},
 	}
 
 	cluster := gocql.NewCluster(u.Host)
@@ -100,17 +126,27 @@ func (p *Cassandra) Open(url string) (database.Driver, error) {
 		cluster.Timeout = timeout
 	}
 
-	p.session, err = cluster.CreateSession()
+	c.session, err = cluster.CreateSession()
 
 	if err != nil {
 		return nil, err
 	}
 
-	if err := p.ensureVersionTable(); err != nil {
+	if err := c.ensureVersionTable(); err != nil {
+		return nil, err
+	}
+
+	if err := c.ensureLockTable(); err != nil {
+		return nil, err
+	}
+
+	aid, err := database.GenerateAdvisoryLockId(c.config.KeyspaceName)
+	if err != nil {
 		return nil, err
 	}
+	c.lock = database.NewLockTable(&lockStore{session: c.session, table: c.config.LockTable}, aid, c.config.LockStaleAfter)
 
-	return p, nil
+	return c, nil
 }
 
 func (p *Cassandra) Close() error {
@@ -118,42 +154,78 @@ func (p *Cassandra) Close() error {
 	return nil
 }
 
+// Handle implements database.Handler. It returns the *gocql.Session.
+func (p *Cassandra) Handle() interface{} {
+	return p.session
+}
+
+// Lock uses a lock table, as Cassandra has no advisory locks.
 func (p *Cassandra) Lock() error {
-	if dbLocked {
-		return database.ErrLocked
+	return p.LockContext(context.Background())
+}
+
+func (p *Cassandra) LockContext(ctx context.Context) error {
+	if err := p.lock.Lock(ctx); err != nil {
+		return err
 	}
-	dbLocked = true
+	p.isLocked = true
 	return nil
 }
 
 func (p *Cassandra) Unlock() error {
-	dbLocked = false
+	if err := p.lock.Unlock(context.Background()); err != nil {
+		return err
+	}
+	p.isLocked = false
 	return nil
 }
 
+// LockLost implements database.LockLostDriver.
+func (p *Cassandra) LockLost() <-chan struct{} {
+	return p.lock.Lost()
+}
+
+// LockHolder implements database.LockTableDriver.
+func (p *Cassandra) LockHolder(ctx context.Context) (*database.LockInfo, error) {
+	return p.lock.Holder(ctx)
+}
+
+// ForceUnlock implements database.LockTableDriver.
+func (p *Cassandra) ForceUnlock(ctx context.Context) (*database.LockInfo, error) {
+	return p.lock.ForceUnlock(ctx)
+}
+
 func (p *Cassandra) Run(migration io.Reader) error {
+	return p.RunContext(context.Background(), migration)
+}
+
+func (p *Cassandra) RunContext(ctx context.Context, migration io.Reader) error {
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
 	}
-	// run migration
-	query := string(migr[:])
-	if err := p.session.Query(query).Exec(); err != nil {
-		// TODO: cast to Cassandra error and get line number
-		return database.Error{OrigErr: err, Err: "migration failed", Query: migr}
+	// run migration, one statement at a time
+	for _, stmt := range sqlsplit.Split(migr, sqlsplit.Cassandra) {
+		if err := p.session.Query(stmt.Query).WithContext(ctx).Exec(); err != nil {
+			return &database.Error{OrigErr: err, Err: "migration failed", Line: stmt.Line, Column: stmt.Column, Query: migr}
+		}
 	}
 
 	return nil
 }
 
 func (p *Cassandra) SetVersion(version int, dirty bool) error {
+	return p.SetVersionContext(context.Background(), version, dirty)
+}
+
+func (p *Cassandra) SetVersionContext(ctx context.Context, version int, dirty bool) error {
 	query := `TRUNCATE "` + p.config.MigrationsTable + `"`
-	if err := p.session.Query(query).Exec(); err != nil {
+	if err := p.session.Query(query).WithContext(ctx).Exec(); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 	if version >= 0 {
 		query = `INSERT INTO "` + p.config.MigrationsTable + `" (version, dirty) VALUES (?, ?)`
-		if err := p.session.Query(query, version, dirty).Exec(); err != nil {
+		if err := p.session.Query(query, version, dirty).WithContext(ctx).Exec(); err != nil {
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
 	}
@@ -163,8 +235,12 @@ func (p *Cassandra) SetVersion(version int, dirty bool) error {
 
 // Return current keyspace version
 func (p *Cassandra) Version() (version int, dirty bool, err error) {
+	return p.VersionContext(context.Background())
+}
+
+func (p *Cassandra) VersionContext(ctx context.Context) (version int, dirty bool, err error) {
 	query := `SELECT version, dirty FROM "` + p.config.MigrationsTable + `" LIMIT 1`
-	err = p.session.Query(query).Scan(&version, &dirty)
+	err = p.session.Query(query).WithContext(ctx).Scan(&version, &dirty)
 	switch {
 	case err == gocql.ErrNotFound:
 		return database.NilVersion, false, nil
@@ -181,12 +257,20 @@ func (p *Cassandra) Version() (version int, dirty bool, err error) {
 }
 
 func (p *Cassandra) Drop() error {
+	return p.DropContext(context.Background())
+}
+
+func (p *Cassandra) DropContext(ctx context.Context) error {
 	// select all tables in current schema
 	query := fmt.Sprintf(`SELECT table_name from system_schema.tables WHERE keyspace_name='%s'`, p.config.KeyspaceName[1:]) // Skip '/' character
-	iter := p.session.Query(query).Iter()
+	iter := p.session.Query(query).WithContext(ctx).Iter()
 	var tableName string
 	for iter.Scan(&tableName) {
-		err := p.session.Query(fmt.Sprintf(`DROP TABLE %s`, tableName)).Exec()
+		// keep the lock, it is held while dropping
+		if tableName == p.config.LockTable {
+			continue
+		}
+		err := p.session.Query(fmt.Sprintf(`DROP TABLE %s`, tableName)).WithContext(ctx).Exec()
 		if err != nil {
 			return err
 		}
@@ -210,6 +294,64 @@ func (p *Cassandra) ensureVersionTable() error {
 	return nil
 }
 
+// Ensure lock table exists
+func (p *Cassandra) ensureLockTable() error {
+	return p.session.Query(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (lock_id text, owner text, hostname text, pid int, acquired_at timestamp, heartbeat_at timestamp, PRIMARY KEY(lock_id))", p.config.LockTable)).Exec()
+}
+
+// lockStore implements database.LockStore with the lock table,
+// using lightweight transactions.
+type lockStore struct {
+	session *gocql.Session
+	table   string
+}
+
+func (l *lockStore) InsertLock(ctx context.Context, lock database.LockInfo) (bool, error) {
+	query := `INSERT INTO "` + l.table + `" (lock_id, owner, hostname, pid, acquired_at, heartbeat_at) VALUES (?, ?, ?, ?, ?, ?) IF NOT EXISTS`
+	return l.cas(ctx, query, lock.LockId, lock.Owner, lock.Hostname, lock.Pid, lock.AcquiredAt, lock.HeartbeatAt)
+}
+
+func (l *lockStore) ReplaceLock(ctx context.Context, oldOwner string, lock database.LockInfo) (bool, error) {
+	query := `UPDATE "` + l.table + `" SET owner = ?, hostname = ?, pid = ?, acquired_at = ?, heartbeat_at = ? WHERE lock_id = ? IF owner = ?`
+	return l.cas(ctx, query, lock.Owner, lock.Hostname, lock.Pid, lock.AcquiredAt, lock.HeartbeatAt, lock.LockId, oldOwner)
+}
+
+func (l *lockStore) UpdateHeartbeat(ctx context.Context, lockId, owner string, heartbeatAt time.Time) (bool, error) {
+	query := `UPDATE "` + l.table + `" SET heartbeat_at = ? WHERE lock_id = ? IF owner = ?`
+	return l.cas(ctx, query, heartbeatAt, lockId, owner)
+}
+
+func (l *lockStore) DeleteLock(ctx context.Context, lockId, owner string) error {
+	var err error
+	if len(owner) == 0 {
+		_, err = l.cas(ctx, `DELETE FROM "`+l.table+`" WHERE lock_id = ? IF EXISTS`, lockId)
+	} else {
+		_, err = l.cas(ctx, `DELETE FROM "`+l.table+`" WHERE lock_id = ? IF owner = ?`, lockId, owner)
+	}
+	return err
+}
+
+func (l *lockStore) ReadLock(ctx context.Context, lockId string) (*database.LockInfo, error) {
+	query := `SELECT owner, hostname, pid, acquired_at, heartbeat_at FROM "` + l.table + `" WHERE lock_id = ?`
+	lock := database.LockInfo{LockId: lockId}
+	err := l.session.Query(query, lockId).WithContext(ctx).Scan(&lock.Owner, &lock.Hostname, &lock.Pid, &lock.AcquiredAt, &lock.HeartbeatAt)
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, &database.Error{OrigErr: err, Err: "failed to fetch migration lock", Query: []byte(query)}
+	}
+	return &lock, nil
+}
+
+// cas runs the lightweight transaction query and reports if it was applied.
+func (l *lockStore) cas(ctx context.Context, query string, values ...interface{}) (bool, error) {
+	applied, err := l.session.Query(query, values...).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return false, &database.Error{OrigErr: err, Err: "failed to update migration lock", Query: []byte(query)}
+	}
+	return applied, nil
+}
+
 // ParseConsistency wraps gocql.ParseConsistency
 // to return an error instead of a panicking.
 func parseConsistency(consistencyStr string) (consistency gocql.Consistency, err error) {
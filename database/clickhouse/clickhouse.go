@@ -1,6 +1,7 @@
 package clickhouse
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/mattes/migrate"
 	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/database/sqlsplit"
 )
 
 var DefaultMigrationsTable = "schema_migrations"
@@ -93,23 +95,34 @@ func (ch *ClickHouse) init() error {
 }
 
 func (ch *ClickHouse) Run(r io.Reader) error {
+	return ch.RunContext(context.Background(), r)
+}
+
+func (ch *ClickHouse) RunContext(ctx context.Context, r io.Reader) error {
 	migration, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
-	if _, err := ch.conn.Exec(string(migration)); err != nil {
-		return database.Error{OrigErr: err, Err: "migration failed", Query: migration}
+	// the driver can only execute a single statement at a time
+	for _, stmt := range sqlsplit.Split(migration, sqlsplit.ClickHouse) {
+		if _, err := ch.conn.ExecContext(ctx, stmt.Query); err != nil {
+			return &database.Error{OrigErr: err, Err: "migration failed", Line: stmt.Line, Column: stmt.Column, Query: migration}
+		}
 	}
 
 	return nil
 }
 func (ch *ClickHouse) Version() (int, bool, error) {
+	return ch.VersionContext(context.Background())
+}
+
+func (ch *ClickHouse) VersionContext(ctx context.Context) (int, bool, error) {
 	var (
 		version int
 		dirty   uint8
 		query   = "SELECT version, dirty FROM `" + ch.config.MigrationsTable + "` ORDER BY sequence DESC LIMIT 1"
 	)
-	if err := ch.conn.QueryRow(query).Scan(&version, &dirty); err != nil {
+	if err := ch.conn.QueryRowContext(ctx, query).Scan(&version, &dirty); err != nil {
 		if err == sql.ErrNoRows {
 			return database.NilVersion, false, nil
 		}
@@ -119,6 +132,10 @@ func (ch *ClickHouse) Version() (int, bool, error) {
 }
 
 func (ch *ClickHouse) SetVersion(version int, dirty bool) error {
+	return ch.SetVersionContext(context.Background(), version, dirty)
+}
+
+func (ch *ClickHouse) SetVersionContext(ctx context.Context, version int, dirty bool) error {
 	var (
 		bool = func(v bool) uint8 {
 			if v {
@@ -126,14 +143,14 @@ func (ch *ClickHouse) SetVersion(version int, dirty bool) error {
 			}
 			return 0
 		}
-		tx, err = ch.conn.Begin()
+		tx, err = ch.conn.BeginTx(ctx, nil)
 	)
 	if err != nil {
 		return err
 	}
 
 	query := "INSERT INTO " + ch.config.MigrationsTable + " (version, dirty, sequence) VALUES (?, ?, ?)"
-	if _, err := tx.Exec(query, version, bool(dirty), time.Now().UnixNano()); err != nil {
+	if _, err := tx.ExecContext(ctx, query, version, bool(dirty), time.Now().UnixNano()); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 
@@ -168,9 +185,13 @@ func (ch *ClickHouse) ensureVersionTable() error {
 }
 
 func (ch *ClickHouse) Drop() error {
+	return ch.DropContext(context.Background())
+}
+
+func (ch *ClickHouse) DropContext(ctx context.Context) error {
 	var (
 		query       = "SHOW TABLES FROM " + ch.config.DatabaseName
-		tables, err = ch.conn.Query(query)
+		tables, err = ch.conn.QueryContext(ctx, query)
 	)
 	if err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
@@ -184,13 +205,17 @@ func (ch *ClickHouse) Drop() error {
 
 		query = "DROP TABLE IF EXISTS " + ch.config.DatabaseName + "." + table
 
-		if _, err := ch.conn.Exec(query); err != nil {
+		if _, err := ch.conn.ExecContext(ctx, query); err != nil {
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
 	}
 	return ch.ensureVersionTable()
 }
 
-func (ch *ClickHouse) Lock() error   { return nil }
-func (ch *ClickHouse) Unlock() error { return nil }
-func (ch *ClickHouse) Close() error  { return ch.conn.Close() }
+func (ch *ClickHouse) Lock() error                           { return nil }
+func (ch *ClickHouse) LockContext(ctx context.Context) error { return nil }
+func (ch *ClickHouse) Unlock() error                         { return nil }
+func (ch *ClickHouse) Close() error                          { return ch.conn.Close() }
+
+// Handle implements database.Handler. It returns the *sql.DB.
+func (ch *ClickHouse) Handle() interface{} { return ch.conn }
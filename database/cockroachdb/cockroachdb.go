@@ -14,6 +14,7 @@ import (
 	"regexp"
 	"strconv"
 	"context"
+	"time"
 )
 
 func init() {
@@ -33,14 +34,19 @@ var (
 
 type Config struct {
 	MigrationsTable string
-	LockTable		string
-	ForceLock		bool
+	LockTable       string
+	ForceLock       bool
 	DatabaseName    string
+
+	// LockStaleAfter is the time after which the lock expires if its
+	// holder crashed. It defaults to database.DefaultLockStaleAfter.
+	LockStaleAfter time.Duration
 }
 
 type CockroachDb struct {
 	db       *sql.DB
 	isLocked bool
+	lock     *database.LockTable
 
 	// Open and WithInstance need to guarantee that config is never nil
 	config *Config
@@ -75,10 +81,17 @@ func WithInstance(instance *sql.DB, config *Config) (database.Driver, error) {
 		config.LockTable = DefaultLockTable
 	}
 
+	aid, err := database.GenerateAdvisoryLockId(config.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+
 	px := &CockroachDb{
 		db:     instance,
 		config: config,
 	}
+	px.lock = database.NewLockTable(&lockStore{db: instance, table: config.LockTable}, aid, config.LockStaleAfter)
+	px.lock.Force = config.ForceLock
 
 	if err := px.ensureVersionTable(); err != nil {
 		return nil, err
@@ -123,11 +136,19 @@ func (c *CockroachDb) Open(url string) (database.Driver, error) {
 		forceLock = false
 	}
 
+	var lockStaleAfter time.Duration
+	if s := purl.Query().Get("x-lock-stale-after"); len(s) > 0 {
+		if lockStaleAfter, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
 	px, err := WithInstance(db, &Config{
 		DatabaseName:    purl.Path,
 		MigrationsTable: migrationsTable,
-		LockTable: lockTable,
-		ForceLock: forceLock,
+		LockTable:       lockTable,
+		ForceLock:       forceLock,
+		LockStaleAfter:  lockStaleAfter,
 	})
 	if err != nil {
 		return nil, err
@@ -142,71 +163,53 @@ func (c *CockroachDb) Close() error {
 
 // Locking is done manually with a separate lock table.  Implementing advisory locks in CRDB is being discussed
 // See: https://github.com/cockroachdb/cockroach/issues/13546
-func (c *CockroachDb) Lock() error {
-	err := crdb.ExecuteTx(context.Background(), c.db, nil, func(tx *sql.Tx) error {
-		aid, err := database.GenerateAdvisoryLockId(c.config.DatabaseName)
-		if err != nil {
-			return err
-		}
-
-		query := "SELECT * FROM " + c.config.LockTable + " WHERE lock_id = $1"
-		rows, err := tx.Query(query, aid)
-		if err != nil {
-			return database.Error{OrigErr: err, Err: "failed to fetch migration lock", Query: []byte(query)}
-		}
-		defer rows.Close()
-
-		// If row exists at all, lock is present
-		locked := rows.Next()
-		if locked && !c.config.ForceLock {
-			return database.Error{Err: "lock could not be acquired; already locked", Query: []byte(query)}
-		}
-
-		query = "INSERT INTO " + c.config.LockTable + " (lock_id) VALUES ($1)"
-		if _, err := tx.Exec(query, aid) ; err != nil {
-			return database.Error{OrigErr: err, Err: "failed to set migration lock", Query: []byte(query)}
-		}
+// Handle implements database.Handler. It returns the *sql.DB.
+func (c *CockroachDb) Handle() interface{} {
+	return c.db
+}
 
-		return nil
-	})
+func (c *CockroachDb) Lock() error {
+	return c.LockContext(context.Background())
+}
 
-	if err != nil {
+func (c *CockroachDb) LockContext(ctx context.Context) error {
+	if err := c.lock.Lock(ctx); err != nil {
 		return err
-	} else {
-		c.isLocked = true
-		return nil
 	}
+	c.isLocked = true
+	return nil
 }
 
 // Locking is done manually with a separate lock table.  Implementing advisory locks in CRDB is being discussed
 // See: https://github.com/cockroachdb/cockroach/issues/13546
 func (c *CockroachDb) Unlock() error {
-	aid, err := database.GenerateAdvisoryLockId(c.config.DatabaseName)
-	if err != nil {
+	if err := c.lock.Unlock(context.Background()); err != nil {
 		return err
 	}
-
-	// In the event of an implementation (non-migration) error, it is possible for the lock to not be released.  Until
-	// a better locking mechanism is added, a manual purging of the lock table may be required in such circumstances
-	query := "DELETE FROM " + c.config.LockTable + " WHERE lock_id = $1"
-	if _, err := c.db.Exec(query, aid); err != nil {
-		if e, ok := err.(*pq.Error); ok {
-			// 42P01 is "UndefinedTableError" in CockroachDB
-			// https://github.com/cockroachdb/cockroach/blob/master/pkg/sql/pgwire/pgerror/codes.go
-			if e.Code == "42P01" {
-				// On drops, the lock table is fully removed;  This is fine, and is a valid "unlocked" state for the schema
-				c.isLocked = false
-				return nil
-			}
-		}
-		return database.Error{OrigErr: err, Err: "failed to release migration lock", Query: []byte(query)}
-	}
-
 	c.isLocked = false
 	return nil
 }
 
+// LockLost implements database.LockLostDriver.
+func (c *CockroachDb) LockLost() <-chan struct{} {
+	return c.lock.Lost()
+}
+
+// LockHolder implements database.LockTableDriver.
+func (c *CockroachDb) LockHolder(ctx context.Context) (*database.LockInfo, error) {
+	return c.lock.Holder(ctx)
+}
+
+// ForceUnlock implements database.LockTableDriver.
+func (c *CockroachDb) ForceUnlock(ctx context.Context) (*database.LockInfo, error) {
+	return c.lock.ForceUnlock(ctx)
+}
+
 func (c *CockroachDb) Run(migration io.Reader) error {
+	return c.RunContext(context.Background(), migration)
+}
+
+func (c *CockroachDb) RunContext(ctx context.Context, migration io.Reader) error {
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
@@ -214,32 +217,66 @@ func (c *CockroachDb) Run(migration io.Reader) error {
 
 	// run migration
 	query := string(migr[:])
-	if _, err := c.db.Exec(query); err != nil {
-		return database.Error{OrigErr: err, Err: "migration failed", Query: migr}
+	if _, err := c.db.ExecContext(ctx, query); err != nil {
+		return &database.Error{OrigErr: err, Err: "migration failed", Query: migr}
 	}
 
 	return nil
 }
 
 func (c *CockroachDb) SetVersion(version int, dirty bool) error {
-	return crdb.ExecuteTx(context.Background(), c.db, nil, func(tx *sql.Tx) error {
-		if _, err := tx.Exec(`DELETE FROM "` + c.config.MigrationsTable + `"`); err != nil {
+	return c.SetVersionContext(context.Background(), version, dirty)
+}
+
+func (c *CockroachDb) SetVersionContext(ctx context.Context, version int, dirty bool) error {
+	return crdb.ExecuteTx(ctx, c.db, nil, func(tx *sql.Tx) error {
+		return c.setVersion(ctx, tx, version, dirty)
+	})
+}
+
+// RunWithVersion implements database.TransactionalDriver.
+// The transaction is retried by crdb.ExecuteTx on retryable errors.
+func (c *CockroachDb) RunWithVersion(ctx context.Context, migration io.Reader, version int) error {
+	migr, err := ioutil.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	// return unwrapped errors, so crdb.ExecuteTx can detect retryable errors
+	err = crdb.ExecuteTx(ctx, c.db, nil, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, string(migr)); err != nil {
 			return err
 		}
+		return c.setVersion(ctx, tx, version, false)
+	})
+	if err != nil {
+		return &database.Error{OrigErr: err, Err: "migration failed", Query: migr}
+	}
 
-		if version >= 0 {
-			if _, err := tx.Exec(`INSERT INTO "` + c.config.MigrationsTable + `" (version, dirty) VALUES ($1, $2)`, version, dirty); err != nil {
-				return err
-			}
+	return nil
+}
+
+func (c *CockroachDb) setVersion(ctx context.Context, tx *sql.Tx, version int, dirty bool) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "`+c.config.MigrationsTable+`"`); err != nil {
+		return err
+	}
+
+	if version >= 0 {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO "`+c.config.MigrationsTable+`" (version, dirty) VALUES ($1, $2)`, version, dirty); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	return nil
 }
 
 func (c *CockroachDb) Version() (version int, dirty bool, err error) {
+	return c.VersionContext(context.Background())
+}
+
+func (c *CockroachDb) VersionContext(ctx context.Context) (version int, dirty bool, err error) {
 	query := `SELECT version, dirty FROM "` + c.config.MigrationsTable + `" LIMIT 1`
-	err = c.db.QueryRow(query).Scan(&version, &dirty)
+	err = c.db.QueryRowContext(ctx, query).Scan(&version, &dirty)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -261,22 +298,26 @@ func (c *CockroachDb) Version() (version int, dirty bool, err error) {
 }
 
 func (c *CockroachDb) Drop() error {
+	return c.DropContext(context.Background())
+}
+
+func (c *CockroachDb) DropContext(ctx context.Context) error {
 	// select all tables in current schema
 	query := `SELECT table_name FROM information_schema.tables WHERE table_schema=(SELECT current_schema())`
-	tables, err := c.db.Query(query)
+	tables, err := c.db.QueryContext(ctx, query)
 	if err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 	defer tables.Close()
 
-	// delete one table after another
+	// delete one table after another, but keep the lock
 	tableNames := make([]string, 0)
 	for tables.Next() {
 		var tableName string
 		if err := tables.Scan(&tableName); err != nil {
 			return err
 		}
-		if len(tableName) > 0 {
+		if len(tableName) > 0 && tableName != c.config.LockTable {
 			tableNames = append(tableNames, tableName)
 		}
 	}
@@ -285,7 +326,7 @@ func (c *CockroachDb) Drop() error {
 		// delete one by one ...
 		for _, t := range tableNames {
 			query = `DROP TABLE IF EXISTS ` + t + ` CASCADE`
-			if _, err := c.db.Exec(query); err != nil {
+			if _, err := c.db.ExecContext(ctx, query); err != nil {
 				return &database.Error{OrigErr: err, Query: []byte(query)}
 			}
 		}
@@ -325,14 +366,102 @@ func (c *CockroachDb) ensureLockTable() error {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 	if count == 1 {
+		// add the columns describing the holder to lock tables of older versions
+		query = `ALTER TABLE "` + c.config.LockTable + `" ` + lockColumns("ADD COLUMN IF NOT EXISTS ")
+		if _, err := c.db.Exec(query); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
 		return nil
 	}
 
 	// if not, create the empty lock table
-	query = `CREATE TABLE "` + c.config.LockTable + `" (lock_id INT NOT NULL PRIMARY KEY)`
+	query = `CREATE TABLE "` + c.config.LockTable + `" (lock_id INT NOT NULL PRIMARY KEY, ` + lockColumns("") + `)`
 	if _, err := c.db.Exec(query); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 
 	return nil
 }
+
+// lockColumns returns the columns of the lock table describing
+// the holder, each prefixed with prefix.
+func lockColumns(prefix string) string {
+	return prefix + "owner STRING, " +
+		prefix + "hostname STRING, " +
+		prefix + "pid INT, " +
+		prefix + "acquired_at TIMESTAMPTZ, " +
+		prefix + "heartbeat_at TIMESTAMPTZ"
+}
+
+// lockStore implements database.LockStore with the lock table.
+type lockStore struct {
+	db    *sql.DB
+	table string
+}
+
+func (l *lockStore) InsertLock(ctx context.Context, lock database.LockInfo) (bool, error) {
+	query := `INSERT INTO "` + l.table + `" (lock_id, owner, hostname, pid, acquired_at, heartbeat_at)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (lock_id) DO NOTHING`
+	return l.exec(ctx, "failed to set migration lock", query,
+		lock.LockId, lock.Owner, lock.Hostname, lock.Pid, lock.AcquiredAt, lock.HeartbeatAt)
+}
+
+func (l *lockStore) ReplaceLock(ctx context.Context, oldOwner string, lock database.LockInfo) (bool, error) {
+	query := `UPDATE "` + l.table + `" SET owner = $3, hostname = $4, pid = $5, acquired_at = $6, heartbeat_at = $7
+		WHERE lock_id = $1 AND COALESCE(owner, '') = $2`
+	return l.exec(ctx, "failed to set migration lock", query,
+		lock.LockId, oldOwner, lock.Owner, lock.Hostname, lock.Pid, lock.AcquiredAt, lock.HeartbeatAt)
+}
+
+func (l *lockStore) UpdateHeartbeat(ctx context.Context, lockId, owner string, heartbeatAt time.Time) (bool, error) {
+	query := `UPDATE "` + l.table + `" SET heartbeat_at = $3 WHERE lock_id = $1 AND owner = $2`
+	return l.exec(ctx, "failed to refresh migration lock", query, lockId, owner, heartbeatAt)
+}
+
+// In the event of an implementation (non-migration) error, it is possible for the lock to not be released.
+// It expires after LockStaleAfter, or can be removed with ForceUnlock.
+func (l *lockStore) DeleteLock(ctx context.Context, lockId, owner string) error {
+	query := `DELETE FROM "` + l.table + `" WHERE lock_id = $1 AND ($2 = '' OR owner = $2)`
+	if _, err := l.db.ExecContext(ctx, query, lockId, owner); err != nil {
+		if e, ok := err.(*pq.Error); ok {
+			// 42P01 is "UndefinedTableError" in CockroachDB
+			// https://github.com/cockroachdb/cockroach/blob/master/pkg/sql/pgwire/pgerror/codes.go
+			if e.Code == "42P01" {
+				// If the lock table was removed, i.e. by a manual drop, this is a valid "unlocked" state for the schema
+				return nil
+			}
+		}
+		return &database.Error{OrigErr: err, Err: "failed to release migration lock", Query: []byte(query)}
+	}
+	return nil
+}
+
+func (l *lockStore) ReadLock(ctx context.Context, lockId string) (*database.LockInfo, error) {
+	query := `SELECT COALESCE(owner, ''), COALESCE(hostname, ''), COALESCE(pid, 0), acquired_at, heartbeat_at
+		FROM "` + l.table + `" WHERE lock_id = $1`
+	lock := database.LockInfo{LockId: lockId}
+	// locks of older versions have no heartbeat and are stale
+	var acquiredAt, heartbeatAt pq.NullTime
+	err := l.db.QueryRowContext(ctx, query, lockId).Scan(&lock.Owner, &lock.Hostname, &lock.Pid, &acquiredAt, &heartbeatAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, &database.Error{OrigErr: err, Err: "failed to fetch migration lock", Query: []byte(query)}
+	}
+	lock.AcquiredAt = acquiredAt.Time
+	lock.HeartbeatAt = heartbeatAt.Time
+	return &lock, nil
+}
+
+// exec runs query and reports if it affected a row.
+func (l *lockStore) exec(ctx context.Context, errMsg, query string, args ...interface{}) (bool, error) {
+	res, err := l.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, &database.Error{OrigErr: err, Err: errMsg, Query: []byte(query)}
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
@@ -4,6 +4,7 @@ package cockroachdb
 
 import (
 	//"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
@@ -89,3 +90,40 @@ func TestFilterCustomQuery(t *testing.T) {
 			}
 		})
 }
+
+func TestForceUnlock(t *testing.T) {
+	mt.ParallelTest(t, versions, isReady,
+		func(t *testing.T, i mt.Instance) {
+			c := &CockroachDb{}
+			addr := fmt.Sprintf("cockroach://root@%v:%v/migrate?sslmode=disable", i.Host(), i.PortFor(26257))
+			d, err := c.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := d.Lock(); err != nil {
+				t.Fatal(err)
+			}
+
+			// another process sees who holds the lock and removes it
+			d2, err := c.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := d2.Lock(); err == nil {
+				t.Fatal("expected the lock to be held")
+			}
+			held, err := d2.(*CockroachDb).ForceUnlock(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if held == nil || held.Pid == 0 || len(held.Hostname) == 0 {
+				t.Fatalf("expected the holder of the lock, got %v", held)
+			}
+			if err := d2.Lock(); err != nil {
+				t.Fatal(err)
+			}
+			if err := d2.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+		})
+}
@@ -5,6 +5,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"io"
 	nurl "net/url"
@@ -53,7 +54,9 @@ type Driver interface {
 	// Lock should acquire a database lock so that only one migration process
 	// can run at a time. Migrate will call this function before Run is called.
 	// If the implementation can't provide this functionality, return nil.
-	// Return database.ErrLocked if database is already locked.
+	// Return database.ErrLockHeld if the lock is held by someone else,
+	// don't wait for the lock. Migrate tries again until its LockTimeout
+	// expires. Return database.ErrLocked if the driver holds the lock itself.
 	Lock() error
 
 	// Unlock should release the lock. Migrate will call this function after
@@ -77,6 +80,30 @@ type Driver interface {
 	Drop() error
 }
 
+// DriverContext is an optional interface a database driver can implement
+// to support cancellation and deadlines. If a driver implements it,
+// Migrate prefers these functions over the ones defined in Driver.
+// The context should be passed down to the underlying database client
+// (i.e. db.ExecContext) wherever possible.
+type DriverContext interface {
+	// LockContext is like Lock, but aborts waiting for the lock
+	// when ctx is done.
+	LockContext(ctx context.Context) error
+
+	// RunContext is like Run. If ctx is done while the migration is
+	// running, the driver should cancel the running statement.
+	RunContext(ctx context.Context, migration io.Reader) error
+
+	// SetVersionContext is like SetVersion.
+	SetVersionContext(ctx context.Context, version int, dirty bool) error
+
+	// VersionContext is like Version.
+	VersionContext(ctx context.Context) (version int, dirty bool, err error)
+
+	// DropContext is like Drop.
+	DropContext(ctx context.Context) error
+}
+
 // Open returns a new driver instance.
 func Open(url string) (Driver, error) {
 	u, err := nurl.Parse(url)
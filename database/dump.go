@@ -0,0 +1,16 @@
+package database
+
+import (
+	"context"
+	"io"
+)
+
+// Dumper is an optional interface a database driver can implement
+// to dump the schema of the database, like Rails' structure.sql.
+type Dumper interface {
+	// Dump writes the DDL of all objects in the database to w.
+	// Objects are written in a stable order, so that dumps of equal
+	// schemas are equal and can be diffed. The migrations table and
+	// the history table are not included.
+	Dump(ctx context.Context, w io.Writer) error
+}
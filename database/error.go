@@ -2,14 +2,19 @@ package database
 
 import (
 	"fmt"
+	"unicode/utf8"
 )
 
-// Error should be used for errors involving queries ran against the database
+// Error should be used for errors involving queries ran against the database.
+// Drivers return it as *Error.
 type Error struct {
 	// Optional: the line number
 	Line uint
 
-	// Query is a query excerpt
+	// Optional: the column number, counted in runes
+	Column uint
+
+	// Query is a query excerpt, Line and Column refer to it
 	Query []byte
 
 	// Err is a useful/helping error message for humans
@@ -19,9 +24,63 @@ type Error struct {
 	OrigErr error
 }
 
-func (e Error) Error() string {
+// Error implements the error interface.
+func (e *Error) Error() string {
 	if len(e.Err) == 0 {
-		return fmt.Sprintf("%v in line %v: %s", e.OrigErr, e.Line, e.Query)
+		return fmt.Sprintf("%v in %v: %s", e.OrigErr, e.Position(), e.Query)
+	}
+	return fmt.Sprintf("%v in %v: %s (details: %v)", e.Err, e.Position(), e.Query, e.OrigErr)
+}
+
+// Position returns the line and, if known, the column as a string.
+func (e *Error) Position() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("line %v", e.Line)
+	}
+	return fmt.Sprintf("line %v, column %v", e.Line, e.Column)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.OrigErr
+}
+
+// LineColumn returns the line and column, both starting at 1, of the
+// byte offset in query. The column is counted in runes.
+func LineColumn(query []byte, offset int) (line, column uint) {
+	if offset > len(query) {
+		offset = len(query)
 	}
-	return fmt.Sprintf("%v in line %v: %s (details: %v)", e.Err, e.Line, e.Query, e.OrigErr)
+
+	line = 1
+	lineStart := 0
+	for i := 0; i < offset; i++ {
+		if query[i] == '\n' {
+			line++
+			lineStart = i + 1
+		}
+	}
+	return line, uint(utf8.RuneCount(query[lineStart:offset])) + 1
+}
+
+// ErrLockHeld is returned by Lock if the lock is held by another
+// session.
+type ErrLockHeld struct {
+	// Holder describes who holds the lock, i.e. a connection id or
+	// user@host. It is empty if the driver can't tell.
+	Holder string
+}
+
+// Error implements the error interface.
+func (e ErrLockHeld) Error() string {
+	if len(e.Holder) == 0 {
+		return ErrLocked.Error()
+	}
+	return fmt.Sprintf("%v: held by %v", ErrLocked, e.Holder)
+}
+
+// Is reports if target is ErrLocked. With Go 1.13 and later,
+// errors.Is uses it to match ErrLockHeld against ErrLocked.
+func (e ErrLockHeld) Is(target error) bool {
+	return target == ErrLocked
 }
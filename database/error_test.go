@@ -0,0 +1,49 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestLineColumn(t *testing.T) {
+	query := []byte("SELECT 1;\nSELECT 'ä', x;\n")
+
+	tt := []struct {
+		offset int
+		line   uint
+		column uint
+	}{
+		{0, 1, 1},
+		{7, 1, 8},
+		{10, 2, 1},
+		{22, 2, 12},
+		{100, 3, 1},
+	}
+
+	for _, tc := range tt {
+		line, column := LineColumn(query, tc.offset)
+		if line != tc.line || column != tc.column {
+			t.Errorf("offset %v: expected line %v, column %v, got line %v, column %v",
+				tc.offset, tc.line, tc.column, line, column)
+		}
+	}
+}
+
+func TestErrLockHeld(t *testing.T) {
+	tt := []struct {
+		holder   string
+		expected string
+	}{
+		{"", "can't acquire lock"},
+		{"pid 42 (postgres@local)", "can't acquire lock: held by pid 42 (postgres@local)"},
+	}
+
+	for _, tc := range tt {
+		err := ErrLockHeld{Holder: tc.holder}
+		if err.Error() != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, err.Error())
+		}
+		if !err.Is(ErrLocked) {
+			t.Errorf("expected %v to be ErrLocked", err)
+		}
+	}
+}
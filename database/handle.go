@@ -0,0 +1,16 @@
+package database
+
+// Handler is an optional interface a database driver can implement to
+// give Go migrations access to its underlying connection.
+type Handler interface {
+	// Handle returns the connection, i.e. *sql.DB.
+	Handle() interface{}
+}
+
+// TxHandler is an optional interface an AtomicDriver can implement to
+// give Go migrations access to the transaction started by Begin.
+// Without it, Go migrations can't run as atomic migrations.
+type TxHandler interface {
+	// TxHandle returns the transaction started by Begin, i.e. *sql.Tx.
+	TxHandle() interface{}
+}
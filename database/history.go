@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNoHistory = fmt.Errorf("no migration history")
+)
+
+// Direction describes in which direction a migration was applied.
+type Direction string
+
+const (
+	DirectionUp   Direction = "up"
+	DirectionDown Direction = "down"
+
+	// DirectionRepeatable is recorded for repeatable migrations,
+	// with the version that was active when they ran.
+	DirectionRepeatable Direction = "repeatable"
+)
+
+// HistoryEntry describes a single migration that was applied to
+// the database.
+type HistoryEntry struct {
+	// Version is the version of the migration.
+	Version uint
+
+	// Direction is either DirectionUp, DirectionDown or DirectionRepeatable.
+	Direction Direction
+
+	// Identifier is the identifier of the migration in the source.
+	Identifier string
+
+	// Checksum is the hex encoded SHA-256 checksum of the migration body.
+	// It is empty for migrations without a body.
+	Checksum string
+
+	// StartedAt is the time when the migration started running.
+	StartedAt time.Time
+
+	// FinishedAt is the time when the migration finished running.
+	FinishedAt time.Time
+
+	// Duration is the time it took to run the migration.
+	Duration time.Duration
+
+	// AppliedBy describes who ran the migration, i.e. user@hostname.
+	AppliedBy string
+}
+
+// HistoryDriver is an optional interface a database driver can implement
+// to keep a record of every applied migration, next to the single
+// version managed by SetVersion.
+//
+// Keeping a history is opt-in. Drivers should only record entries
+// if the user enabled the history, i.e. by providing the name of
+// a history table in the URL or Config{}.
+type HistoryDriver interface {
+	// AppendHistory appends entry to the migration history.
+	// Migrate will call this function after each successfully run migration.
+	// If the driver implements AtomicDriver, it is called in the same
+	// transaction as the migration.
+	// If the history is not enabled, return nil.
+	AppendHistory(entry HistoryEntry) error
+
+	// History returns all entries of the migration history, oldest first.
+	// If the history is not enabled, return database.ErrNoHistory.
+	History() ([]HistoryEntry, error)
+}
+
+// HistoryDriverContext is an optional interface a HistoryDriver can
+// implement to support cancellation and deadlines, like DriverContext.
+type HistoryDriverContext interface {
+	// AppendHistoryContext is like AppendHistory.
+	AppendHistoryContext(ctx context.Context, entry HistoryEntry) error
+
+	// HistoryContext is like History.
+	HistoryContext(ctx context.Context) ([]HistoryEntry, error)
+}
@@ -0,0 +1,305 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultLockStaleAfter is the time after which a lock in a lock table
+// expires, if its holder stopped refreshing it.
+var DefaultLockStaleAfter = time.Minute
+
+var (
+	ErrLockLost = fmt.Errorf("lock was lost while held, it expired or was removed")
+)
+
+// LockInfo is a lock in a lock table and describes who holds it.
+type LockInfo struct {
+	// LockId identifies the locked database, see GenerateAdvisoryLockId.
+	LockId string
+
+	// Owner identifies a single acquisition of the lock.
+	Owner string
+
+	// Hostname and Pid describe the process holding the lock.
+	Hostname string
+	Pid      int
+
+	// AcquiredAt is the time when the lock was acquired.
+	AcquiredAt time.Time
+
+	// HeartbeatAt is the time when the holder last refreshed the lock.
+	HeartbeatAt time.Time
+}
+
+// String describes the holder of the lock.
+func (l LockInfo) String() string {
+	return fmt.Sprintf("pid %v on %v since %v (last heartbeat %v)",
+		l.Pid, l.Hostname, l.AcquiredAt.Format(time.RFC3339), l.HeartbeatAt.Format(time.RFC3339))
+}
+
+// Stale reports if the lock wasn't refreshed within staleAfter before now.
+func (l LockInfo) Stale(now time.Time, staleAfter time.Duration) bool {
+	return now.Sub(l.HeartbeatAt) > staleAfter
+}
+
+// LockStore is implemented by database drivers to keep the rows of a
+// lock table for LockTable. Each lock is a single row, identified by LockId.
+type LockStore interface {
+	// InsertLock inserts lock, if there is no lock with the same LockId.
+	// It reports if lock was inserted.
+	InsertLock(ctx context.Context, lock LockInfo) (bool, error)
+
+	// ReplaceLock replaces the lock with the same LockId as lock, if it is
+	// still held by oldOwner. It reports if the lock was replaced.
+	ReplaceLock(ctx context.Context, oldOwner string, lock LockInfo) (bool, error)
+
+	// UpdateHeartbeat sets HeartbeatAt of the lock lockId, if it is
+	// still held by owner. It reports if the lock was updated.
+	UpdateHeartbeat(ctx context.Context, lockId, owner string, heartbeatAt time.Time) (bool, error)
+
+	// DeleteLock deletes the lock lockId if it is held by owner,
+	// or regardless of who holds it, if owner is empty.
+	DeleteLock(ctx context.Context, lockId, owner string) error
+
+	// ReadLock returns the lock lockId, or nil if it isn't held.
+	ReadLock(ctx context.Context, lockId string) (*LockInfo, error)
+}
+
+// LockTableDriver is an optional interface a database driver can implement
+// if it keeps its lock in a lock table, like with LockTable. Unlike advisory
+// locks, such locks outlive the process holding them, so a lock left behind
+// can be inspected and removed.
+type LockTableDriver interface {
+	// LockHolder returns the lock, or nil if it isn't held.
+	LockHolder(ctx context.Context) (*LockInfo, error)
+
+	// ForceUnlock removes the lock regardless of who holds it.
+	// It returns the removed lock, or nil if it wasn't held.
+	ForceUnlock(ctx context.Context) (*LockInfo, error)
+}
+
+// LockLostDriver is an optional interface a database driver can implement
+// if its lock can be lost while it is held, like with LockTable.
+// Migrate stops with ErrLockLost before running the next migration then.
+type LockLostDriver interface {
+	// LockLost returns a channel that is closed if the lock was lost
+	// while it was held.
+	LockLost() <-chan struct{}
+}
+
+// LockTable implements locking with a lock table, for databases without
+// advisory locks. The lock records the hostname and pid of its holder,
+// and is refreshed in the background while it is held. A lock that wasn't
+// refreshed for StaleAfter, i.e. because its holder crashed, expires and
+// can be taken over. Heartbeats use the local clock, so the clocks of all
+// hosts running migrations must be roughly in sync.
+type LockTable struct {
+	// Store keeps the lock table.
+	Store LockStore
+
+	// LockId identifies the locked database.
+	LockId string
+
+	// StaleAfter defaults to DefaultLockStaleAfter.
+	StaleAfter time.Duration
+
+	// HeartbeatInterval defaults to a quarter of StaleAfter.
+	HeartbeatInterval time.Duration
+
+	// Force takes over the lock even if it isn't stale.
+	Force bool
+
+	mu     sync.Mutex
+	owner  string
+	stop   chan bool
+	done   chan bool
+	lost   bool
+	lostCh chan struct{}
+}
+
+// NewLockTable returns a LockTable for the lock lockId in store.
+func NewLockTable(store LockStore, lockId string, staleAfter time.Duration) *LockTable {
+	return &LockTable{
+		Store:      store,
+		LockId:     lockId,
+		StaleAfter: staleAfter,
+	}
+}
+
+func (l *LockTable) staleAfter() time.Duration {
+	if l.StaleAfter <= 0 {
+		return DefaultLockStaleAfter
+	}
+	return l.StaleAfter
+}
+
+func (l *LockTable) heartbeatInterval() time.Duration {
+	if l.HeartbeatInterval <= 0 {
+		return l.staleAfter() / 4
+	}
+	return l.HeartbeatInterval
+}
+
+// Lock acquires the lock, taking it over if it is stale.
+// It returns ErrLockHeld if the lock is held by someone else,
+// or ErrLocked if it is held by l itself.
+func (l *LockTable) Lock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.owner) > 0 {
+		return ErrLocked
+	}
+
+	owner, err := newLockOwner()
+	if err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	// retry if the lock changes between reading and replacing it
+	for i := 0; ; i++ {
+		now := time.Now().UTC()
+		lock := LockInfo{
+			LockId:      l.LockId,
+			Owner:       owner,
+			Hostname:    hostname,
+			Pid:         os.Getpid(),
+			AcquiredAt:  now,
+			HeartbeatAt: now,
+		}
+
+		ok, err := l.Store.InsertLock(ctx, lock)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			held, err := l.Store.ReadLock(ctx, l.LockId)
+			if err != nil {
+				return err
+			}
+			if held != nil && !l.Force && !held.Stale(now, l.staleAfter()) {
+				return ErrLockHeld{Holder: held.String()}
+			}
+			if held != nil {
+				if ok, err = l.Store.ReplaceLock(ctx, held.Owner, lock); err != nil {
+					return err
+				}
+			}
+			if !ok && i < 3 {
+				continue
+			}
+			if !ok {
+				return ErrLockHeld{}
+			}
+		}
+
+		l.owner = owner
+		l.lost = false
+		l.lostCh = make(chan struct{})
+		l.stop = make(chan bool)
+		l.done = make(chan bool)
+		go l.heartbeat(owner, l.stop, l.done, l.lostCh)
+		return nil
+	}
+}
+
+// heartbeat refreshes the lock until stop is closed.
+// It closes lost if the lock was lost.
+func (l *LockTable) heartbeat(owner string, stop, done chan bool, lost chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(l.heartbeatInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// errors are retried with the next tick, until the lock expires
+			ok, err := l.Store.UpdateHeartbeat(context.Background(), l.LockId, owner, time.Now().UTC())
+			if err == nil && !ok {
+				l.mu.Lock()
+				l.lost = true
+				l.mu.Unlock()
+				close(lost)
+				return
+			}
+		}
+	}
+}
+
+// Unlock releases the lock. It returns ErrLockLost if the lock
+// expired or was removed while it was held. If the lock can't be
+// removed, l keeps holding it and Unlock can be called again.
+func (l *LockTable) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	if len(l.owner) == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	// stop is nil if a previous Unlock failed to remove the lock
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	done := l.done
+	l.mu.Unlock()
+	<-done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Store.DeleteLock(ctx, l.LockId, l.owner); err != nil {
+		return err
+	}
+	l.owner = ""
+	if l.lost {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Lost returns a channel that is closed if the lock expired or was
+// removed while l held it, so the holder can stop before doing more
+// work without the lock. Unlock returns ErrLockLost then.
+func (l *LockTable) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lostCh
+}
+
+// Holder returns the lock, or nil if it isn't held.
+func (l *LockTable) Holder(ctx context.Context) (*LockInfo, error) {
+	return l.Store.ReadLock(ctx, l.LockId)
+}
+
+// ForceUnlock removes the lock regardless of who holds it.
+// It returns the removed lock, or nil if it wasn't held.
+func (l *LockTable) ForceUnlock(ctx context.Context) (*LockInfo, error) {
+	held, err := l.Store.ReadLock(ctx, l.LockId)
+	if err != nil || held == nil {
+		return nil, err
+	}
+	if err := l.Store.DeleteLock(ctx, l.LockId, ""); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+// newLockOwner returns a random id for a single acquisition of a lock.
+func newLockOwner() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
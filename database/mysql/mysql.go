@@ -1,6 +1,8 @@
 package mysql
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
@@ -8,12 +10,14 @@ import (
 	"io"
 	"io/ioutil"
 	nurl "net/url"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/mattes/migrate"
 	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/database/sqlsplit"
 )
 
 func init() {
@@ -32,6 +36,9 @@ var (
 type Config struct {
 	MigrationsTable string
 	DatabaseName    string
+
+	// HistoryTable enables the migration history if not empty.
+	HistoryTable string
 }
 
 type Mysql struct {
@@ -76,6 +83,10 @@ func WithInstance(instance *sql.DB, config *Config) (database.Driver, error) {
 		return nil, err
 	}
 
+	if err := mx.ensureHistoryTable(); err != nil {
+		return nil, err
+	}
+
 	return mx, nil
 }
 
@@ -139,6 +150,7 @@ func (m *Mysql) Open(url string) (database.Driver, error) {
 	mx, err := WithInstance(db, &Config{
 		DatabaseName:    purl.Path,
 		MigrationsTable: migrationsTable,
+		HistoryTable:    purl.Query().Get("x-history-table"),
 	})
 	if err != nil {
 		return nil, err
@@ -151,7 +163,16 @@ func (m *Mysql) Close() error {
 	return m.db.Close()
 }
 
+// Handle implements database.Handler. It returns the *sql.DB.
+func (m *Mysql) Handle() interface{} {
+	return m.db
+}
+
 func (m *Mysql) Lock() error {
+	return m.LockContext(context.Background())
+}
+
+func (m *Mysql) LockContext(ctx context.Context) error {
 	if m.isLocked {
 		return database.ErrLocked
 	}
@@ -164,7 +185,7 @@ func (m *Mysql) Lock() error {
 
 	query := "SELECT GET_LOCK(?, 1)"
 	var success bool
-	if err := m.db.QueryRow(query, aid).Scan(&success); err != nil {
+	if err := m.db.QueryRowContext(ctx, query, aid).Scan(&success); err != nil {
 		return &database.Error{OrigErr: err, Err: "try lock failed", Query: []byte(query)}
 	}
 
@@ -173,7 +194,24 @@ func (m *Mysql) Lock() error {
 		return nil
 	}
 
-	return database.ErrLocked
+	return database.ErrLockHeld{Holder: m.lockHolder(ctx, aid)}
+}
+
+// lockHolder returns the connection holding the lock aid,
+// or an empty string if it can't be found.
+func (m *Mysql) lockHolder(ctx context.Context, aid string) string {
+	var id int64
+	query := "SELECT IFNULL(IS_USED_LOCK(?), 0)"
+	if err := m.db.QueryRowContext(ctx, query, aid).Scan(&id); err != nil || id == 0 {
+		return ""
+	}
+
+	var user, host string
+	query = "SELECT USER, HOST FROM information_schema.PROCESSLIST WHERE ID = ?"
+	if err := m.db.QueryRowContext(ctx, query, id).Scan(&user, &host); err != nil {
+		return fmt.Sprintf("connection %v", id)
+	}
+	return fmt.Sprintf("connection %v (%v@%v)", id, user, host)
 }
 
 func (m *Mysql) Unlock() error {
@@ -197,33 +235,42 @@ func (m *Mysql) Unlock() error {
 }
 
 func (m *Mysql) Run(migration io.Reader) error {
+	return m.RunContext(context.Background(), migration)
+}
+
+func (m *Mysql) RunContext(ctx context.Context, migration io.Reader) error {
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
 	}
 
 	query := string(migr[:])
-	if _, err := m.db.Exec(query); err != nil {
-		return database.Error{OrigErr: err, Err: "migration failed", Query: migr}
+	if _, err := m.db.ExecContext(ctx, query); err != nil {
+		line, column := errorPosition(err, migr)
+		return &database.Error{OrigErr: err, Err: "migration failed", Line: line, Column: column, Query: migr}
 	}
 
 	return nil
 }
 
 func (m *Mysql) SetVersion(version int, dirty bool) error {
-	tx, err := m.db.Begin()
+	return m.SetVersionContext(context.Background(), version, dirty)
+}
+
+func (m *Mysql) SetVersionContext(ctx context.Context, version int, dirty bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &database.Error{OrigErr: err, Err: "transaction start failed"}
 	}
 
 	query := "TRUNCATE `" + m.config.MigrationsTable + "`"
-	if _, err := m.db.Exec(query); err != nil {
+	if _, err := m.db.ExecContext(ctx, query); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 
 	if version >= 0 {
 		query := "INSERT INTO `" + m.config.MigrationsTable + "` (version, dirty) VALUES (?, ?)"
-		if _, err := m.db.Exec(query, version, dirty); err != nil {
+		if _, err := m.db.ExecContext(ctx, query, version, dirty); err != nil {
 			tx.Rollback()
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
@@ -237,8 +284,12 @@ func (m *Mysql) SetVersion(version int, dirty bool) error {
 }
 
 func (m *Mysql) Version() (version int, dirty bool, err error) {
+	return m.VersionContext(context.Background())
+}
+
+func (m *Mysql) VersionContext(ctx context.Context) (version int, dirty bool, err error) {
 	query := "SELECT version, dirty FROM `" + m.config.MigrationsTable + "` LIMIT 1"
-	err = m.db.QueryRow(query).Scan(&version, &dirty)
+	err = m.db.QueryRowContext(ctx, query).Scan(&version, &dirty)
 	switch {
 	case err == sql.ErrNoRows:
 		return database.NilVersion, false, nil
@@ -257,9 +308,13 @@ func (m *Mysql) Version() (version int, dirty bool, err error) {
 }
 
 func (m *Mysql) Drop() error {
+	return m.DropContext(context.Background())
+}
+
+func (m *Mysql) DropContext(ctx context.Context) error {
 	// select all tables
 	query := `SHOW TABLES LIKE '%'`
-	tables, err := m.db.Query(query)
+	tables, err := m.db.QueryContext(ctx, query)
 	if err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
@@ -281,18 +336,74 @@ func (m *Mysql) Drop() error {
 		// delete one by one ...
 		for _, t := range tableNames {
 			query = "DROP TABLE IF EXISTS `" + t + "` CASCADE"
-			if _, err := m.db.Exec(query); err != nil {
+			if _, err := m.db.ExecContext(ctx, query); err != nil {
 				return &database.Error{OrigErr: err, Query: []byte(query)}
 			}
 		}
 		if err := m.ensureVersionTable(); err != nil {
 			return err
 		}
+		if err := m.ensureHistoryTable(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func (m *Mysql) AppendHistory(entry database.HistoryEntry) error {
+	return m.AppendHistoryContext(context.Background(), entry)
+}
+
+func (m *Mysql) AppendHistoryContext(ctx context.Context, entry database.HistoryEntry) error {
+	if len(m.config.HistoryTable) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO `" + m.config.HistoryTable + "` (version, direction, identifier, checksum, started_at, finished_at, duration, applied_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	if _, err := m.db.ExecContext(ctx, query, int64(entry.Version), string(entry.Direction), entry.Identifier, entry.Checksum,
+		entry.StartedAt.UTC(), entry.FinishedAt.UTC(), int64(entry.Duration), entry.AppliedBy); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+func (m *Mysql) History() ([]database.HistoryEntry, error) {
+	return m.HistoryContext(context.Background())
+}
+
+func (m *Mysql) HistoryContext(ctx context.Context) ([]database.HistoryEntry, error) {
+	if len(m.config.HistoryTable) == 0 {
+		return nil, database.ErrNoHistory
+	}
+
+	query := "SELECT version, direction, identifier, checksum, started_at, finished_at, duration, applied_by FROM `" + m.config.HistoryTable + "` ORDER BY id"
+	rows, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	entries := make([]database.HistoryEntry, 0)
+	for rows.Next() {
+		var e database.HistoryEntry
+		// use mysql.NullTime, so this works without parseTime=true, too
+		var startedAt, finishedAt mysql.NullTime
+		if err := rows.Scan(&e.Version, &e.Direction, &e.Identifier, &e.Checksum,
+			&startedAt, &finishedAt, &e.Duration, &e.AppliedBy); err != nil {
+			return nil, err
+		}
+		e.StartedAt = startedAt.Time
+		e.FinishedAt = finishedAt.Time
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	return entries, nil
+}
+
 func (m *Mysql) ensureVersionTable() error {
 	// check if migration table exists
 	var result string
@@ -313,6 +424,27 @@ func (m *Mysql) ensureVersionTable() error {
 	return nil
 }
 
+func (m *Mysql) ensureHistoryTable() error {
+	if len(m.config.HistoryTable) == 0 {
+		return nil
+	}
+
+	query := "CREATE TABLE IF NOT EXISTS `" + m.config.HistoryTable + "` (" +
+		"id bigint not null auto_increment primary key, " +
+		"version bigint not null, " +
+		"direction varchar(16) not null, " +
+		"identifier text not null, " +
+		"checksum varchar(64) not null, " +
+		"started_at datetime not null, " +
+		"finished_at datetime not null, " +
+		"duration bigint not null, " +
+		"applied_by text not null)"
+	if _, err := m.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
 // Returns the bool value of the input.
 // The 2nd return value indicates if the input was a valid bool value
 // See https://github.com/go-sql-driver/mysql/blob/a059889267dc7170331388008528b3b44479bffb/utils.go#L71
@@ -327,3 +459,171 @@ func readBool(input string) (value bool, valid bool) {
 	// Not a valid bool value
 	return
 }
+
+// syntaxErrorPosition matches the position in mysql syntax errors, i.e.
+// "... near 'TABLEE users' at line 1"
+var syntaxErrorPosition = regexp.MustCompile(`(?s)near '(.*)' at line (\d+)$`)
+
+// errorPosition returns the line and column in migr of the position
+// reported by a mysql syntax error, or zero if there is none.
+func errorPosition(err error, migr []byte) (line, column uint) {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return 0, 0
+	}
+
+	match := syntaxErrorPosition.FindStringSubmatch(mysqlErr.Message)
+	if match == nil {
+		return 0, 0
+	}
+
+	l, err := strconv.ParseUint(match[2], 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+
+	// the excerpt starts where the error is, and the line is relative
+	// to the failing statement
+	if len(match[1]) > 0 {
+		if i := statementPosition(migr, []byte(match[1]), uint(l)); i >= 0 {
+			return database.LineColumn(migr, i)
+		}
+	}
+	return uint(l), 0
+}
+
+// statementPosition returns the offset in migr of excerpt, searching each
+// statement from its offset. A statement in which excerpt is in line
+// (relative to the statement) is preferred over the first one containing it.
+// It returns -1 if no statement contains excerpt.
+func statementPosition(migr []byte, excerpt []byte, line uint) int {
+	stmts := sqlsplit.Split(migr, sqlsplit.MySQL)
+	first := -1
+	for i, stmt := range stmts {
+		end := len(migr)
+		if i+1 < len(stmts) {
+			end = stmts[i+1].Offset
+		}
+		j := bytes.Index(migr[stmt.Offset:], excerpt)
+		if j < 0 || stmt.Offset+j >= end {
+			continue
+		}
+		if l, _ := database.LineColumn(migr[stmt.Offset:], j); l == line {
+			return stmt.Offset + j
+		}
+		if first < 0 {
+			first = stmt.Offset + j
+		}
+	}
+	return first
+}
+
+// definer matches the DEFINER clause of views, triggers and routines,
+// which depends on the user running the migrations.
+var definer = regexp.MustCompile(` DEFINER=\S+`)
+
+// autoIncrement matches the AUTO_INCREMENT table option, which
+// depends on the data.
+var autoIncrement = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// Dump implements database.Dumper. It writes tables, views, triggers
+// and routines of the current database.
+func (m *Mysql) Dump(ctx context.Context, w io.Writer) error {
+	query := `SELECT table_name, table_type FROM information_schema.tables
+		WHERE table_schema = DATABASE() ORDER BY table_type, table_name`
+	tables, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	showQueries := make([]string, 0)
+	for tables.Next() {
+		var name, tableType string
+		if err := tables.Scan(&name, &tableType); err != nil {
+			tables.Close()
+			return err
+		}
+		if name == m.config.MigrationsTable || name == m.config.HistoryTable {
+			continue
+		}
+		if tableType == "VIEW" {
+			showQueries = append(showQueries, "SHOW CREATE VIEW `"+name+"`")
+		} else {
+			showQueries = append(showQueries, "SHOW CREATE TABLE `"+name+"`")
+		}
+	}
+	tables.Close()
+	if err := tables.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	query = `SELECT 'TRIGGER', trigger_name FROM information_schema.triggers
+		WHERE trigger_schema = DATABASE()
+		UNION ALL
+		SELECT routine_type, routine_name FROM information_schema.routines
+		WHERE routine_schema = DATABASE()
+		ORDER BY 1 DESC, 2`
+	objects, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	for objects.Next() {
+		var objectType, name string
+		if err := objects.Scan(&objectType, &name); err != nil {
+			objects.Close()
+			return err
+		}
+		showQueries = append(showQueries, "SHOW CREATE "+objectType+" `"+name+"`")
+	}
+	objects.Close()
+	if err := objects.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	for _, query := range showQueries {
+		def, err := m.showCreate(ctx, query)
+		if err != nil {
+			return err
+		}
+		def = definer.ReplaceAllString(def, "")
+		def = autoIncrement.ReplaceAllString(def, "")
+		fmt.Fprintf(w, "%s;\n\n", def)
+	}
+	return nil
+}
+
+// showCreate runs a SHOW CREATE query and returns the statement,
+// found in the first column starting with "Create" or "SQL Original Statement".
+func (m *Mysql) showCreate(ctx context.Context, query string) (string, error) {
+	rows, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return "", &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		return "", &database.Error{OrigErr: sql.ErrNoRows, Query: []byte(query)}
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", err
+	}
+
+	for i, column := range columns {
+		if strings.HasPrefix(column, "Create") || column == "SQL Original Statement" {
+			return values[i].String, nil
+		}
+	}
+	return "", fmt.Errorf("no statement returned by %v", query)
+}
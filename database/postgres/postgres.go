@@ -1,11 +1,15 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
 	"io/ioutil"
 	nurl "net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/lib/pq"
 	"github.com/mattes/migrate"
@@ -21,21 +25,46 @@ func init() {
 var DefaultMigrationsTable = "schema_migrations"
 
 var (
-	ErrNilConfig      = fmt.Errorf("no config")
-	ErrNoDatabaseName = fmt.Errorf("no database name")
-	ErrNoSchema       = fmt.Errorf("no schema")
-	ErrDatabaseDirty  = fmt.Errorf("database is dirty")
+	ErrNilConfig          = fmt.Errorf("no config")
+	ErrNoDatabaseName     = fmt.Errorf("no database name")
+	ErrNoSchema           = fmt.Errorf("no schema")
+	ErrDatabaseDirty      = fmt.Errorf("database is dirty")
+	ErrTransactionStarted = fmt.Errorf("transaction already started")
 )
 
 type Config struct {
 	MigrationsTable string
 	DatabaseName    string
+
+	// HistoryTable enables the migration history if not empty.
+	HistoryTable string
+
+	// SchemaName is the schema to migrate, if not empty. It must be the
+	// current schema of the connection, Open sets the search_path for the
+	// `x-schema` URL parameter. The lock is always taken for the current
+	// schema only, so several schemas of a database can be migrated at
+	// the same time, and a schema takes the same lock with and without
+	// SchemaName.
+	SchemaName string
+}
+
+// conn is implemented by *sql.DB and *sql.Tx.
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 type Postgres struct {
 	db       *sql.DB
 	isLocked bool
 
+	// tx is the transaction started by Begin
+	tx *sql.Tx
+
+	// schemaName is the current schema, see Config.SchemaName
+	schemaName string
+
 	// Open and WithInstance need to garantuee that config is never nil
 	config *Config
 }
@@ -61,19 +90,33 @@ func WithInstance(instance *sql.DB, config *Config) (database.Driver, error) {
 
 	config.DatabaseName = databaseName
 
+	query = `SELECT COALESCE(CURRENT_SCHEMA(), '')`
+	var schemaName string
+	if err := instance.QueryRow(query).Scan(&schemaName); err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	if len(config.SchemaName) > 0 && schemaName != config.SchemaName {
+		return nil, fmt.Errorf("schema %v doesn't exist or isn't the current schema", config.SchemaName)
+	}
+
 	if len(config.MigrationsTable) == 0 {
 		config.MigrationsTable = DefaultMigrationsTable
 	}
 
 	px := &Postgres{
-		db:     instance,
-		config: config,
+		db:         instance,
+		config:     config,
+		schemaName: schemaName,
 	}
 
 	if err := px.ensureVersionTable(); err != nil {
 		return nil, err
 	}
 
+	if err := px.ensureHistoryTable(); err != nil {
+		return nil, err
+	}
+
 	return px, nil
 }
 
@@ -83,6 +126,13 @@ func (p *Postgres) Open(url string) (database.Driver, error) {
 		return nil, err
 	}
 
+	schemaName := purl.Query().Get("x-schema")
+	if len(schemaName) > 0 {
+		q := purl.Query()
+		q.Set("search_path", pq.QuoteIdentifier(schemaName))
+		purl.RawQuery = q.Encode()
+	}
+
 	db, err := sql.Open("postgres", migrate.FilterCustomQuery(purl).String())
 	if err != nil {
 		return nil, err
@@ -96,6 +146,8 @@ func (p *Postgres) Open(url string) (database.Driver, error) {
 	px, err := WithInstance(db, &Config{
 		DatabaseName:    purl.Path,
 		MigrationsTable: migrationsTable,
+		HistoryTable:    purl.Query().Get("x-history-table"),
+		SchemaName:      schemaName,
 	})
 	if err != nil {
 		return nil, err
@@ -104,17 +156,49 @@ func (p *Postgres) Open(url string) (database.Driver, error) {
 	return px, nil
 }
 
+// Schemas implements database.SchemaDriver.
+func (p *Postgres) Schemas(ctx context.Context, url string, pattern string) ([]string, error) {
+	query := `SELECT nspname FROM pg_namespace WHERE nspname LIKE $1 ORDER BY nspname`
+	return queryURL(ctx, url, query, pattern)
+}
+
+// QuerySchemas implements database.SchemaDriver.
+func (p *Postgres) QuerySchemas(ctx context.Context, url string, query string) ([]string, error) {
+	return queryURL(ctx, url, query)
+}
+
+// queryURL returns the first column of query, run against the database at url.
+func queryURL(ctx context.Context, url string, query string, args ...interface{}) ([]string, error) {
+	purl, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", migrate.FilterCustomQuery(purl).String())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	px := &Postgres{db: db}
+	return px.queryStrings(ctx, query, args...)
+}
+
 func (p *Postgres) Close() error {
 	return p.db.Close()
 }
 
 // https://www.postgresql.org/docs/9.6/static/explicit-locking.html#ADVISORY-LOCKS
 func (p *Postgres) Lock() error {
+	return p.LockContext(context.Background())
+}
+
+func (p *Postgres) LockContext(ctx context.Context) error {
 	if p.isLocked {
 		return database.ErrLocked
 	}
 
-	aid, err := database.GenerateAdvisoryLockId(p.config.DatabaseName)
+	aid, err := database.GenerateAdvisoryLockId(p.config.DatabaseName, p.schemaName)
 	if err != nil {
 		return err
 	}
@@ -123,7 +207,7 @@ func (p *Postgres) Lock() error {
 	// or return false if the lock cannot be acquired immediately.
 	query := `SELECT pg_try_advisory_lock($1)`
 	var success bool
-	if err := p.db.QueryRow(query, aid).Scan(&success); err != nil {
+	if err := p.db.QueryRowContext(ctx, query, aid).Scan(&success); err != nil {
 		return &database.Error{OrigErr: err, Err: "try lock failed", Query: []byte(query)}
 	}
 
@@ -132,7 +216,23 @@ func (p *Postgres) Lock() error {
 		return nil
 	}
 
-	return database.ErrLocked
+	return database.ErrLockHeld{Holder: p.lockHolder(ctx, aid)}
+}
+
+// lockHolder returns the session holding the advisory lock aid,
+// or an empty string if it can't be found.
+func (p *Postgres) lockHolder(ctx context.Context, aid string) string {
+	// a bigint advisory lock is split into classid and objid
+	query := `SELECT a.pid, COALESCE(a.usename, ''), COALESCE(host(a.client_addr), 'local')
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+		AND (l.classid::bigint << 32 | l.objid::bigint) = $1::bigint`
+	var pid int
+	var user, host string
+	if err := p.db.QueryRowContext(ctx, query, aid).Scan(&pid, &user, &host); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("pid %v (%v@%v)", pid, user, host)
 }
 
 func (p *Postgres) Unlock() error {
@@ -140,7 +240,7 @@ func (p *Postgres) Unlock() error {
 		return nil
 	}
 
-	aid, err := database.GenerateAdvisoryLockId(p.config.DatabaseName)
+	aid, err := database.GenerateAdvisoryLockId(p.config.DatabaseName, p.schemaName)
 	if err != nil {
 		return err
 	}
@@ -154,6 +254,10 @@ func (p *Postgres) Unlock() error {
 }
 
 func (p *Postgres) Run(migration io.Reader) error {
+	return p.RunContext(context.Background(), migration)
+}
+
+func (p *Postgres) RunContext(ctx context.Context, migration io.Reader) error {
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
@@ -161,44 +265,154 @@ func (p *Postgres) Run(migration io.Reader) error {
 
 	// run migration
 	query := string(migr[:])
-	if _, err := p.db.Exec(query); err != nil {
-		// TODO: cast to postgress error and get line number
-		return database.Error{OrigErr: err, Err: "migration failed", Query: migr}
+	if _, err := p.conn().ExecContext(ctx, query); err != nil {
+		line, column := errorPosition(err, migr)
+		return &database.Error{OrigErr: err, Err: "migration failed", Line: line, Column: column, Query: migr}
 	}
 
 	return nil
 }
 
 func (p *Postgres) SetVersion(version int, dirty bool) error {
-	tx, err := p.db.Begin()
+	return p.SetVersionContext(context.Background(), version, dirty)
+}
+
+func (p *Postgres) SetVersionContext(ctx context.Context, version int, dirty bool) error {
+	if p.tx != nil {
+		return p.setVersion(ctx, p.tx, version, dirty)
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &database.Error{OrigErr: err, Err: "transaction start failed"}
 	}
 
-	query := `TRUNCATE "` + p.config.MigrationsTable + `"`
-	if _, err := tx.Exec(query); err != nil {
+	if err := p.setVersion(ctx, tx, version, dirty); err != nil {
 		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+	}
+
+	return nil
+}
+
+// RunWithVersion implements database.TransactionalDriver.
+func (p *Postgres) RunWithVersion(ctx context.Context, migration io.Reader, version int) error {
+	migr, err := ioutil.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction start failed"}
+	}
+
+	if _, err := tx.ExecContext(ctx, string(migr)); err != nil {
+		tx.Rollback()
+		line, column := errorPosition(err, migr)
+		return &database.Error{OrigErr: err, Err: "migration failed", Line: line, Column: column, Query: migr}
+	}
+
+	if err := p.setVersion(ctx, tx, version, false); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+	}
+
+	return nil
+}
+
+// Begin implements database.AtomicDriver.
+func (p *Postgres) Begin(ctx context.Context) error {
+	if p.tx != nil {
+		return ErrTransactionStarted
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction start failed"}
+	}
+	p.tx = tx
+	return nil
+}
+
+// Commit implements database.AtomicDriver.
+func (p *Postgres) Commit() error {
+	if p.tx == nil {
+		return nil
+	}
+
+	tx := p.tx
+	p.tx = nil
+	if err := tx.Commit(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+	}
+	return nil
+}
+
+// Rollback implements database.AtomicDriver.
+func (p *Postgres) Rollback() error {
+	if p.tx == nil {
+		return nil
+	}
+
+	tx := p.tx
+	p.tx = nil
+	if err := tx.Rollback(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction rollback failed"}
+	}
+	return nil
+}
+
+// Handle implements database.Handler. It returns the *sql.DB.
+func (p *Postgres) Handle() interface{} {
+	return p.db
+}
+
+// TxHandle implements database.TxHandler. It returns the *sql.Tx
+// started by Begin.
+func (p *Postgres) TxHandle() interface{} {
+	return p.tx
+}
+
+// conn returns the transaction started by Begin, if any, or the database.
+func (p *Postgres) conn() conn {
+	if p.tx != nil {
+		return p.tx
+	}
+	return p.db
+}
+
+func (p *Postgres) setVersion(ctx context.Context, tx *sql.Tx, version int, dirty bool) error {
+	query := `TRUNCATE "` + p.config.MigrationsTable + `"`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 
 	if version >= 0 {
 		query = `INSERT INTO "` + p.config.MigrationsTable + `" (version, dirty) VALUES ($1, $2)`
-		if _, err := tx.Exec(query, version, dirty); err != nil {
-			tx.Rollback()
+		if _, err := tx.ExecContext(ctx, query, version, dirty); err != nil {
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
-	}
-
 	return nil
 }
 
 func (p *Postgres) Version() (version int, dirty bool, err error) {
+	return p.VersionContext(context.Background())
+}
+
+func (p *Postgres) VersionContext(ctx context.Context) (version int, dirty bool, err error) {
 	query := `SELECT version, dirty FROM "` + p.config.MigrationsTable + `" LIMIT 1`
-	err = p.db.QueryRow(query).Scan(&version, &dirty)
+	err = p.conn().QueryRowContext(ctx, query).Scan(&version, &dirty)
 	switch {
 	case err == sql.ErrNoRows:
 		return database.NilVersion, false, nil
@@ -217,9 +431,13 @@ func (p *Postgres) Version() (version int, dirty bool, err error) {
 }
 
 func (p *Postgres) Drop() error {
+	return p.DropContext(context.Background())
+}
+
+func (p *Postgres) DropContext(ctx context.Context) error {
 	// select all tables in current schema
 	query := `SELECT table_name FROM information_schema.tables WHERE table_schema=(SELECT current_schema())`
-	tables, err := p.db.Query(query)
+	tables, err := p.db.QueryContext(ctx, query)
 	if err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
@@ -241,18 +459,70 @@ func (p *Postgres) Drop() error {
 		// delete one by one ...
 		for _, t := range tableNames {
 			query = `DROP TABLE IF EXISTS ` + t + ` CASCADE`
-			if _, err := p.db.Exec(query); err != nil {
+			if _, err := p.db.ExecContext(ctx, query); err != nil {
 				return &database.Error{OrigErr: err, Query: []byte(query)}
 			}
 		}
 		if err := p.ensureVersionTable(); err != nil {
 			return err
 		}
+		if err := p.ensureHistoryTable(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func (p *Postgres) AppendHistory(entry database.HistoryEntry) error {
+	return p.AppendHistoryContext(context.Background(), entry)
+}
+
+func (p *Postgres) AppendHistoryContext(ctx context.Context, entry database.HistoryEntry) error {
+	if len(p.config.HistoryTable) == 0 {
+		return nil
+	}
+
+	query := `INSERT INTO "` + p.config.HistoryTable + `" (version, direction, identifier, checksum, started_at, finished_at, duration, applied_by) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	if _, err := p.conn().ExecContext(ctx, query, int64(entry.Version), string(entry.Direction), entry.Identifier, entry.Checksum,
+		entry.StartedAt, entry.FinishedAt, int64(entry.Duration), entry.AppliedBy); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+func (p *Postgres) History() ([]database.HistoryEntry, error) {
+	return p.HistoryContext(context.Background())
+}
+
+func (p *Postgres) HistoryContext(ctx context.Context) ([]database.HistoryEntry, error) {
+	if len(p.config.HistoryTable) == 0 {
+		return nil, database.ErrNoHistory
+	}
+
+	query := `SELECT version, direction, identifier, checksum, started_at, finished_at, duration, applied_by FROM "` + p.config.HistoryTable + `" ORDER BY id`
+	rows, err := p.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	entries := make([]database.HistoryEntry, 0)
+	for rows.Next() {
+		var e database.HistoryEntry
+		if err := rows.Scan(&e.Version, &e.Direction, &e.Identifier, &e.Checksum,
+			&e.StartedAt, &e.FinishedAt, &e.Duration, &e.AppliedBy); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	return entries, nil
+}
+
 func (p *Postgres) ensureVersionTable() error {
 	// check if migration table exists
 	var count int
@@ -271,3 +541,240 @@ func (p *Postgres) ensureVersionTable() error {
 	}
 	return nil
 }
+
+func (p *Postgres) ensureHistoryTable() error {
+	if len(p.config.HistoryTable) == 0 {
+		return nil
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS "` + p.config.HistoryTable + `" (
+		id bigserial primary key,
+		version bigint not null,
+		direction varchar(16) not null,
+		identifier text not null,
+		checksum varchar(64) not null,
+		started_at timestamp with time zone not null,
+		finished_at timestamp with time zone not null,
+		duration bigint not null,
+		applied_by text not null)`
+	if _, err := p.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+// errorPosition returns the line and column in migr of the position
+// reported by a postgres error, or zero if there is none.
+func errorPosition(err error, migr []byte) (line, column uint) {
+	pqErr, ok := err.(*pq.Error)
+	if !ok || len(pqErr.Position) == 0 {
+		return 0, 0
+	}
+
+	// Position counts characters, starting at 1
+	pos, err := strconv.Atoi(pqErr.Position)
+	if err != nil || pos < 1 {
+		return 0, 0
+	}
+
+	offset := 0
+	for i := 1; i < pos && offset < len(migr); i++ {
+		_, size := utf8.DecodeRune(migr[offset:])
+		offset += size
+	}
+	return database.LineColumn(migr, offset)
+}
+
+// Dump implements database.Dumper. It writes sequences, tables with their
+// constraints, indexes and triggers, views and functions of the current schema.
+func (p *Postgres) Dump(ctx context.Context, w io.Writer) error {
+	for _, dump := range []func(context.Context, io.Writer) error{
+		p.dumpSequences,
+		p.dumpTables,
+		p.dumpViews,
+		p.dumpFunctions,
+	} {
+		if err := dump(ctx, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpSequences dumps all sequences, except the ones owned by
+// the migrations table or the history table.
+func (p *Postgres) dumpSequences(ctx context.Context, w io.Writer) error {
+	query := `SELECT c.relname FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relkind = 'S'
+		AND NOT EXISTS (SELECT 1 FROM pg_depend d JOIN pg_class t ON t.oid = d.refobjid
+			WHERE d.objid = c.oid AND d.deptype = 'a' AND t.relname IN ($1, $2))
+		ORDER BY c.relname`
+	names, err := p.queryStrings(ctx, query, p.config.MigrationsTable, p.config.HistoryTable)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintf(w, "CREATE SEQUENCE \"%s\";\n\n", name)
+	}
+	return nil
+}
+
+func (p *Postgres) dumpTables(ctx context.Context, w io.Writer) error {
+	query := `SELECT c.oid, c.relname FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relkind = 'r'
+		ORDER BY c.relname`
+	rows, err := p.conn().QueryContext(ctx, query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	type table struct {
+		oid  int64
+		name string
+	}
+	tables := make([]table, 0)
+	for rows.Next() {
+		var t table
+		if err := rows.Scan(&t.oid, &t.name); err != nil {
+			rows.Close()
+			return err
+		}
+		if t.name != p.config.MigrationsTable && t.name != p.config.HistoryTable {
+			tables = append(tables, t)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	for _, t := range tables {
+		if err := p.dumpTable(ctx, w, t.oid, t.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpTable dumps a table with its constraints, indexes and triggers.
+func (p *Postgres) dumpTable(ctx context.Context, w io.Writer, oid int64, name string) error {
+	query := `SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+		COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`
+	rows, err := p.conn().QueryContext(ctx, query, oid)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defs := make([]string, 0)
+	for rows.Next() {
+		var column, dataType, defaultValue string
+		var notNull bool
+		if err := rows.Scan(&column, &dataType, &notNull, &defaultValue); err != nil {
+			rows.Close()
+			return err
+		}
+		def := fmt.Sprintf("\"%s\" %s", column, dataType)
+		if notNull {
+			def += " NOT NULL"
+		}
+		if len(defaultValue) > 0 {
+			def += " DEFAULT " + defaultValue
+		}
+		defs = append(defs, def)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	query = `SELECT 'CONSTRAINT "' || conname || '" ' || pg_get_constraintdef(oid)
+		FROM pg_constraint WHERE conrelid = $1 AND contype != 'n' ORDER BY conname`
+	constraints, err := p.queryStrings(ctx, query, oid)
+	if err != nil {
+		return err
+	}
+	defs = append(defs, constraints...)
+
+	fmt.Fprintf(w, "CREATE TABLE \"%s\" (\n    %s\n);\n\n", name, strings.Join(defs, ",\n    "))
+
+	// indexes of constraints are created by the constraints
+	query = `SELECT pg_get_indexdef(i.indexrelid) FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE i.indrelid = $1
+		AND NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conindid = i.indexrelid)
+		ORDER BY c.relname`
+	indexes, err := p.queryStrings(ctx, query, oid)
+	if err != nil {
+		return err
+	}
+
+	query = `SELECT pg_get_triggerdef(oid) FROM pg_trigger
+		WHERE tgrelid = $1 AND NOT tgisinternal ORDER BY tgname`
+	triggers, err := p.queryStrings(ctx, query, oid)
+	if err != nil {
+		return err
+	}
+
+	for _, def := range append(indexes, triggers...) {
+		fmt.Fprintf(w, "%s;\n\n", def)
+	}
+	return nil
+}
+
+func (p *Postgres) dumpViews(ctx context.Context, w io.Writer) error {
+	query := `SELECT 'CREATE VIEW "' || c.relname || '" AS' || chr(10) || pg_get_viewdef(c.oid)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relkind = 'v'
+		ORDER BY c.relname`
+	views, err := p.queryStrings(ctx, query)
+	if err != nil {
+		return err
+	}
+	for _, def := range views {
+		fmt.Fprintf(w, "%s\n\n", strings.TrimSpace(def))
+	}
+	return nil
+}
+
+func (p *Postgres) dumpFunctions(ctx context.Context, w io.Writer) error {
+	query := `SELECT pg_get_functiondef(p.oid) FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE n.nspname = current_schema()
+		AND NOT EXISTS (SELECT 1 FROM pg_aggregate WHERE aggfnoid = p.oid)
+		ORDER BY p.proname, pg_get_function_identity_arguments(p.oid)`
+	functions, err := p.queryStrings(ctx, query)
+	if err != nil {
+		return err
+	}
+	for _, def := range functions {
+		fmt.Fprintf(w, "%s;\n\n", strings.TrimSpace(def))
+	}
+	return nil
+}
+
+// queryStrings returns the first column of all rows returned by query.
+func (p *Postgres) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := p.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	strs := make([]string, 0)
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return strs, nil
+}
@@ -4,12 +4,15 @@ package postgres
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/lib/pq"
+	"github.com/mattes/migrate/database"
 	dt "github.com/mattes/migrate/database/testing"
 	mt "github.com/mattes/migrate/testing"
 )
@@ -54,6 +57,19 @@ func Test(t *testing.T) {
 		})
 }
 
+func TestHistory(t *testing.T) {
+	mt.ParallelTest(t, versions, isReady,
+		func(t *testing.T, i mt.Instance) {
+			p := &Postgres{}
+			addr := fmt.Sprintf("postgres://postgres@%v:%v/postgres?sslmode=disable&x-history-table=schema_history", i.Host(), i.Port())
+			d, err := p.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			dt.TestHistory(t, d.(database.HistoryDriver))
+		})
+}
+
 func TestMultiStatement(t *testing.T) {
 	mt.ParallelTest(t, versions, isReady,
 		func(t *testing.T, i mt.Instance) {
@@ -78,6 +94,55 @@ func TestMultiStatement(t *testing.T) {
 		})
 }
 
+func TestErrorPosition(t *testing.T) {
+	mt.ParallelTest(t, versions, isReady,
+		func(t *testing.T, i mt.Instance) {
+			p := &Postgres{}
+			addr := fmt.Sprintf("postgres://postgres@%v:%v/postgres?sslmode=disable", i.Host(), i.Port())
+			d, err := p.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			err = d.Run(bytes.NewReader([]byte("SELECT 1;\nCREATE TABLEE foo (foo text);")))
+			e, ok := err.(*database.Error)
+			if !ok {
+				t.Fatalf("expected *database.Error, got %v", err)
+			}
+			if e.Line != 2 || e.Column != 8 {
+				t.Fatalf("expected error in line 2, column 8, got line %v, column %v", e.Line, e.Column)
+			}
+		})
+}
+
+func TestDump(t *testing.T) {
+	mt.ParallelTest(t, versions, isReady,
+		func(t *testing.T, i mt.Instance) {
+			p := &Postgres{}
+			addr := fmt.Sprintf("postgres://postgres@%v:%v/postgres?sslmode=disable", i.Host(), i.Port())
+			d, err := p.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (id serial primary key, name text not null default 'x'); CREATE INDEX foo_name ON foo (name); CREATE VIEW bar AS SELECT name FROM foo;"))); err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+			if err := d.(database.Dumper).Dump(context.Background(), &b); err != nil {
+				t.Fatal(err)
+			}
+			dump := b.String()
+			for _, expected := range []string{`CREATE TABLE "foo"`, `"name" text NOT NULL DEFAULT 'x'::text`, `PRIMARY KEY (id)`, `CREATE INDEX foo_name`, `CREATE VIEW "bar"`} {
+				if !strings.Contains(dump, expected) {
+					t.Errorf("expected dump to contain %q, got %v", expected, dump)
+				}
+			}
+			if strings.Contains(dump, "schema_migrations") {
+				t.Errorf("expected dump not to contain the migrations table, got %v", dump)
+			}
+		})
+}
+
 func TestFilterCustomQuery(t *testing.T) {
 	mt.ParallelTest(t, versions, isReady,
 		func(t *testing.T, i mt.Instance) {
@@ -145,6 +210,68 @@ func TestWithSchema(t *testing.T) {
 		})
 }
 
+func TestSchemaParameter(t *testing.T) {
+	mt.ParallelTest(t, versions, isReady,
+		func(t *testing.T, i mt.Instance) {
+			p := &Postgres{}
+			addr := fmt.Sprintf("postgres://postgres@%v:%v/postgres?sslmode=disable", i.Host(), i.Port())
+			d, err := p.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := d.Run(bytes.NewReader([]byte("CREATE SCHEMA tenant_a; CREATE SCHEMA tenant_b"))); err != nil {
+				t.Fatal(err)
+			}
+
+			schemas, err := p.Schemas(context.Background(), addr, "tenant_%")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(schemas) != 2 || schemas[0] != "tenant_a" || schemas[1] != "tenant_b" {
+				t.Fatalf("expected tenant_a and tenant_b, got %v", schemas)
+			}
+
+			// both schemas can be locked at the same time
+			a, err := p.Open(addr + "&x-schema=tenant_a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := p.Open(addr + "&x-schema=tenant_b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Lock(); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Lock(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := a.SetVersion(2, false); err != nil {
+				t.Fatal(err)
+			}
+			if version, _, err := b.Version(); err != nil || version != database.NilVersion {
+				t.Fatalf("expected NilVersion for tenant_b, got %v, %v", version, err)
+			}
+
+			if _, err := p.Open(addr + "&x-schema=tenant_c"); err == nil {
+				t.Fatal("expected error for missing schema")
+			}
+
+			// the current schema takes the same lock with and without x-schema
+			public, err := p.Open(addr + "&x-schema=public")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := public.Lock(); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := d.Lock().(database.ErrLockHeld); !ok {
+				t.Fatal("expected the lock of the public schema to be held")
+			}
+		})
+}
+
 func TestWithInstance(t *testing.T) {
 
 }
@@ -1,6 +1,7 @@
 package ql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
@@ -106,8 +107,11 @@ func (m *Ql) Close() error {
 	return m.db.Close()
 }
 func (m *Ql) Drop() error {
+	return m.DropContext(context.Background())
+}
+func (m *Ql) DropContext(ctx context.Context) error {
 	query := `SELECT Name FROM __Table`
-	tables, err := m.db.Query(query)
+	tables, err := m.db.QueryContext(ctx, query)
 	if err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
@@ -127,7 +131,7 @@ func (m *Ql) Drop() error {
 	if len(tableNames) > 0 {
 		for _, t := range tableNames {
 			query := "DROP TABLE " + t
-			err = m.executeQuery(query)
+			err = m.executeQuery(ctx, query)
 			if err != nil {
 				return &database.Error{OrigErr: err, Query: []byte(query)}
 			}
@@ -139,7 +143,15 @@ func (m *Ql) Drop() error {
 
 	return nil
 }
+// Handle implements database.Handler. It returns the *sql.DB.
+func (m *Ql) Handle() interface{} {
+	return m.db
+}
+
 func (m *Ql) Lock() error {
+	return m.LockContext(context.Background())
+}
+func (m *Ql) LockContext(ctx context.Context) error {
 	if m.isLocked {
 		return database.ErrLocked
 	}
@@ -154,20 +166,23 @@ func (m *Ql) Unlock() error {
 	return nil
 }
 func (m *Ql) Run(migration io.Reader) error {
+	return m.RunContext(context.Background(), migration)
+}
+func (m *Ql) RunContext(ctx context.Context, migration io.Reader) error {
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
 	}
 	query := string(migr[:])
 
-	return m.executeQuery(query)
+	return m.executeQuery(ctx, query)
 }
-func (m *Ql) executeQuery(query string) error {
-	tx, err := m.db.Begin()
+func (m *Ql) executeQuery(ctx context.Context, query string) error {
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &database.Error{OrigErr: err, Err: "transaction start failed"}
 	}
-	if _, err := tx.Exec(query); err != nil {
+	if _, err := tx.ExecContext(ctx, query); err != nil {
 		tx.Rollback()
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
@@ -177,19 +192,22 @@ func (m *Ql) executeQuery(query string) error {
 	return nil
 }
 func (m *Ql) SetVersion(version int, dirty bool) error {
-	tx, err := m.db.Begin()
+	return m.SetVersionContext(context.Background(), version, dirty)
+}
+func (m *Ql) SetVersionContext(ctx context.Context, version int, dirty bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &database.Error{OrigErr: err, Err: "transaction start failed"}
 	}
 
 	query := "TRUNCATE TABLE " + m.config.MigrationsTable
-	if _, err := tx.Exec(query); err != nil {
+	if _, err := tx.ExecContext(ctx, query); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 
 	if version >= 0 {
 		query := fmt.Sprintf(`INSERT INTO %s (version, dirty) VALUES (%d, %t)`, m.config.MigrationsTable, version, dirty)
-		if _, err := tx.Exec(query); err != nil {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
 			tx.Rollback()
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
@@ -203,10 +221,64 @@ func (m *Ql) SetVersion(version int, dirty bool) error {
 }
 
 func (m *Ql) Version() (version int, dirty bool, err error) {
+	return m.VersionContext(context.Background())
+}
+
+func (m *Ql) VersionContext(ctx context.Context) (version int, dirty bool, err error) {
 	query := "SELECT version, dirty FROM " + m.config.MigrationsTable + " LIMIT 1"
-	err = m.db.QueryRow(query).Scan(&version, &dirty)
+	err = m.db.QueryRowContext(ctx, query).Scan(&version, &dirty)
 	if err != nil {
 		return database.NilVersion, false, nil
 	}
 	return version, dirty, nil
 }
+
+// Dump implements database.Dumper. It writes tables and indexes
+// as they are stored in the __Table and __Index system tables.
+func (m *Ql) Dump(ctx context.Context, w io.Writer) error {
+	query := `SELECT Name, Schema FROM __Table ORDER BY Name`
+	tables, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer tables.Close()
+	for tables.Next() {
+		var name, schema string
+		if err := tables.Scan(&name, &schema); err != nil {
+			return err
+		}
+		if name == m.config.MigrationsTable || strings.HasPrefix(name, "__") {
+			continue
+		}
+		fmt.Fprintf(w, "%s;\n\n", strings.TrimRight(strings.TrimSpace(schema), ";"))
+	}
+	if err := tables.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	query = `SELECT Name, TableName, ColumnName, IsUnique FROM __Index ORDER BY Name`
+	indexes, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer indexes.Close()
+	for indexes.Next() {
+		var name, table, column string
+		var unique bool
+		if err := indexes.Scan(&name, &table, &column, &unique); err != nil {
+			return err
+		}
+		if table == m.config.MigrationsTable {
+			continue
+		}
+		if unique {
+			fmt.Fprintf(w, "CREATE UNIQUE INDEX %s ON %s (%s);\n\n", name, table, column)
+		} else {
+			fmt.Fprintf(w, "CREATE INDEX %s ON %s (%s);\n\n", name, table, column)
+		}
+	}
+	if err := indexes.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package redshift
 
 import (
+	"context"
+	"io"
 	"net/url"
 
 	"github.com/mattes/migrate/database"
@@ -44,3 +46,26 @@ func (driver *Redshift) Lock() error { return nil }
 
 // Unlock implements the database.Driver interface by not unlocking and returning nil.
 func (driver *Redshift) Unlock() error { return nil }
+
+// LockContext implements the database.DriverContext interface by not locking and returning nil.
+func (driver *Redshift) LockContext(ctx context.Context) error { return nil }
+
+// RunContext implements the database.DriverContext interface by delegating to the underlying PostgreSQL driver.
+func (driver *Redshift) RunContext(ctx context.Context, migration io.Reader) error {
+	return driver.Driver.(database.DriverContext).RunContext(ctx, migration)
+}
+
+// SetVersionContext implements the database.DriverContext interface by delegating to the underlying PostgreSQL driver.
+func (driver *Redshift) SetVersionContext(ctx context.Context, version int, dirty bool) error {
+	return driver.Driver.(database.DriverContext).SetVersionContext(ctx, version, dirty)
+}
+
+// VersionContext implements the database.DriverContext interface by delegating to the underlying PostgreSQL driver.
+func (driver *Redshift) VersionContext(ctx context.Context) (version int, dirty bool, err error) {
+	return driver.Driver.(database.DriverContext).VersionContext(ctx)
+}
+
+// DropContext implements the database.DriverContext interface by delegating to the underlying PostgreSQL driver.
+func (driver *Redshift) DropContext(ctx context.Context) error {
+	return driver.Driver.(database.DriverContext).DropContext(ctx)
+}
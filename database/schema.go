@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	nurl "net/url"
+)
+
+// SchemaDriver is an optional interface a database driver can implement if
+// it can migrate each schema of a database independently, with its own
+// migrations table, i.e. one schema per tenant. Instances for a schema are
+// opened with the `x-schema` URL parameter. The functions are called on the
+// registered driver, without opening an instance.
+type SchemaDriver interface {
+	// Schemas returns the names of the schemas of the database at url
+	// matching pattern, with % and _ as wildcards like in SQL LIKE,
+	// ordered by name.
+	Schemas(ctx context.Context, url string, pattern string) ([]string, error)
+
+	// QuerySchemas returns the values of the first column of the
+	// rows returned by query as schema names.
+	QuerySchemas(ctx context.Context, url string, query string) ([]string, error)
+}
+
+// GetSchemaDriver returns the registered driver for the scheme of url,
+// if it implements SchemaDriver.
+func GetSchemaDriver(url string) (SchemaDriver, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	driversMu.RLock()
+	d, ok := drivers[u.Scheme]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("database driver: unknown driver %v (forgotten import?)", u.Scheme)
+	}
+
+	sd, ok := d.(SchemaDriver)
+	if !ok {
+		return nil, fmt.Errorf("database driver: %v doesn't support schemas", u.Scheme)
+	}
+	return sd, nil
+}
+
+// SchemaURL returns url with the `x-schema` parameter set to schema.
+func SchemaURL(url string, schema string) (string, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("x-schema", schema)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
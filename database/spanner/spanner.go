@@ -1,6 +1,7 @@
 package spanner
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,13 +10,12 @@ import (
 	"regexp"
 	"strings"
 
-	"golang.org/x/net/context"
-
 	"cloud.google.com/go/spanner"
 	sdb "cloud.google.com/go/spanner/admin/database/apiv1"
 
 	"github.com/mattes/migrate"
 	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/database/sqlsplit"
 
 	"google.golang.org/api/iterator"
 	adminpb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
@@ -118,12 +118,22 @@ func (s *Spanner) Close() error {
 	return s.db.admin.Close()
 }
 
+// Handle implements database.Handler. It returns the *spanner.Client.
+func (s *Spanner) Handle() interface{} {
+	return s.db.data
+}
+
 // Lock implements database.Driver but doesn't do anything because Spanner only
 // enqueues the UpdateDatabaseDdlRequest.
 func (s *Spanner) Lock() error {
 	return nil
 }
 
+// LockContext implements database.DriverContext, see Lock.
+func (s *Spanner) LockContext(ctx context.Context) error {
+	return nil
+}
+
 // Unlock implements database.Driver but no action required, see Lock.
 func (s *Spanner) Unlock() error {
 	return nil
@@ -131,6 +141,11 @@ func (s *Spanner) Unlock() error {
 
 // Run implements database.Driver
 func (s *Spanner) Run(migration io.Reader) error {
+	return s.RunContext(context.Background(), migration)
+}
+
+// RunContext implements database.DriverContext
+func (s *Spanner) RunContext(ctx context.Context, migration io.Reader) error {
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
@@ -138,7 +153,6 @@ func (s *Spanner) Run(migration io.Reader) error {
 
 	// run migration
 	stmts := migrationStatements(migr)
-	ctx := context.Background()
 
 	op, err := s.db.admin.UpdateDatabaseDdl(ctx, &adminpb.UpdateDatabaseDdlRequest{
 		Database:   s.config.DatabaseName,
@@ -158,7 +172,11 @@ func (s *Spanner) Run(migration io.Reader) error {
 
 // SetVersion implements database.Driver
 func (s *Spanner) SetVersion(version int, dirty bool) error {
-	ctx := context.Background()
+	return s.SetVersionContext(context.Background(), version, dirty)
+}
+
+// SetVersionContext implements database.DriverContext
+func (s *Spanner) SetVersionContext(ctx context.Context, version int, dirty bool) error {
 
 	_, err := s.db.data.ReadWriteTransaction(ctx,
 		func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
@@ -179,7 +197,11 @@ func (s *Spanner) SetVersion(version int, dirty bool) error {
 
 // Version implements database.Driver
 func (s *Spanner) Version() (version int, dirty bool, err error) {
-	ctx := context.Background()
+	return s.VersionContext(context.Background())
+}
+
+// VersionContext implements database.DriverContext
+func (s *Spanner) VersionContext(ctx context.Context) (version int, dirty bool, err error) {
 
 	stmt := spanner.Statement{
 		SQL: `SELECT Version, Dirty FROM ` + s.config.MigrationsTable + ` LIMIT 1`,
@@ -211,7 +233,11 @@ func (s *Spanner) Version() (version int, dirty bool, err error) {
 // be "build up", it seems logical to "unbuild" the database simply by going the
 // opposite direction. More testing
 func (s *Spanner) Drop() error {
-	ctx := context.Background()
+	return s.DropContext(context.Background())
+}
+
+// DropContext implements database.DriverContext
+func (s *Spanner) DropContext(ctx context.Context) error {
 	res, err := s.db.admin.GetDatabaseDdl(ctx, &adminpb.GetDatabaseDdlRequest{
 		Database: s.config.DatabaseName,
 	})
@@ -284,11 +310,10 @@ func (s *Spanner) ensureVersionTable() error {
 }
 
 func migrationStatements(migration []byte) []string {
-	regex := regexp.MustCompile(";$")
-	migrationString := string(migration[:])
-	migrationString = strings.TrimSpace(migrationString)
-	migrationString = regex.ReplaceAllString(migrationString, "")
-
-	statements := strings.Split(migrationString, ";")
+	stmts := sqlsplit.Split(migration, sqlsplit.Spanner)
+	statements := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		statements = append(statements, stmt.Query)
+	}
 	return statements
 }
@@ -1,10 +1,12 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/mattes/migrate"
 	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/database/sqlsplit"
 	_ "github.com/mattn/go-sqlite3"
 	"io"
 	"io/ioutil"
@@ -18,20 +20,34 @@ func init() {
 
 var DefaultMigrationsTable = "schema_migrations"
 var (
-	ErrDatabaseDirty  = fmt.Errorf("database is dirty")
-	ErrNilConfig      = fmt.Errorf("no config")
-	ErrNoDatabaseName = fmt.Errorf("no database name")
+	ErrDatabaseDirty      = fmt.Errorf("database is dirty")
+	ErrNilConfig          = fmt.Errorf("no config")
+	ErrNoDatabaseName     = fmt.Errorf("no database name")
+	ErrTransactionStarted = fmt.Errorf("transaction already started")
 )
 
 type Config struct {
 	MigrationsTable string
 	DatabaseName    string
+
+	// HistoryTable enables the migration history if not empty.
+	HistoryTable string
+}
+
+// conn is implemented by *sql.DB and *sql.Tx.
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 type Sqlite struct {
 	db       *sql.DB
 	isLocked bool
 
+	// tx is the transaction started by Begin
+	tx *sql.Tx
+
 	config *Config
 }
 
@@ -54,6 +70,9 @@ func WithInstance(instance *sql.DB, config *Config) (database.Driver, error) {
 	if err := mx.ensureVersionTable(); err != nil {
 		return nil, err
 	}
+	if err := mx.ensureHistoryTable(); err != nil {
+		return nil, err
+	}
 	return mx, nil
 }
 
@@ -70,6 +89,30 @@ func (m *Sqlite) ensureVersionTable() error {
 	return nil
 }
 
+func (m *Sqlite) ensureHistoryTable() error {
+	if len(m.config.HistoryTable) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id integer primary key autoincrement,
+		version uint64 not null,
+		direction text not null,
+		identifier text not null,
+		checksum text not null,
+		started_at datetime not null,
+		finished_at datetime not null,
+		duration integer not null,
+		applied_by text not null);
+	`, m.config.HistoryTable)
+
+	if _, err := m.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
 func (m *Sqlite) Open(url string) (database.Driver, error) {
 	purl, err := nurl.Parse(url)
 	if err != nil {
@@ -88,6 +131,7 @@ func (m *Sqlite) Open(url string) (database.Driver, error) {
 	mx, err := WithInstance(db, &Config{
 		DatabaseName:    purl.Path,
 		MigrationsTable: migrationsTable,
+		HistoryTable:    purl.Query().Get("x-history-table"),
 	})
 	if err != nil {
 		return nil, err
@@ -100,8 +144,12 @@ func (m *Sqlite) Close() error {
 }
 
 func (m *Sqlite) Drop() error {
+	return m.DropContext(context.Background())
+}
+
+func (m *Sqlite) DropContext(ctx context.Context) error {
 	query := `SELECT name FROM sqlite_master WHERE type = 'table';`
-	tables, err := m.db.Query(query)
+	tables, err := m.db.QueryContext(ctx, query)
 	if err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
@@ -119,7 +167,7 @@ func (m *Sqlite) Drop() error {
 	if len(tableNames) > 0 {
 		for _, t := range tableNames {
 			query := "DROP TABLE " + t
-			err = m.executeQuery(query)
+			err = m.executeQuery(ctx, []byte(query))
 			if err != nil {
 				return &database.Error{OrigErr: err, Query: []byte(query)}
 			}
@@ -127,8 +175,11 @@ func (m *Sqlite) Drop() error {
 		if err := m.ensureVersionTable(); err != nil {
 			return err
 		}
+		if err := m.ensureHistoryTable(); err != nil {
+			return err
+		}
 		query := "VACUUM"
-		_, err = m.db.Query(query)
+		_, err = m.db.ExecContext(ctx, query)
 		if err != nil {
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
@@ -138,6 +189,10 @@ func (m *Sqlite) Drop() error {
 }
 
 func (m *Sqlite) Lock() error {
+	return m.LockContext(context.Background())
+}
+
+func (m *Sqlite) LockContext(ctx context.Context) error {
 	if m.isLocked {
 		return database.ErrLocked
 	}
@@ -154,23 +209,30 @@ func (m *Sqlite) Unlock() error {
 }
 
 func (m *Sqlite) Run(migration io.Reader) error {
+	return m.RunContext(context.Background(), migration)
+}
+
+func (m *Sqlite) RunContext(ctx context.Context, migration io.Reader) error {
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
 	}
-	query := string(migr[:])
 
-	return m.executeQuery(query)
+	return m.executeQuery(ctx, migr)
 }
 
-func (m *Sqlite) executeQuery(query string) error {
-	tx, err := m.db.Begin()
+func (m *Sqlite) executeQuery(ctx context.Context, query []byte) error {
+	if m.tx != nil {
+		return executeStatements(ctx, m.tx, query)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &database.Error{OrigErr: err, Err: "transaction start failed"}
 	}
-	if _, err := tx.Exec(query); err != nil {
+	if err := executeStatements(ctx, tx, query); err != nil {
 		tx.Rollback()
-		return &database.Error{OrigErr: err, Query: []byte(query)}
+		return err
 	}
 	if err := tx.Commit(); err != nil {
 		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
@@ -179,36 +241,230 @@ func (m *Sqlite) executeQuery(query string) error {
 }
 
 func (m *Sqlite) SetVersion(version int, dirty bool) error {
-	tx, err := m.db.Begin()
+	return m.SetVersionContext(context.Background(), version, dirty)
+}
+
+func (m *Sqlite) SetVersionContext(ctx context.Context, version int, dirty bool) error {
+	if m.tx != nil {
+		return m.setVersion(ctx, m.tx, version, dirty)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction start failed"}
+	}
+
+	if err := m.setVersion(ctx, tx, version, dirty); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+	}
+
+	return nil
+}
+
+// RunWithVersion implements database.TransactionalDriver.
+func (m *Sqlite) RunWithVersion(ctx context.Context, migration io.Reader, version int) error {
+	migr, err := ioutil.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction start failed"}
+	}
+	if err := executeStatements(ctx, tx, migr); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := m.setVersion(ctx, tx, version, false); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+	}
+	return nil
+}
+
+// executeStatements executes the statements of query one at a time,
+// so the position of a failing statement can be reported.
+func executeStatements(ctx context.Context, tx *sql.Tx, query []byte) error {
+	for _, stmt := range sqlsplit.Split(query, sqlsplit.SQLite) {
+		if _, err := tx.ExecContext(ctx, stmt.Query); err != nil {
+			return &database.Error{OrigErr: err, Line: stmt.Line, Column: stmt.Column, Query: query}
+		}
+	}
+	return nil
+}
+
+// Begin implements database.AtomicDriver.
+func (m *Sqlite) Begin(ctx context.Context) error {
+	if m.tx != nil {
+		return ErrTransactionStarted
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &database.Error{OrigErr: err, Err: "transaction start failed"}
 	}
+	m.tx = tx
+	return nil
+}
+
+// Commit implements database.AtomicDriver.
+func (m *Sqlite) Commit() error {
+	if m.tx == nil {
+		return nil
+	}
+
+	tx := m.tx
+	m.tx = nil
+	if err := tx.Commit(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+	}
+	return nil
+}
+
+// Rollback implements database.AtomicDriver.
+func (m *Sqlite) Rollback() error {
+	if m.tx == nil {
+		return nil
+	}
 
+	tx := m.tx
+	m.tx = nil
+	if err := tx.Rollback(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction rollback failed"}
+	}
+	return nil
+}
+
+// Handle implements database.Handler. It returns the *sql.DB.
+func (m *Sqlite) Handle() interface{} {
+	return m.db
+}
+
+// TxHandle implements database.TxHandler. It returns the *sql.Tx
+// started by Begin.
+func (m *Sqlite) TxHandle() interface{} {
+	return m.tx
+}
+
+// conn returns the transaction started by Begin, if any, or the database.
+func (m *Sqlite) conn() conn {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.db
+}
+
+func (m *Sqlite) setVersion(ctx context.Context, tx *sql.Tx, version int, dirty bool) error {
 	query := "DELETE FROM " + m.config.MigrationsTable
-	if _, err := tx.Exec(query); err != nil {
+	if _, err := tx.ExecContext(ctx, query); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
 
 	if version >= 0 {
 		query := fmt.Sprintf(`INSERT INTO %s (version, dirty) VALUES (%d, '%t')`, m.config.MigrationsTable, version, dirty)
-		if _, err := tx.Exec(query); err != nil {
-			tx.Rollback()
+		if _, err := tx.ExecContext(ctx, query); err != nil {
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
-	}
-
 	return nil
 }
 
 func (m *Sqlite) Version() (version int, dirty bool, err error) {
+	return m.VersionContext(context.Background())
+}
+
+func (m *Sqlite) VersionContext(ctx context.Context) (version int, dirty bool, err error) {
 	query := "SELECT version, dirty FROM " + m.config.MigrationsTable + " LIMIT 1"
-	err = m.db.QueryRow(query).Scan(&version, &dirty)
+	err = m.conn().QueryRowContext(ctx, query).Scan(&version, &dirty)
 	if err != nil {
 		return database.NilVersion, false, nil
 	}
 	return version, dirty, nil
 }
+
+func (m *Sqlite) AppendHistory(entry database.HistoryEntry) error {
+	return m.AppendHistoryContext(context.Background(), entry)
+}
+
+func (m *Sqlite) AppendHistoryContext(ctx context.Context, entry database.HistoryEntry) error {
+	if len(m.config.HistoryTable) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO " + m.config.HistoryTable + " (version, direction, identifier, checksum, started_at, finished_at, duration, applied_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	if _, err := m.conn().ExecContext(ctx, query, int64(entry.Version), string(entry.Direction), entry.Identifier, entry.Checksum,
+		entry.StartedAt, entry.FinishedAt, int64(entry.Duration), entry.AppliedBy); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+func (m *Sqlite) History() ([]database.HistoryEntry, error) {
+	return m.HistoryContext(context.Background())
+}
+
+func (m *Sqlite) HistoryContext(ctx context.Context) ([]database.HistoryEntry, error) {
+	if len(m.config.HistoryTable) == 0 {
+		return nil, database.ErrNoHistory
+	}
+
+	query := "SELECT version, direction, identifier, checksum, started_at, finished_at, duration, applied_by FROM " + m.config.HistoryTable + " ORDER BY id"
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	entries := make([]database.HistoryEntry, 0)
+	for rows.Next() {
+		var e database.HistoryEntry
+		if err := rows.Scan(&e.Version, &e.Direction, &e.Identifier, &e.Checksum,
+			&e.StartedAt, &e.FinishedAt, &e.Duration, &e.AppliedBy); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	return entries, nil
+}
+
+// Dump implements database.Dumper. It writes tables, indexes, views
+// and triggers as they are stored in sqlite_master.
+func (m *Sqlite) Dump(ctx context.Context, w io.Writer) error {
+	query := `SELECT tbl_name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, name`
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, def string
+		if err := rows.Scan(&table, &def); err != nil {
+			return err
+		}
+		if table == m.config.MigrationsTable || table == m.config.HistoryTable {
+			continue
+		}
+		fmt.Fprintf(w, "%s;\n\n", def)
+	}
+	if err := rows.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
@@ -1,15 +1,19 @@
 package sqlite3
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/database"
 	dt "github.com/mattes/migrate/database/testing"
 	_ "github.com/mattes/migrate/source/file"
 	_ "github.com/mattn/go-sqlite3"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -59,3 +63,77 @@ func Test(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3-driver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	p := &Sqlite{}
+	addr := fmt.Sprintf("sqlite3://%s?x-history-table=schema_history", filepath.Join(dir, "sqlite3.db"))
+	d, err := p.Open(addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	dt.TestHistory(t, d.(database.HistoryDriver))
+}
+
+func TestErrorPosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3-driver-test")
+	if err != nil {
+		return
+	}
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+	p := &Sqlite{}
+	addr := fmt.Sprintf("sqlite3://%s", filepath.Join(dir, "sqlite3.db"))
+	d, err := p.Open(addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer d.Close()
+
+	err = d.Run(strings.NewReader("CREATE TABLE t (Qty int);\n  INSERT INTO u VALUES (1);"))
+	e, ok := err.(*database.Error)
+	if !ok {
+		t.Fatalf("expected *database.Error, got %v", err)
+	}
+	if e.Line != 2 || e.Column != 3 {
+		t.Fatalf("expected error in line 2, column 3, got line %v, column %v", e.Line, e.Column)
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite3-driver-test")
+	if err != nil {
+		return
+	}
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+	p := &Sqlite{}
+	addr := fmt.Sprintf("sqlite3://%s", filepath.Join(dir, "sqlite3.db"))
+	d, err := p.Open(addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer d.Close()
+
+	if err := d.Run(strings.NewReader("CREATE TABLE t (Qty int);\nCREATE INDEX t_qty ON t (Qty);")); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := d.(database.Dumper).Dump(context.Background(), &b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "CREATE TABLE t (Qty int);\n\nCREATE INDEX t_qty ON t (Qty);\n\n"
+	if b.String() != expected {
+		t.Fatalf("expected dump %q, got %q", expected, b.String())
+	}
+}
@@ -0,0 +1,441 @@
+// Package sqlsplit splits migrations into single statements for database
+// drivers that can only execute one statement at a time.
+//
+// The splitter knows just enough about the lexical rules of a dialect to
+// find the end of a statement: quoted strings and identifiers, comments,
+// dollar quoted bodies and custom delimiters. It doesn't validate queries.
+package sqlsplit
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultDelimiter terminates statements, unless changed with a
+// DELIMITER command.
+var DefaultDelimiter = ";"
+
+// Dialect describes the lexical rules of a query language.
+// 'Single quoted' and "double quoted" strings as well as
+// -- line comments and /* block comments */ are always recognized.
+type Dialect struct {
+	// BacktickQuotes enables `backtick quoted` identifiers.
+	BacktickQuotes bool
+
+	// BracketQuotes enables [bracket quoted] identifiers.
+	BracketQuotes bool
+
+	// TripleQuotes enables '''triple quoted''' and """triple quoted""" strings.
+	TripleQuotes bool
+
+	// BackslashEscapes enables backslash escapes in quoted strings.
+	BackslashEscapes bool
+
+	// EscapeStrings enables E'escape strings' with backslash escapes.
+	EscapeStrings bool
+
+	// DollarQuotes enables $$dollar quoted$$ strings.
+	DollarQuotes bool
+
+	// DollarTags enables $tag$dollar quoted$tag$ strings with a tag.
+	// Requires DollarQuotes.
+	DollarTags bool
+
+	// HashComments enables # comments until the end of the line.
+	HashComments bool
+
+	// SlashComments enables // comments until the end of the line.
+	SlashComments bool
+
+	// NestedComments allows /* block comments */ to be nested.
+	NestedComments bool
+
+	// DelimiterCommand enables the DELIMITER command of the mysql client,
+	// which changes the delimiter for the following statements.
+	// The command itself is not returned as a statement.
+	DelimiterCommand bool
+
+	// Batches keeps BEGIN BATCH ... APPLY BATCH blocks in a single statement.
+	Batches bool
+
+	// Triggers keeps the BEGIN ... END body of CREATE TRIGGER
+	// statements in a single statement.
+	Triggers bool
+}
+
+var (
+	// Generic only knows the rules that apply to all dialects.
+	Generic = Dialect{}
+
+	// Postgres is the dialect of postgres, cockroachdb and redshift.
+	Postgres = Dialect{
+		EscapeStrings:  true,
+		DollarQuotes:   true,
+		DollarTags:     true,
+		NestedComments: true,
+	}
+
+	// MySQL is the dialect of mysql, including DELIMITER commands.
+	MySQL = Dialect{
+		BacktickQuotes:   true,
+		BackslashEscapes: true,
+		HashComments:     true,
+		DelimiterCommand: true,
+	}
+
+	// Cassandra is the dialect of CQL.
+	Cassandra = Dialect{
+		DollarQuotes:  true,
+		SlashComments: true,
+		Batches:       true,
+	}
+
+	// Spanner is the dialect of Google Cloud Spanner.
+	Spanner = Dialect{
+		BacktickQuotes:   true,
+		TripleQuotes:     true,
+		BackslashEscapes: true,
+		HashComments:     true,
+	}
+
+	// SQLite is the dialect of sqlite3.
+	SQLite = Dialect{
+		BacktickQuotes: true,
+		BracketQuotes:  true,
+		Triggers:       true,
+	}
+
+	// ClickHouse is the dialect of ClickHouse.
+	ClickHouse = Dialect{
+		BacktickQuotes:   true,
+		BackslashEscapes: true,
+	}
+)
+
+// Statement is a single statement of a migration.
+type Statement struct {
+	// Query is the statement without the delimiter and without
+	// leading comments and surrounding whitespace.
+	Query string
+
+	// Offset is the byte offset of the statement in the migration.
+	Offset int
+
+	// Line and Column are the position of the statement in the
+	// migration, both starting at 1. Column counts runes, not bytes.
+	Line   uint
+	Column uint
+}
+
+// Split splits migration into statements according to the rules of d.
+// Empty statements and statements consisting only of comments are skipped.
+// Unterminated strings and comments extend to the end of the migration.
+func Split(migration []byte, d Dialect) []Statement {
+	s := &splitter{
+		src:   migration,
+		d:     d,
+		delim: DefaultDelimiter,
+		start: -1,
+		line:  1,
+	}
+	return s.split()
+}
+
+type splitter struct {
+	src   []byte
+	d     Dialect
+	delim string
+	pos   int
+
+	// start is the offset of the current statement, -1 if
+	// no statement has started yet
+	start int
+
+	// line is the line of lineStart, counted is the offset
+	// up to which lines have been counted
+	line      uint
+	lineStart int
+	counted   int
+
+	// words of the current statement, for Batches and Triggers
+	words     int
+	firstWord string
+	lastWord  string
+	inBatch   bool
+	inTrigger bool
+	depth     int
+
+	stmts []Statement
+}
+
+func (s *splitter) split() []Statement {
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+
+		switch {
+		case isSpace(c):
+			s.pos++
+
+		case s.hasPrefix("--"),
+			s.d.HashComments && c == '#',
+			s.d.SlashComments && s.hasPrefix("//"):
+			s.skipLine()
+
+		case s.hasPrefix("/*"):
+			s.skipBlockComment()
+
+		case s.start < 0 && s.d.DelimiterCommand && s.delimiterCommand():
+
+		case !s.inBatch && s.depth == 0 && s.hasPrefix(s.delim):
+			s.end(s.pos)
+			s.pos += len(s.delim)
+
+		default:
+			if s.start < 0 {
+				s.start = s.pos
+			}
+			s.skipToken()
+		}
+	}
+
+	s.end(len(s.src))
+	return s.stmts
+}
+
+// end adds the current statement, if any, ending at offset end.
+func (s *splitter) end(end int) {
+	if s.start < 0 {
+		return
+	}
+
+	for i := s.counted; i < s.start; i++ {
+		if s.src[i] == '\n' {
+			s.line++
+			s.lineStart = i + 1
+		}
+	}
+	s.counted = s.start
+
+	s.stmts = append(s.stmts, Statement{
+		Query:  strings.TrimRightFunc(string(s.src[s.start:end]), isSpaceRune),
+		Offset: s.start,
+		Line:   s.line,
+		Column: uint(utf8.RuneCount(s.src[s.lineStart:s.start])) + 1,
+	})
+
+	s.start = -1
+	s.words = 0
+	s.firstWord = ""
+	s.lastWord = ""
+	s.inBatch = false
+	s.inTrigger = false
+	s.depth = 0
+}
+
+// skipToken skips a quoted string or identifier, a word
+// or a single byte.
+func (s *splitter) skipToken() {
+	c := s.src[s.pos]
+
+	switch {
+	case s.d.TripleQuotes && (s.hasPrefix("'''") || s.hasPrefix(`"""`)):
+		s.skipQuoted(string(s.src[s.pos:s.pos+3]), s.d.BackslashEscapes)
+
+	case c == '\'' || c == '"':
+		s.skipQuoted(string(c), s.d.BackslashEscapes)
+
+	case s.d.BacktickQuotes && c == '`':
+		s.skipQuoted("`", false)
+
+	case s.d.BracketQuotes && c == '[':
+		s.skipUntil("]", 1)
+
+	case s.d.EscapeStrings && (c == 'E' || c == 'e') && s.peek(1) == '\'' && !s.afterIdent():
+		s.pos++
+		s.skipQuoted("'", true)
+
+	case s.d.DollarQuotes && c == '$' && !s.afterIdent():
+		if tag := s.dollarTag(); len(tag) > 0 {
+			s.skipUntil(tag, len(tag))
+		} else {
+			s.pos++
+		}
+
+	case isIdent(c):
+		begin := s.pos
+		for s.pos < len(s.src) && isIdent(s.src[s.pos]) {
+			s.pos++
+		}
+		s.word(string(s.src[begin:s.pos]))
+
+	default:
+		s.pos++
+	}
+}
+
+// word keeps track of BEGIN BATCH ... APPLY BATCH blocks
+// and trigger bodies.
+func (s *splitter) word(w string) {
+	if !s.d.Batches && !s.d.Triggers {
+		return
+	}
+
+	w = strings.ToUpper(w)
+	s.words++
+
+	if s.d.Batches && w == "BATCH" {
+		switch {
+		case s.inBatch && s.lastWord == "APPLY":
+			s.inBatch = false
+		case s.firstWord == "BEGIN" && (s.words == 2 ||
+			s.words == 3 && (s.lastWord == "UNLOGGED" || s.lastWord == "COUNTER")):
+			s.inBatch = true
+		}
+	}
+
+	if s.d.Triggers {
+		switch {
+		case w == "TRIGGER" && s.firstWord == "CREATE" && s.words <= 3:
+			s.inTrigger = true
+		case s.inTrigger && w == "BEGIN",
+			s.depth > 0 && w == "CASE":
+			s.depth++
+		case s.depth > 0 && w == "END":
+			s.depth--
+		}
+	}
+
+	if s.words == 1 {
+		s.firstWord = w
+	}
+	s.lastWord = w
+}
+
+// skipQuoted skips a string starting at pos and ending with quote.
+func (s *splitter) skipQuoted(quote string, backslashEscapes bool) {
+	s.pos += len(quote)
+	for s.pos < len(s.src) {
+		if backslashEscapes && s.src[s.pos] == '\\' {
+			s.pos += 2
+			continue
+		}
+		if s.hasPrefix(quote) {
+			s.pos += len(quote)
+			return
+		}
+		s.pos++
+	}
+	s.pos = len(s.src)
+}
+
+// skipUntil skips skip bytes and everything up to and including the
+// next occurrence of end.
+func (s *splitter) skipUntil(end string, skip int) {
+	i := bytes.Index(s.src[s.pos+skip:], []byte(end))
+	if i < 0 {
+		s.pos = len(s.src)
+		return
+	}
+	s.pos += skip + i + len(end)
+}
+
+func (s *splitter) skipLine() {
+	i := bytes.IndexByte(s.src[s.pos:], '\n')
+	if i < 0 {
+		s.pos = len(s.src)
+		return
+	}
+	s.pos += i + 1
+}
+
+func (s *splitter) skipBlockComment() {
+	if !s.d.NestedComments {
+		s.skipUntil("*/", 2)
+		return
+	}
+
+	depth := 0
+	for s.pos < len(s.src) {
+		switch {
+		case s.hasPrefix("/*"):
+			depth++
+			s.pos += 2
+		case s.hasPrefix("*/"):
+			depth--
+			s.pos += 2
+			if depth == 0 {
+				return
+			}
+		default:
+			s.pos++
+		}
+	}
+}
+
+// dollarTag returns the tag of a dollar quoted string starting at pos,
+// i.e. $$ or $tag$, or an empty string if there is none. Positional
+// parameters like $1 are no tags.
+func (s *splitter) dollarTag() string {
+	i := s.pos + 1
+	if s.d.DollarTags && i < len(s.src) && !isDigit(s.src[i]) {
+		for i < len(s.src) && isIdent(s.src[i]) {
+			i++
+		}
+	}
+	if i < len(s.src) && s.src[i] == '$' {
+		return string(s.src[s.pos : i+1])
+	}
+	return ""
+}
+
+// delimiterCommand handles a DELIMITER command at pos.
+// It returns false if there is none.
+func (s *splitter) delimiterCommand() bool {
+	const cmd = "DELIMITER"
+	if len(s.src)-s.pos <= len(cmd) ||
+		!strings.EqualFold(string(s.src[s.pos:s.pos+len(cmd)]), cmd) ||
+		!isSpace(s.src[s.pos+len(cmd)]) || s.src[s.pos+len(cmd)] == '\n' {
+		return false
+	}
+
+	begin := s.pos + len(cmd)
+	s.skipLine()
+	delim := strings.TrimSpace(string(s.src[begin:s.pos]))
+	if len(delim) > 0 {
+		s.delim = delim
+	}
+	return true
+}
+
+func (s *splitter) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(s.src[s.pos:], []byte(prefix))
+}
+
+func (s *splitter) peek(n int) byte {
+	if s.pos+n < len(s.src) {
+		return s.src[s.pos+n]
+	}
+	return 0
+}
+
+// afterIdent reports if the byte before pos belongs to an identifier.
+func (s *splitter) afterIdent() bool {
+	return s.pos > 0 && (isIdent(s.src[s.pos-1]) || s.src[s.pos-1] == '$')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isSpaceRune(r rune) bool {
+	return r < utf8.RuneSelf && isSpace(byte(r))
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdent(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '_' || c >= utf8.RuneSelf
+}
@@ -0,0 +1,98 @@
+package sqlsplit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func queries(stmts []Statement) []string {
+	q := make([]string, 0, len(stmts))
+	for _, s := range stmts {
+		q = append(q, s.Query)
+	}
+	return q
+}
+
+func TestSplit(t *testing.T) {
+	tt := []struct {
+		name      string
+		dialect   Dialect
+		migration string
+		expected  []string
+	}{
+		{"empty", Generic, "", []string{}},
+		{"only comments", Generic, "-- foo;\n/* bar; */\n", []string{}},
+		{"single", Generic, "SELECT 1", []string{"SELECT 1"}},
+		{"trailing delimiter", Generic, "SELECT 1;\n", []string{"SELECT 1"}},
+		{"multiple", Generic, "SELECT 1; SELECT 2;\nSELECT 3", []string{"SELECT 1", "SELECT 2", "SELECT 3"}},
+		{"empty statements", Generic, ";;SELECT 1;;", []string{"SELECT 1"}},
+		{"leading comment", Generic, "-- users\nCREATE TABLE users (id int);", []string{"CREATE TABLE users (id int)"}},
+		{"comment inside", Generic, "SELECT 1 -- one; two\n, 2;", []string{"SELECT 1 -- one; two\n, 2"}},
+		{"block comment", Generic, "SELECT /* ; */ 1;", []string{"SELECT /* ; */ 1"}},
+		{"single quotes", Generic, "SELECT 'a;b'; SELECT 'it''s;'", []string{"SELECT 'a;b'", "SELECT 'it''s;'"}},
+		{"double quotes", Generic, `SELECT "a;b" FROM t;`, []string{`SELECT "a;b" FROM t`}},
+		{"no backslash escapes", Generic, `SELECT 'a\'; SELECT 2`, []string{`SELECT 'a\'`, "SELECT 2"}},
+		{"unterminated string", Generic, "SELECT 'a; SELECT 2", []string{"SELECT 'a; SELECT 2"}},
+
+		{"postgres dollar quotes", Postgres,
+			"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;\nSELECT f();",
+			[]string{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql", "SELECT f()"}},
+		{"postgres dollar tags", Postgres,
+			"DO $body$ BEGIN PERFORM '$$;'; END $body$; SELECT 1",
+			[]string{"DO $body$ BEGIN PERFORM '$$;'; END $body$", "SELECT 1"}},
+		{"postgres positional parameters", Postgres, "PREPARE p AS SELECT $1; SELECT $2",
+			[]string{"PREPARE p AS SELECT $1", "SELECT $2"}},
+		{"postgres escape strings", Postgres, `SELECT E'a\';'; SELECT 'b\'; SELECT 3`,
+			[]string{`SELECT E'a\';'`, `SELECT 'b\'`, "SELECT 3"}},
+		{"postgres nested comments", Postgres, "/* a /* ; */ ; */ SELECT 1; SELECT 2",
+			[]string{"SELECT 1", "SELECT 2"}},
+
+		{"mysql backslash escapes", MySQL, `SELECT 'a\';'; SELECT 2`, []string{`SELECT 'a\';'`, "SELECT 2"}},
+		{"mysql backticks", MySQL, "SELECT `a;b` FROM t; SELECT 2", []string{"SELECT `a;b` FROM t", "SELECT 2"}},
+		{"mysql hash comments", MySQL, "# one; two\nSELECT 1; # three;\n", []string{"SELECT 1"}},
+		{"mysql delimiter", MySQL,
+			"DELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END//\nDELIMITER ;\nCALL p();",
+			[]string{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "CALL p()"}},
+		{"generic delimiter", Generic, "DELIMITER //\nSELECT 1;", []string{"DELIMITER //\nSELECT 1"}},
+
+		{"cassandra batch", Cassandra,
+			"BEGIN UNLOGGED BATCH\n  INSERT INTO t (id) VALUES (1);\n  INSERT INTO t (id) VALUES (2);\nAPPLY BATCH;\nSELECT * FROM t;",
+			[]string{"BEGIN UNLOGGED BATCH\n  INSERT INTO t (id) VALUES (1);\n  INSERT INTO t (id) VALUES (2);\nAPPLY BATCH", "SELECT * FROM t"}},
+		{"cassandra slash comments", Cassandra, "// a;\nSELECT 1; // b;\n", []string{"SELECT 1"}},
+		{"cassandra dollar quotes", Cassandra, "CREATE FUNCTION f() AS $$ return 1; $$;",
+			[]string{"CREATE FUNCTION f() AS $$ return 1; $$"}},
+
+		{"sqlite trigger", SQLite,
+			"CREATE TRIGGER t AFTER INSERT ON a BEGIN\n  UPDATE b SET n = CASE WHEN n > 0 THEN n END;\n  DELETE FROM c;\nEND;\nSELECT [a;b] FROM a;",
+			[]string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN\n  UPDATE b SET n = CASE WHEN n > 0 THEN n END;\n  DELETE FROM c;\nEND", "SELECT [a;b] FROM a"}},
+		{"sqlite transaction", SQLite, "BEGIN; SELECT 1; END;", []string{"BEGIN", "SELECT 1", "END"}},
+
+		{"spanner triple quotes", Spanner, "SELECT '''a;'b'''; SELECT \"\"\"c;\"\"\"",
+			[]string{"SELECT '''a;'b'''", "SELECT \"\"\"c;\"\"\""}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := queries(Split([]byte(tc.migration), tc.dialect))
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSplitPosition(t *testing.T) {
+	migration := "-- comment\nSELECT 1;\n\n  SELECT 2; SELECT 'ä'; SELECT 4;\r\nSELECT 5"
+	expected := []Statement{
+		{Query: "SELECT 1", Offset: 11, Line: 2, Column: 1},
+		{Query: "SELECT 2", Offset: 24, Line: 4, Column: 3},
+		{Query: "SELECT 'ä'", Offset: 34, Line: 4, Column: 13},
+		{Query: "SELECT 4", Offset: 47, Line: 4, Column: 25},
+		{Query: "SELECT 5", Offset: 58, Line: 5, Column: 1},
+	}
+
+	got := Split([]byte(migration), Generic)
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+}
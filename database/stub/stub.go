@@ -1,9 +1,15 @@
 package stub
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
+	nurl "net/url"
 	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mattes/migrate/database"
 )
@@ -20,28 +26,60 @@ type Stub struct {
 	LastRunMigration  []byte // todo: make []string
 	IsDirty           bool
 	IsLocked          bool
+	HistoryEntries    []database.HistoryEntry
+	LockStore         *LockStore
 
 	Config *Config
+
+	lockTable *database.LockTable
 }
 
 func (s *Stub) Open(url string) (database.Driver, error) {
-	return &Stub{
-		Url:               url,
-		CurrentVersion:    -1,
-		MigrationSequence: make([]string, 0),
-		Config:            &Config{},
-	}, nil
+	purl, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var staleAfter time.Duration
+	if s := purl.Query().Get("x-lock-stale-after"); len(s) > 0 {
+		if staleAfter, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	d, err := WithInstance(nil, &Config{
+		HistoryTable:   purl.Query().Get("x-history-table"),
+		LockTable:      purl.Query().Get("x-lock-table"),
+		LockStaleAfter: staleAfter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.(*Stub).Url = url
+	return d, nil
 }
 
-type Config struct{}
+type Config struct {
+	HistoryTable string
+
+	// LockTable enables locking with an in-memory lock table,
+	// see database.LockTable.
+	LockTable      string
+	LockStaleAfter time.Duration
+}
 
 func WithInstance(instance interface{}, config *Config) (database.Driver, error) {
-	return &Stub{
+	s := &Stub{
 		Instance:          instance,
 		CurrentVersion:    -1,
 		MigrationSequence: make([]string, 0),
 		Config:            config,
-	}, nil
+	}
+	if config != nil && len(config.LockTable) > 0 {
+		s.LockStore = &LockStore{locks: make(map[string]database.LockInfo)}
+		s.lockTable = database.NewLockTable(s.LockStore, config.LockTable, config.LockStaleAfter)
+	}
+	return s, nil
 }
 
 func (s *Stub) Close() error {
@@ -49,7 +87,11 @@ func (s *Stub) Close() error {
 }
 
 func (s *Stub) Lock() error {
-	if s.IsLocked {
+	if s.lockTable != nil {
+		if err := s.lockTable.Lock(context.Background()); err != nil {
+			return err
+		}
+	} else if s.IsLocked {
 		return database.ErrLocked
 	}
 	s.IsLocked = true
@@ -58,9 +100,36 @@ func (s *Stub) Lock() error {
 
 func (s *Stub) Unlock() error {
 	s.IsLocked = false
+	if s.lockTable != nil {
+		return s.lockTable.Unlock(context.Background())
+	}
 	return nil
 }
 
+// LockLost implements database.LockLostDriver.
+func (s *Stub) LockLost() <-chan struct{} {
+	if s.lockTable == nil {
+		return nil
+	}
+	return s.lockTable.Lost()
+}
+
+// LockHolder implements database.LockTableDriver.
+func (s *Stub) LockHolder(ctx context.Context) (*database.LockInfo, error) {
+	if s.lockTable == nil {
+		return nil, ErrNoLockTable
+	}
+	return s.lockTable.Holder(ctx)
+}
+
+// ForceUnlock implements database.LockTableDriver.
+func (s *Stub) ForceUnlock(ctx context.Context) (*database.LockInfo, error) {
+	if s.lockTable == nil {
+		return nil, ErrNoLockTable
+	}
+	return s.lockTable.ForceUnlock(ctx)
+}
+
 func (s *Stub) Run(migration io.Reader) error {
 	m, err := ioutil.ReadAll(migration)
 	if err != nil {
@@ -90,6 +159,122 @@ func (s *Stub) Drop() error {
 	return nil
 }
 
+func (s *Stub) AppendHistory(entry database.HistoryEntry) error {
+	if s.Config == nil || len(s.Config.HistoryTable) == 0 {
+		return nil
+	}
+	s.HistoryEntries = append(s.HistoryEntries, entry)
+	return nil
+}
+
+func (s *Stub) History() ([]database.HistoryEntry, error) {
+	if s.Config == nil || len(s.Config.HistoryTable) == 0 {
+		return nil, database.ErrNoHistory
+	}
+	return s.HistoryEntries, nil
+}
+
+// Dump writes the migrations run since the last Drop, one per line,
+// like a schema: empty migrations are left out, and "DROP x" removes
+// a previous "CREATE x".
+func (s *Stub) Dump(ctx context.Context, w io.Writer) error {
+	start := 0
+	for i, m := range s.MigrationSequence {
+		if m == DROP {
+			start = i + 1
+		}
+	}
+	schema := make([]string, 0)
+	for _, m := range s.MigrationSequence[start:] {
+		if len(m) == 0 {
+			continue
+		}
+		if strings.HasPrefix(m, "DROP ") {
+			if i := indexOf(schema, "CREATE "+strings.TrimPrefix(m, "DROP ")); i >= 0 {
+				schema = append(schema[:i], schema[i+1:]...)
+				continue
+			}
+		}
+		schema = append(schema, m)
+	}
+	for _, m := range schema {
+		if _, err := fmt.Fprintln(w, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexOf(a []string, s string) int {
+	for i, e := range a {
+		if e == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// ErrNoLockTable is returned by LockHolder and ForceUnlock
+// if the lock table isn't enabled.
+var ErrNoLockTable = fmt.Errorf("no lock table, enable it with x-lock-table")
+
+// LockStore is an in-memory database.LockStore.
+type LockStore struct {
+	mu    sync.Mutex
+	locks map[string]database.LockInfo
+}
+
+func (l *LockStore) InsertLock(ctx context.Context, lock database.LockInfo) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.locks[lock.LockId]; ok {
+		return false, nil
+	}
+	l.locks[lock.LockId] = lock
+	return true, nil
+}
+
+func (l *LockStore) ReplaceLock(ctx context.Context, oldOwner string, lock database.LockInfo) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if held, ok := l.locks[lock.LockId]; !ok || held.Owner != oldOwner {
+		return false, nil
+	}
+	l.locks[lock.LockId] = lock
+	return true, nil
+}
+
+func (l *LockStore) UpdateHeartbeat(ctx context.Context, lockId, owner string, heartbeatAt time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	held, ok := l.locks[lockId]
+	if !ok || held.Owner != owner {
+		return false, nil
+	}
+	held.HeartbeatAt = heartbeatAt
+	l.locks[lockId] = held
+	return true, nil
+}
+
+func (l *LockStore) DeleteLock(ctx context.Context, lockId, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if held, ok := l.locks[lockId]; ok && (len(owner) == 0 || held.Owner == owner) {
+		delete(l.locks, lockId)
+	}
+	return nil
+}
+
+func (l *LockStore) ReadLock(ctx context.Context, lockId string) (*database.LockInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	held, ok := l.locks[lockId]
+	if !ok {
+		return nil, nil
+	}
+	return &held, nil
+}
+
 func (s *Stub) EqualSequence(seq []string) bool {
 	return reflect.DeepEqual(seq, s.MigrationSequence)
 }
@@ -1,8 +1,12 @@
 package stub
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/mattes/migrate/database"
 	dt "github.com/mattes/migrate/database/testing"
 )
 
@@ -14,3 +18,123 @@ func Test(t *testing.T) {
 	}
 	dt.Test(t, d, []byte("/* foobar migration */"))
 }
+
+func TestHistory(t *testing.T) {
+	s := &Stub{}
+	d, err := s.Open("stub://?x-history-table=schema_history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt.TestHistory(t, d.(*Stub))
+}
+
+func TestLockTable(t *testing.T) {
+	s := &Stub{}
+	d, err := s.Open("stub://?x-lock-table=schema_lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt.TestLockAndUnlock(t, d)
+}
+
+func TestLockTableHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	store := &LockStore{locks: make(map[string]database.LockInfo)}
+	a := database.NewLockTable(store, "1", 40*time.Millisecond)
+	b := database.NewLockTable(store, "1", 40*time.Millisecond)
+
+	if err := a.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(ctx); err == nil {
+		t.Fatal("expected the lock to be held")
+	} else if e, ok := err.(database.ErrLockHeld); !ok || len(e.Holder) == 0 {
+		t.Fatalf("expected ErrLockHeld with holder, got %v", err)
+	}
+
+	// the heartbeat keeps the lock from expiring
+	time.Sleep(100 * time.Millisecond)
+	if err := b.Lock(ctx); err == nil {
+		t.Fatal("expected the lock to be held")
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// stale locks expire
+	stale := time.Now().Add(-time.Hour)
+	if _, err := store.InsertLock(ctx, database.LockInfo{LockId: "1", Owner: "crashed", AcquiredAt: stale, HeartbeatAt: stale}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the holder notices that its lock was removed
+	held, err := b.ForceUnlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held == nil || held.Owner == "crashed" {
+		t.Fatalf("expected the lock of a to be removed, got %v", held)
+	}
+	select {
+	case <-a.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock to be lost")
+	}
+	if err := a.Unlock(ctx); err != database.ErrLockLost {
+		t.Fatalf("expected ErrLockLost, got %v", err)
+	}
+}
+
+// failDeleteStore is a LockStore which fails to delete locks while err is set.
+type failDeleteStore struct {
+	*LockStore
+	err error
+}
+
+func (s *failDeleteStore) DeleteLock(ctx context.Context, lockId, owner string) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.LockStore.DeleteLock(ctx, lockId, owner)
+}
+
+func TestLockTableUnlockRetry(t *testing.T) {
+	ctx := context.Background()
+	store := &failDeleteStore{LockStore: &LockStore{locks: make(map[string]database.LockInfo)}}
+	l := database.NewLockTable(store, "1", time.Minute)
+
+	if err := l.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Lock(ctx); err != database.ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	store.err = fmt.Errorf("connection reset")
+	if err := l.Unlock(ctx); err != store.err {
+		t.Fatalf("expected delete error, got %v", err)
+	}
+	if err := l.Lock(ctx); err != database.ErrLocked {
+		t.Fatalf("expected the lock to be held still, got %v", err)
+	}
+
+	store.err = nil
+	if err := l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := l.Holder(ctx); err != nil || held != nil {
+		t.Fatalf("expected the lock to be removed, got %v (%v)", held, err)
+	}
+	if err := l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
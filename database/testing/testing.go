@@ -136,3 +136,54 @@ func TestSetVersion(t *testing.T, d database.Driver) {
 		t.Fatal("expected version to be 2")
 	}
 }
+
+// TestHistory runs tests against database implementations of the optional
+// database.HistoryDriver interface. The history must be enabled and empty.
+func TestHistory(t *testing.T, d database.HistoryDriver) {
+	tnow := time.Now().UTC().Truncate(time.Second)
+	entries := []database.HistoryEntry{
+		{
+			Version:    1,
+			Direction:  database.DirectionUp,
+			Identifier: "1_foobar.up.sql",
+			Checksum:   "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c",
+			StartedAt:  tnow,
+			FinishedAt: tnow.Add(2 * time.Second),
+			Duration:   2 * time.Second,
+			AppliedBy:  "tester@localhost",
+		},
+		{
+			Version:    1,
+			Direction:  database.DirectionDown,
+			Identifier: "<empty>",
+			StartedAt:  tnow.Add(3 * time.Second),
+			FinishedAt: tnow.Add(3 * time.Second),
+			AppliedBy:  "tester@localhost",
+		},
+	}
+
+	for _, e := range entries {
+		if err := d.AppendHistory(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := d.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(entries) {
+		t.Fatalf("History: expected %v entries, got %v", len(entries), len(history))
+	}
+
+	for i, e := range entries {
+		h := history[i]
+		if h.Version != e.Version || h.Direction != e.Direction || h.Identifier != e.Identifier ||
+			h.Checksum != e.Checksum || h.Duration != e.Duration || h.AppliedBy != e.AppliedBy {
+			t.Errorf("History: expected %+v, got %+v", e, h)
+		}
+		if !h.StartedAt.Equal(e.StartedAt) || !h.FinishedAt.Equal(e.FinishedAt) {
+			t.Errorf("History: expected %v - %v, got %v - %v", e.StartedAt, e.FinishedAt, h.StartedAt, h.FinishedAt)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"io"
+)
+
+// TransactionalDriver is an optional interface a database driver can
+// implement if the database supports transactional DDL.
+//
+// Migrate uses it to run a migration and set the new version in a single
+// transaction, so a failing migration is rolled back and doesn't leave
+// the database in a dirty state. If the driver implements AtomicDriver,
+// too, Migrate uses Begin and Commit instead, so the migration history
+// is written in the same transaction.
+type TransactionalDriver interface {
+	// RunWithVersion runs migration and sets version with a clean
+	// (not dirty) state in a single transaction. If either fails,
+	// the transaction must be rolled back.
+	// version must be >= -1. -1 means NilVersion.
+	RunWithVersion(ctx context.Context, migration io.Reader, version int) error
+}
+
+// AtomicDriver is an optional interface a database driver can implement
+// to run a whole batch of migrations in a single transaction.
+//
+// Migrate calls Begin before the first migration of a batch and Commit
+// after the last one. If a migration fails, Rollback is called instead.
+// In between, Run, SetVersion and AppendHistory (and their context variants)
+// must use the transaction started by Begin.
+type AtomicDriver interface {
+	// Begin starts a transaction.
+	Begin(ctx context.Context) error
+
+	// Commit commits the transaction started by Begin.
+	Commit() error
+
+	// Rollback rolls back the transaction started by Begin.
+	Rollback() error
+}
@@ -8,7 +8,13 @@ import (
 const advisoryLockIdSalt uint = 1486364155
 
 // inspired by rails migrations, see https://goo.gl/8o9bCT
-func GenerateAdvisoryLockId(databaseName string) (string, error) {
+// Non-empty additionalNames, i.e. a schema name, make the lock more specific.
+func GenerateAdvisoryLockId(databaseName string, additionalNames ...string) (string, error) {
+	for _, name := range additionalNames {
+		if len(name) > 0 {
+			databaseName += "\x00" + name
+		}
+	}
 	sum := crc32.ChecksumIEEE([]byte(databaseName))
 	sum = sum * uint32(advisoryLockIdSalt)
 	return fmt.Sprintf("%v", sum), nil
@@ -1,7 +1,11 @@
 package database
 
+import (
+	"testing"
+)
+
 func TestGenerateAdvisoryLockId(t *testing.T) {
-	id, err := p.generateAdvisoryLockId("database_name")
+	id, err := GenerateAdvisoryLockId("database_name")
 	if err != nil {
 		t.Errorf("expected err to be nil, got %v", err)
 	}
@@ -9,4 +13,20 @@ func TestGenerateAdvisoryLockId(t *testing.T) {
 		t.Errorf("expected generated id not to be empty")
 	}
 	t.Logf("generated id: %v", id)
+
+	// empty additional names don't change the id
+	if same, _ := GenerateAdvisoryLockId("database_name", ""); same != id {
+		t.Errorf("expected %v for empty schema name, got %v", id, same)
+	}
+
+	a, _ := GenerateAdvisoryLockId("database_name", "tenant_a")
+	b, _ := GenerateAdvisoryLockId("database_name", "tenant_b")
+	if a == id || b == id || a == b {
+		t.Errorf("expected distinct ids per schema, got %v, %v and %v", id, a, b)
+	}
+
+	// names are separated, so they can't be confused
+	if joined, _ := GenerateAdvisoryLockId("database_nametenant_a"); joined == a {
+		t.Errorf("expected distinct ids for database_nametenant_a and tenant_a")
+	}
 }
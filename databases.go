@@ -0,0 +1,166 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/source"
+)
+
+// ErrSkipped is the error of databases that haven't been migrated
+// by ForEachDatabase, because another database failed before.
+var ErrSkipped = fmt.Errorf("skipped after an earlier error")
+
+// DatabaseResult is the result of ForEachDatabase for a single database.
+type DatabaseResult struct {
+	// DatabaseUrl is the URL of the database.
+	DatabaseUrl string
+
+	// Err is the error returned by opening the database or by fn.
+	Err error
+
+	// Version is the active version of the database afterwards,
+	// or database.NilVersion if it has none or it couldn't be read.
+	Version int
+	Dirty   bool
+
+	// Duration is the time spent on the database.
+	Duration time.Duration
+}
+
+// ForEachDatabase opens a Migrate instance for each of databaseUrls and
+// calls fn with it, i.e. to migrate all shards of a database up. At most
+// parallel databases are handled at the same time. The source is opened
+// once and shared by all instances, which are closed after fn returns.
+// If failFast is set, no more databases are started after fn failed for one
+// of them, their results are ErrSkipped. Running ones are finished.
+// It returns an error if the source can't be opened, and the result
+// of each database otherwise, in the order of databaseUrls.
+func ForEachDatabase(sourceUrl string, databaseUrls []string, parallel int, failFast bool, fn func(databaseUrl string, m *Migrate) error) ([]DatabaseResult, error) {
+	return ForEachDatabaseContext(context.Background(), sourceUrl, databaseUrls, parallel, failFast, fn)
+}
+
+// ForEachDatabaseContext is like ForEachDatabase. If ctx is done,
+// no more databases are started and their results are ctx.Err().
+// fn should pass ctx on, i.e. to UpContext.
+func ForEachDatabaseContext(ctx context.Context, sourceUrl string, databaseUrls []string, parallel int, failFast bool, fn func(databaseUrl string, m *Migrate) error) ([]DatabaseResult, error) {
+	targets, err := forEachTarget(ctx, sourceUrl, databaseUrls, parallel, failFast, func(i int, m *Migrate) error {
+		return fn(databaseUrls[i], m)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DatabaseResult, len(targets))
+	for i, t := range targets {
+		results[i] = DatabaseResult{
+			DatabaseUrl: databaseUrls[i],
+			Err:         t.err,
+			Version:     t.version,
+			Dirty:       t.dirty,
+			Duration:    t.duration,
+		}
+	}
+	return results, nil
+}
+
+// targetResult is the result of forEachTarget for a single database.
+type targetResult struct {
+	err      error
+	version  int
+	dirty    bool
+	duration time.Duration
+}
+
+// forEachTarget opens a Migrate instance for each of databaseUrls, sharing
+// the source, and calls fn with the index of the database and the instance.
+func forEachTarget(ctx context.Context, sourceUrl string, databaseUrls []string, parallel int, failFast bool, fn func(i int, m *Migrate) error) ([]targetResult, error) {
+	sourceName, err := schemeFromUrl(sourceUrl)
+	if err != nil {
+		return nil, err
+	}
+	sourceDrv, err := source.Open(sourceUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer sourceDrv.Close()
+	shared := newSharedSource(sourceDrv)
+
+	var mu sync.Mutex
+	failed := false
+
+	results := make([]targetResult, len(databaseUrls))
+	forEachParallel(len(databaseUrls), parallel, func(i int) {
+		mu.Lock()
+		skip := failFast && failed
+		mu.Unlock()
+		if skip {
+			results[i] = targetResult{err: ErrSkipped, version: database.NilVersion}
+			return
+		}
+
+		startTime := time.Now()
+		results[i] = migrateTarget(ctx, sourceName, shared, databaseUrls[i], func(m *Migrate) error {
+			return fn(i, m)
+		})
+		results[i].duration = time.Now().Sub(startTime)
+
+		if results[i].err != nil {
+			mu.Lock()
+			failed = true
+			mu.Unlock()
+		}
+	})
+	return results, nil
+}
+
+// migrateTarget opens a Migrate instance for databaseUrl and calls fn with it.
+func migrateTarget(ctx context.Context, sourceName string, sourceDrv source.Driver, databaseUrl string, fn func(*Migrate) error) targetResult {
+	result := targetResult{version: database.NilVersion}
+	if err := ctx.Err(); err != nil {
+		result.err = err
+		return result
+	}
+
+	m, err := NewWithSourceInstance(sourceName, sourceDrv, databaseUrl)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer m.Close()
+
+	result.err = fn(m)
+	if v, dirty, err := m.databaseVersion(context.Background()); err == nil {
+		result.version = v
+		result.dirty = dirty
+	}
+	return result
+}
+
+// forEachParallel calls fn for 0 <= i < n, with at most parallel calls
+// at the same time. It returns after all calls returned.
+func forEachParallel(n int, parallel int, fn func(i int)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < parallel && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
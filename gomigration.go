@@ -0,0 +1,306 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mattes/migrate/database"
+)
+
+// GoMigrationFunc is a migration written in Go. handle is the underlying
+// connection of the database driver if it implements database.Handler,
+// i.e. *sql.DB, otherwise it is the database.Driver itself. While running
+// atomic migrations, handle is the transaction, i.e. *sql.Tx.
+type GoMigrationFunc func(ctx context.Context, handle interface{}) error
+
+type goMigration struct {
+	identifier string
+	up         GoMigrationFunc
+	down       GoMigrationFunc
+}
+
+// GoMigrations holds Go migrations by version, see RegisterGoMigration.
+// Use NewGoMigrations to create one.
+type GoMigrations struct {
+	mu         sync.RWMutex
+	migrations map[uint]*goMigration
+
+	// versions are the versions of migrations in ascending order
+	versions []uint
+}
+
+// NewGoMigrations returns an empty set of Go migrations.
+func NewGoMigrations() *GoMigrations {
+	return &GoMigrations{migrations: make(map[uint]*goMigration)}
+}
+
+// DefaultGoMigrations holds the migrations registered with RegisterGoMigration.
+// It is shared by all Migrate instances, regardless of their source,
+// unless Migrate.GoMigrations is changed.
+var DefaultGoMigrations = NewGoMigrations()
+
+// RegisterGoMigration registers Go functions as up and down migration for
+// version in DefaultGoMigrations, usually from an init function.
+// See GoMigrations.Register.
+//
+// Every Migrate instance runs these migrations, whatever its source is.
+// Use GoMigrations of their own for Migrate instances that should not,
+// see Migrate.GoMigrations.
+func RegisterGoMigration(version uint, up, down GoMigrationFunc) {
+	DefaultGoMigrations.Register(version, up, down)
+}
+
+// Register registers Go functions as up and down migration for version.
+// Either of them can be nil.
+// Go migrations are merged with the migrations of the source, a version
+// can't be both. They run with the same version bookkeeping as migrations
+// from the source. Their body is a comment with the version and the name of
+// the function, so its checksum changes if another function is registered.
+// If Register is called twice with the same version, it panics.
+func (g *GoMigrations) Register(version uint, up, down GoMigrationFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if up == nil && down == nil {
+		panic("RegisterGoMigration: up and down are nil")
+	}
+	if _, dup := g.migrations[version]; dup {
+		panic(fmt.Sprintf("RegisterGoMigration called twice for version %v", version))
+	}
+
+	fn := up
+	if fn == nil {
+		fn = down
+	}
+	g.migrations[version] = &goMigration{
+		identifier: funcName(fn),
+		up:         up,
+		down:       down,
+	}
+
+	// copy, so slices returned by sortedVersions don't change
+	versions := make([]uint, len(g.versions), len(g.versions)+1)
+	copy(versions, g.versions)
+	i := sort.Search(len(versions), func(i int) bool { return versions[i] > version })
+	versions = append(versions, 0)
+	copy(versions[i+1:], versions[i:])
+	versions[i] = version
+	g.versions = versions
+}
+
+// get returns the Go migration registered for version.
+func (g *GoMigrations) get(version uint) (*goMigration, bool) {
+	if g == nil {
+		return nil, false
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	migr, ok := g.migrations[version]
+	return migr, ok
+}
+
+// sortedVersions returns the versions of all Go migrations in ascending
+// order. The returned slice must not be modified.
+func (g *GoMigrations) sortedVersions() []uint {
+	if g == nil {
+		return nil
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.versions
+}
+
+// funcName returns the name of fn without the package path,
+// i.e. main.backfillUsers.
+func funcName(fn GoMigrationFunc) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "go"
+	}
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// The following helpers merge the versions of the source with the
+// versions of the Go migrations, see context.go.
+
+func (m *Migrate) mergedFirst(ctx context.Context) (uint, error) {
+	if len(m.GoMigrations.sortedVersions()) == 0 {
+		return m.sourceDrvFirst(ctx)
+	}
+
+	versions, err := m.mergedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, os.ErrNotExist
+	}
+	return versions[0], nil
+}
+
+func (m *Migrate) mergedNext(ctx context.Context, version uint) (uint, error) {
+	if len(m.GoMigrations.sortedVersions()) == 0 {
+		return m.sourceDrvNext(ctx, version)
+	}
+
+	versions, err := m.mergedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i] > version })
+	if i == len(versions) {
+		return 0, os.ErrNotExist
+	}
+	return versions[i], nil
+}
+
+func (m *Migrate) mergedPrev(ctx context.Context, version uint) (uint, error) {
+	if len(m.GoMigrations.sortedVersions()) == 0 {
+		return m.sourceDrvPrev(ctx, version)
+	}
+
+	versions, err := m.mergedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i] >= version })
+	if i == 0 {
+		return 0, os.ErrNotExist
+	}
+	return versions[i-1], nil
+}
+
+// mergedVersions returns the versions of the source and the Go migrations
+// in ascending order. The versions of the source are read once, walking
+// the source from its first version, and merged with the Go migrations
+// again only if more of them were registered since.
+func (m *Migrate) mergedVersions(ctx context.Context) ([]uint, error) {
+	goVersions := m.GoMigrations.sortedVersions()
+	if m.mergedVersionsCache != nil && m.mergedGoVersions == len(goVersions) {
+		return m.mergedVersionsCache, nil
+	}
+
+	if m.sourceVersionsCache == nil {
+		sourceVersions := make([]uint, 0)
+		v, err := m.sourceDrvFirst(ctx)
+		for err == nil {
+			sourceVersions = append(sourceVersions, v)
+			v, err = m.sourceDrvNext(ctx, v)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		m.sourceVersionsCache = sourceVersions
+	}
+
+	// both are sorted, merge them
+	src := m.sourceVersionsCache
+	versions := make([]uint, 0, len(src)+len(goVersions))
+	i, j := 0, 0
+	for i < len(src) || j < len(goVersions) {
+		switch {
+		case j == len(goVersions) || i < len(src) && src[i] < goVersions[j]:
+			versions = append(versions, src[i])
+			i++
+		case i == len(src) || goVersions[j] < src[i]:
+			versions = append(versions, goVersions[j])
+			j++
+		default:
+			// a version can't be both, see checkGoMigration
+			versions = append(versions, src[i])
+			i++
+			j++
+		}
+	}
+
+	m.mergedVersionsCache = versions
+	m.mergedGoVersions = len(goVersions)
+	return versions, nil
+}
+
+// mergedRead returns the body from the source, or for Go migrations
+// a comment naming the version and function, see goMigrationBody.
+func (m *Migrate) mergedRead(ctx context.Context, version uint, up bool) (io.ReadCloser, string, error) {
+	read := m.sourceDrvReadUp
+	if !up {
+		read = m.sourceDrvReadDown
+	}
+
+	g, ok := m.GoMigrations.get(version)
+	if !ok {
+		return read(ctx, version)
+	}
+
+	if err := m.checkGoMigration(ctx, version); err != nil {
+		return nil, "", err
+	}
+
+	fn := g.up
+	if !up {
+		fn = g.down
+	}
+	if fn == nil {
+		return nil, "", os.ErrNotExist
+	}
+	return ioutil.NopCloser(strings.NewReader(goMigrationBody(version, fn))), g.identifier, nil
+}
+
+// goMigrationBody returns the body of the Go migration fn for version.
+// It isn't run, but gives the migration a checksum that changes if
+// another function is registered for version.
+func goMigrationBody(version uint, fn GoMigrationFunc) string {
+	return fmt.Sprintf("-- Go migration %v: %v\n", version, funcName(fn))
+}
+
+// checkGoMigration returns an error if version is a Go migration
+// and a migration in the source.
+func (m *Migrate) checkGoMigration(ctx context.Context, version uint) error {
+	for _, read := range []func(context.Context, uint) (io.ReadCloser, string, error){
+		m.sourceDrvReadUp, m.sourceDrvReadDown,
+	} {
+		r, _, err := read(ctx, version)
+		if err == nil {
+			r.Close()
+			return fmt.Errorf("version %v is a Go migration and a migration in the source", version)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runGoMigration runs the Go migration of migr. While running atomic
+// migrations, it gets the transaction of the database driver,
+// see database.TxHandler.
+func (m *Migrate) runGoMigration(ctx context.Context, migr *Migration) error {
+	var handle interface{} = m.databaseDrv
+	if m.isAtomic {
+		h, ok := m.databaseDrv.(database.TxHandler)
+		if !ok {
+			return fmt.Errorf("Go migration %v can't run in the transaction of atomic migrations", migr.LogString())
+		}
+		handle = h.TxHandle()
+	} else if h, ok := m.databaseDrv.(database.Handler); ok {
+		handle = h.Handle()
+	}
+
+	// drain the body, so the migration is finished reading
+	if _, err := io.Copy(ioutil.Discard, migr.BufferedBody); err != nil {
+		return err
+	}
+
+	m.logVerbosePrintf("Execute Go migration %v\n", migr.LogString())
+	return migr.goFunc(ctx, handle)
+}
@@ -0,0 +1,93 @@
+package migrate
+
+import (
+	"time"
+)
+
+// Hooks are called around running migrations, i.e. to collect metrics
+// or send notifications. All of them are optional. A batch is a single
+// call to Migrate, Steps, Up, Down, Run, Redo or VerifyReversible.
+// An error returned by a hook stops the batch and is returned by it.
+type Hooks struct {
+	// BeforeAll is called before the first migration of a batch runs.
+	// It isn't called if there is nothing to migrate.
+	BeforeAll func() error
+
+	// BeforeEach is called before each migration runs.
+	BeforeEach func(migr *Migration) error
+
+	// AfterEach is called after each migration ran successfully. readTime is
+	// the time spent reading the migration from the source, runTime the time
+	// spent running it against the database.
+	AfterEach func(migr *Migration, readTime, runTime time.Duration) error
+
+	// AfterAll is called after all migrations of a batch ran successfully,
+	// with the number of migrations that ran and the duration of the batch.
+	// In atomic mode, it is called after the transaction has been committed.
+	AfterAll func(count int, duration time.Duration) error
+
+	// OnError is called once if a batch fails after BeforeAll has been called.
+	// migr is the migration that failed, or nil if the error isn't caused
+	// by a single migration, i.e. if committing the transaction failed.
+	OnError func(migr *Migration, err error)
+}
+
+// withHooks runs a batch of migrations and calls AfterAll or OnError
+// afterwards, if BeforeAll has been called.
+func (m *Migrate) withHooks(fn func() error) error {
+	m.hooksStarted = false
+	m.hooksFailed = false
+	m.hooksCount = 0
+
+	startTime := time.Now()
+	err := fn()
+	if !m.hooksStarted {
+		return err
+	}
+
+	if err == nil && m.Hooks.AfterAll != nil {
+		err = m.Hooks.AfterAll(m.hooksCount, time.Now().Sub(startTime))
+	}
+	if err != nil {
+		m.onError(nil, err)
+	}
+	return err
+}
+
+// beforeEach calls BeforeAll before the first migration
+// of a batch and BeforeEach before every migration.
+func (m *Migrate) beforeEach(migr *Migration) error {
+	if !m.hooksStarted {
+		m.hooksStarted = true
+		if m.Hooks.BeforeAll != nil {
+			if err := m.Hooks.BeforeAll(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.Hooks.BeforeEach != nil {
+		return m.Hooks.BeforeEach(migr)
+	}
+	return nil
+}
+
+// afterEach calls AfterEach after every migration.
+func (m *Migrate) afterEach(migr *Migration, readTime, runTime time.Duration) error {
+	m.hooksCount++
+	if m.Hooks.AfterEach != nil {
+		return m.Hooks.AfterEach(migr, readTime, runTime)
+	}
+	return nil
+}
+
+// onError calls OnError for the first error of a batch.
+func (m *Migrate) onError(migr *Migration, err error) {
+	if m.hooksFailed {
+		return
+	}
+	m.hooksFailed = true
+	if m.Hooks.OnError != nil {
+		m.Hooks.OnError(migr, err)
+	}
+}
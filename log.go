@@ -1,5 +1,7 @@
 package migrate
 
+import "time"
+
 // Logger is an interface so you can pass in your own
 // logging implementation.
 type Logger interface {
@@ -10,3 +12,18 @@ type Logger interface {
 	// Verbose should return true when verbose logging output is wanted
 	Verbose() bool
 }
+
+// MigrationLogger can be implemented by a Logger to be notified before and
+// after each migration runs, i.e. to write machine-readable output.
+// If it is implemented, the log line for a finished migration is not printed.
+type MigrationLogger interface {
+	Logger
+
+	// StartMigration is called before migr runs.
+	StartMigration(migr *Migration)
+
+	// FinishMigration is called after migr ran successfully. readTime is the
+	// time spent reading the migration from the source, runTime the time
+	// spent running it against the database.
+	FinishMigration(migr *Migration, readTime, runTime time.Duration)
+}
@@ -5,8 +5,13 @@
 package migrate
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"math/rand"
 	"os"
+	"os/user"
 	"sync"
 	"time"
 
@@ -24,11 +29,21 @@ var DefaultPrefetchMigrations = uint(10)
 // DefaultLockTimeout sets the max time a database driver has to acquire a lock.
 var DefaultLockTimeout = 15 * time.Second
 
+// DefaultLockRetryInterval sets the time to wait before trying again to
+// acquire a lock held by someone else. It is doubled after each try,
+// up to maxLockRetryInterval.
+var DefaultLockRetryInterval = 100 * time.Millisecond
+
+const maxLockRetryInterval = 5 * time.Second
+
 var (
-	ErrNoChange    = fmt.Errorf("no change")
-	ErrNilVersion  = fmt.Errorf("no migration")
-	ErrLocked      = fmt.Errorf("database locked")
-	ErrLockTimeout = fmt.Errorf("timeout: can't acquire database lock")
+	ErrNoChange           = fmt.Errorf("no change")
+	ErrNilVersion         = fmt.Errorf("no migration")
+	ErrLocked             = fmt.Errorf("database locked")
+	ErrLockTimeout        = fmt.Errorf("timeout: can't acquire database lock")
+	ErrAtomicNotSupported = fmt.Errorf("database driver doesn't support atomic migrations")
+	ErrDumpNotSupported   = fmt.Errorf("database driver doesn't support dumping the schema")
+	ErrNoLockTable        = fmt.Errorf("database driver doesn't keep its lock in a lock table")
 )
 
 // ErrShortLimit is an error returned when not enough migrations
@@ -50,6 +65,25 @@ func (e ErrDirty) Error() string {
 	return fmt.Sprintf("Dirty database version %v. Fix and force version.", e.Version)
 }
 
+// ErrMigrationFailed is returned if a migration fails to run
+// against the database. Err is usually a *database.Error.
+type ErrMigrationFailed struct {
+	Version    uint
+	Identifier string
+	Direction  database.Direction
+	Err        error
+}
+
+// Error implements the error interface.
+func (e ErrMigrationFailed) Error() string {
+	return fmt.Sprintf("migration %v/%v %v failed: %v", e.Version, e.Direction, e.Identifier, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e ErrMigrationFailed) Unwrap() error {
+	return e.Err
+}
+
 type Migrate struct {
 	sourceName   string
 	sourceDrv    source.Driver
@@ -75,6 +109,60 @@ type Migrate struct {
 	// LockTimeout defaults to DefaultLockTimeout,
 	// but can be set per Migrate instance.
 	LockTimeout time.Duration
+
+	// LockRetryInterval defaults to DefaultLockRetryInterval,
+	// but can be set per Migrate instance. If it is 0, acquiring
+	// a lock held by someone else fails right away.
+	LockRetryInterval time.Duration
+
+	// AppliedBy is recorded in the migration history (if the database
+	// driver keeps one) for every migration that is run.
+	// Defaults to user@hostname of the current process.
+	AppliedBy string
+
+	// SkipValidation disables the validation of applied migrations
+	// before migrating up. See Validate.
+	SkipValidation bool
+
+	// AllowOutOfOrder applies migrations below the currently active
+	// version that haven't been applied yet when migrating up, instead
+	// of failing with ErrOutOfOrder. Requires a migration history.
+	AllowOutOfOrder bool
+
+	// Atomic runs all migrations of a single call to Migrate, Steps, Up,
+	// Down or Run in one transaction, so either all of them are applied
+	// or none. The database driver must implement database.AtomicDriver.
+	Atomic   bool
+	isAtomic bool
+
+	// Vars replace variables in migration bodies before they run, so the
+	// same migrations can be applied with different names, i.e. per schema.
+	// Variables are written as ${name} or {{.name}}. Undefined variables
+	// are left as they are, unless StrictVars is set.
+	// Checksums are computed from the bodies before replacing variables.
+	Vars map[string]string
+
+	// StrictVars makes migrations that use variables that aren't
+	// in Vars fail with ErrUndefinedVar.
+	StrictVars bool
+
+	// GoMigrations are merged with the migrations of the source.
+	// Defaults to DefaultGoMigrations, which are shared by all Migrate
+	// instances. Set it to nil to run the source only, or to GoMigrations
+	// of its own, see NewGoMigrations.
+	GoMigrations *GoMigrations
+
+	// versions of the source and merged with GoMigrations,
+	// see mergedVersions
+	sourceVersionsCache []uint
+	mergedVersionsCache []uint
+	mergedGoVersions    int
+
+	// Hooks are called around running migrations.
+	Hooks        Hooks
+	hooksStarted bool
+	hooksFailed  bool
+	hooksCount   int
 }
 
 // New returns a new Migrate instance from a source URL and a database URL.
@@ -182,7 +270,9 @@ func newCommon() *Migrate {
 		GracefulStop:       make(chan bool, 1),
 		PrefetchMigrations: DefaultPrefetchMigrations,
 		LockTimeout:        DefaultLockTimeout,
+		LockRetryInterval:  DefaultLockRetryInterval,
 		isLockedMu:         &sync.Mutex{},
+		GoMigrations:       DefaultGoMigrations,
 	}
 }
 
@@ -207,11 +297,18 @@ func (m *Migrate) Close() (source error, database error) {
 // Migrate looks at the currently active migration version,
 // then migrates either up or down to the specified version.
 func (m *Migrate) Migrate(version uint) error {
-	if err := m.lock(); err != nil {
+	return m.MigrateContext(context.Background(), version)
+}
+
+// MigrateContext is like Migrate. If ctx is done, it stops executing
+// migrations at the next safe break point, just like GracefulStop,
+// and returns ctx.Err().
+func (m *Migrate) MigrateContext(ctx context.Context, version uint) error {
+	if err := m.lock(ctx); err != nil {
 		return err
 	}
 
-	curVersion, dirty, err := m.databaseDrv.Version()
+	curVersion, dirty, err := m.databaseVersion(ctx)
 	if err != nil {
 		return m.unlockErr(err)
 	}
@@ -220,24 +317,34 @@ func (m *Migrate) Migrate(version uint) error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
-	go m.read(curVersion, int(version), ret)
-
-	return m.unlockErr(m.runMigrations(ret))
+	err = m.atomic(ctx, func() error {
+		return m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+			m.read(ctx, curVersion, int(version), ret)
+		})
+	})
+	return m.unlockErr(err)
 }
 
 // Steps looks at the currently active migration version.
 // It will migrate up if n > 0, and down if n < 0.
+// When migrating up, applied migrations are validated and out-of-order
+// migrations are handled just like in Up. Out-of-order migrations don't
+// count towards n.
 func (m *Migrate) Steps(n int) error {
+	return m.StepsContext(context.Background(), n)
+}
+
+// StepsContext is like Steps. See MigrateContext for how ctx is handled.
+func (m *Migrate) StepsContext(ctx context.Context, n int) error {
 	if n == 0 {
 		return ErrNoChange
 	}
 
-	if err := m.lock(); err != nil {
+	if err := m.lock(ctx); err != nil {
 		return err
 	}
 
-	curVersion, dirty, err := m.databaseDrv.Version()
+	curVersion, dirty, err := m.databaseVersion(ctx)
 	if err != nil {
 		return m.unlockErr(err)
 	}
@@ -246,25 +353,56 @@ func (m *Migrate) Steps(n int) error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
-
+	var outOfOrder []uint
 	if n > 0 {
-		go m.readUp(curVersion, n, ret)
-	} else {
-		go m.readDown(curVersion, -n, ret)
+		if err := m.validateBeforeUp(ctx); err != nil {
+			return m.unlockErr(err)
+		}
+
+		outOfOrder, err = m.checkOutOfOrder(ctx, curVersion)
+		if err != nil {
+			return m.unlockErr(err)
+		}
 	}
 
-	return m.unlockErr(m.runMigrations(ret))
+	err = m.atomic(ctx, func() error {
+		if err := m.runOutOfOrder(ctx, outOfOrder, curVersion); err != nil {
+			return err
+		}
+
+		err := m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+			if n > 0 {
+				m.readUp(ctx, curVersion, n, ret)
+			} else {
+				m.readDown(ctx, curVersion, -n, ret)
+			}
+		})
+		if err == ErrNoChange && len(outOfOrder) > 0 {
+			err = nil
+		}
+		return err
+	})
+	return m.unlockErr(err)
 }
 
 // Up looks at the currently active migration version
 // and will migrate all the way up (applying all up migrations).
+// If the database keeps a migration history, Up fails with
+// ErrChecksumMismatch if applied migrations changed in the source,
+// unless SkipValidation is set. Migrations below the currently active
+// version that haven't been applied yet, make Up fail with ErrOutOfOrder,
+// unless AllowOutOfOrder is set, in which case they are applied first.
 func (m *Migrate) Up() error {
-	if err := m.lock(); err != nil {
+	return m.UpContext(context.Background())
+}
+
+// UpContext is like Up. See MigrateContext for how ctx is handled.
+func (m *Migrate) UpContext(ctx context.Context) error {
+	if err := m.lock(ctx); err != nil {
 		return err
 	}
 
-	curVersion, dirty, err := m.databaseDrv.Version()
+	curVersion, dirty, err := m.databaseVersion(ctx)
 	if err != nil {
 		return m.unlockErr(err)
 	}
@@ -273,20 +411,56 @@ func (m *Migrate) Up() error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
+	if err := m.validateBeforeUp(ctx); err != nil {
+		return m.unlockErr(err)
+	}
 
-	go m.readUp(curVersion, -1, ret)
-	return m.unlockErr(m.runMigrations(ret))
+	outOfOrder, err := m.checkOutOfOrder(ctx, curVersion)
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	err = m.atomic(ctx, func() error {
+		if err := m.runOutOfOrder(ctx, outOfOrder, curVersion); err != nil {
+			return err
+		}
+
+		err := m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+			m.readUp(ctx, curVersion, -1, ret)
+		})
+		if err == ErrNoChange && len(outOfOrder) > 0 {
+			err = nil
+		}
+		if err != nil && err != ErrNoChange {
+			return err
+		}
+
+		// re-run changed repeatable migrations after all versioned ones
+		ran, repeatableErr := m.runRepeatables(ctx)
+		if repeatableErr != nil {
+			return repeatableErr
+		}
+		if err == ErrNoChange && ran > 0 {
+			err = nil
+		}
+		return err
+	})
+	return m.unlockErr(err)
 }
 
 // Down looks at the currently active migration version
 // and will migrate all the way down (applying all down migrations).
 func (m *Migrate) Down() error {
-	if err := m.lock(); err != nil {
+	return m.DownContext(context.Background())
+}
+
+// DownContext is like Down. See MigrateContext for how ctx is handled.
+func (m *Migrate) DownContext(ctx context.Context) error {
+	if err := m.lock(ctx); err != nil {
 		return err
 	}
 
-	curVersion, dirty, err := m.databaseDrv.Version()
+	curVersion, dirty, err := m.databaseVersion(ctx)
 	if err != nil {
 		return m.unlockErr(err)
 	}
@@ -295,17 +469,25 @@ func (m *Migrate) Down() error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
-	go m.readDown(curVersion, -1, ret)
-	return m.unlockErr(m.runMigrations(ret))
+	err = m.atomic(ctx, func() error {
+		return m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+			m.readDown(ctx, curVersion, -1, ret)
+		})
+	})
+	return m.unlockErr(err)
 }
 
 // Drop deletes everything in the database.
 func (m *Migrate) Drop() error {
-	if err := m.lock(); err != nil {
+	return m.DropContext(context.Background())
+}
+
+// DropContext is like Drop.
+func (m *Migrate) DropContext(ctx context.Context) error {
+	if err := m.lock(ctx); err != nil {
 		return err
 	}
-	if err := m.databaseDrv.Drop(); err != nil {
+	if err := m.databaseDrop(ctx); err != nil {
 		return m.unlockErr(err)
 	}
 	return m.unlock()
@@ -316,15 +498,20 @@ func (m *Migrate) Drop() error {
 // Usually you don't need this function at all. Use Migrate,
 // Steps, Up or Down instead.
 func (m *Migrate) Run(migration ...*Migration) error {
+	return m.RunContext(context.Background(), migration...)
+}
+
+// RunContext is like Run. See MigrateContext for how ctx is handled.
+func (m *Migrate) RunContext(ctx context.Context, migration ...*Migration) error {
 	if len(migration) == 0 {
 		return ErrNoChange
 	}
 
-	if err := m.lock(); err != nil {
+	if err := m.lock(ctx); err != nil {
 		return err
 	}
 
-	curVersion, dirty, err := m.databaseDrv.Version()
+	curVersion, dirty, err := m.databaseVersion(ctx)
 	if err != nil {
 		return m.unlockErr(err)
 	}
@@ -333,38 +520,42 @@ func (m *Migrate) Run(migration ...*Migration) error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
+	err = m.atomic(ctx, func() error {
+		return m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+			defer close(ret)
+			for _, migr := range migration {
+				if m.PrefetchMigrations > 0 && migr.Body != nil {
+					m.logVerbosePrintf("Start buffering %v\n", migr.LogString())
+				} else {
+					m.logVerbosePrintf("Scheduled %v\n", migr.LogString())
+				}
 
-	go func() {
-		defer close(ret)
-		for _, migr := range migration {
-			if m.PrefetchMigrations > 0 && migr.Body != nil {
-				m.logVerbosePrintf("Start buffering %v\n", migr.LogString())
-			} else {
-				m.logVerbosePrintf("Scheduled %v\n", migr.LogString())
+				ret <- migr
+				go migr.Buffer()
 			}
-
-			ret <- migr
-			go migr.Buffer()
-		}
-	}()
-
-	return m.unlockErr(m.runMigrations(ret))
+		})
+	})
+	return m.unlockErr(err)
 }
 
 // Force sets a migration version.
 // It does not check any currently active version in database.
 // It resets the dirty state to false.
 func (m *Migrate) Force(version int) error {
+	return m.ForceContext(context.Background(), version)
+}
+
+// ForceContext is like Force.
+func (m *Migrate) ForceContext(ctx context.Context, version int) error {
 	if version < -1 {
 		panic("version must be >= -1")
 	}
 
-	if err := m.lock(); err != nil {
+	if err := m.lock(ctx); err != nil {
 		return err
 	}
 
-	if err := m.databaseDrv.SetVersion(version, false); err != nil {
+	if err := m.databaseSetVersion(ctx, version, false); err != nil {
 		return m.unlockErr(err)
 	}
 
@@ -374,7 +565,12 @@ func (m *Migrate) Force(version int) error {
 // Version returns the currently active migration version.
 // If no migration has been applied, yet, it will return ErrNilVersion.
 func (m *Migrate) Version() (version uint, dirty bool, err error) {
-	v, d, err := m.databaseDrv.Version()
+	return m.VersionContext(context.Background())
+}
+
+// VersionContext is like Version.
+func (m *Migrate) VersionContext(ctx context.Context) (version uint, dirty bool, err error) {
+	v, d, err := m.databaseVersion(ctx)
 	if err != nil {
 		return 0, false, err
 	}
@@ -386,16 +582,74 @@ func (m *Migrate) Version() (version uint, dirty bool, err error) {
 	return suint(v), d, nil
 }
 
+// History returns all migrations recorded in the migration history of
+// the database, oldest first. It returns database.ErrNoHistory if the
+// database driver does not keep a history or if it was not enabled.
+func (m *Migrate) History() ([]database.HistoryEntry, error) {
+	h, ok := m.databaseDrv.(database.HistoryDriver)
+	if !ok {
+		return nil, database.ErrNoHistory
+	}
+	return h.History()
+}
+
+// Dump writes the schema of the database to w. It returns
+// ErrDumpNotSupported if the database driver doesn't implement database.Dumper.
+func (m *Migrate) Dump(w io.Writer) error {
+	return m.DumpContext(context.Background(), w)
+}
+
+// DumpContext is like Dump.
+func (m *Migrate) DumpContext(ctx context.Context, w io.Writer) error {
+	d, ok := m.databaseDrv.(database.Dumper)
+	if !ok {
+		return ErrDumpNotSupported
+	}
+	return d.Dump(ctx, w)
+}
+
+// LockHolder returns who holds the database lock, or nil if it isn't held.
+// The database driver must implement database.LockTableDriver.
+func (m *Migrate) LockHolder() (*database.LockInfo, error) {
+	return m.LockHolderContext(context.Background())
+}
+
+// LockHolderContext is like LockHolder.
+func (m *Migrate) LockHolderContext(ctx context.Context) (*database.LockInfo, error) {
+	d, ok := m.databaseDrv.(database.LockTableDriver)
+	if !ok {
+		return nil, ErrNoLockTable
+	}
+	return d.LockHolder(ctx)
+}
+
+// ForceUnlock removes the database lock regardless of who holds it, i.e.
+// if the process holding it crashed. It returns who held the lock, or nil
+// if it wasn't held. Only use it if no migration is running.
+// The database driver must implement database.LockTableDriver.
+func (m *Migrate) ForceUnlock() (*database.LockInfo, error) {
+	return m.ForceUnlockContext(context.Background())
+}
+
+// ForceUnlockContext is like ForceUnlock.
+func (m *Migrate) ForceUnlockContext(ctx context.Context) (*database.LockInfo, error) {
+	d, ok := m.databaseDrv.(database.LockTableDriver)
+	if !ok {
+		return nil, ErrNoLockTable
+	}
+	return d.ForceUnlock(ctx)
+}
+
 // read reads either up or down migrations from source `from` to `to`.
 // Each migration is then written to the ret channel.
 // If an error occurs during reading, that error is written to the ret channel, too.
 // Once read is done reading it will close the ret channel.
-func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
+func (m *Migrate) read(ctx context.Context, from int, to int, ret chan<- interface{}) {
 	defer close(ret)
 
 	// check if from version exists
 	if from >= 0 {
-		if m.versionExists(suint(from)) != nil {
+		if m.versionExists(ctx, suint(from)) != nil {
 			ret <- os.ErrNotExist
 			return
 		}
@@ -403,7 +657,7 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 
 	// check if to version exists
 	if to >= 0 {
-		if m.versionExists(suint(to)) != nil {
+		if m.versionExists(ctx, suint(to)) != nil {
 			ret <- os.ErrNotExist
 			return
 		}
@@ -419,13 +673,13 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 		// it's going up
 		// apply first migration if from is nil version
 		if from == -1 {
-			firstVersion, err := m.sourceDrv.First()
+			firstVersion, err := m.sourceFirst(ctx)
 			if err != nil {
 				ret <- err
 				return
 			}
 
-			migr, err := m.newMigration(firstVersion, int(firstVersion))
+			migr, err := m.newMigration(ctx, firstVersion, int(firstVersion))
 			if err != nil {
 				ret <- err
 				return
@@ -438,17 +692,20 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 
 		// run until we reach target ...
 		for from < to {
-			if m.stop() {
+			if m.stop(ctx) {
+				if err := ctx.Err(); err != nil {
+					ret <- err
+				}
 				return
 			}
 
-			next, err := m.sourceDrv.Next(suint(from))
+			next, err := m.sourceNext(ctx, suint(from))
 			if err != nil {
 				ret <- err
 				return
 			}
 
-			migr, err := m.newMigration(next, int(next))
+			migr, err := m.newMigration(ctx, next, int(next))
 			if err != nil {
 				ret <- err
 				return
@@ -463,14 +720,17 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 		// it's going down
 		// run until we reach target ...
 		for from > to && from >= 0 {
-			if m.stop() {
+			if m.stop(ctx) {
+				if err := ctx.Err(); err != nil {
+					ret <- err
+				}
 				return
 			}
 
-			prev, err := m.sourceDrv.Prev(suint(from))
+			prev, err := m.sourcePrev(ctx, suint(from))
 			if os.IsNotExist(err) && to == -1 {
 				// apply nil migration
-				migr, err := m.newMigration(suint(from), -1)
+				migr, err := m.newMigration(ctx, suint(from), -1)
 				if err != nil {
 					ret <- err
 					return
@@ -484,7 +744,7 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 				return
 			}
 
-			migr, err := m.newMigration(suint(from), int(prev))
+			migr, err := m.newMigration(ctx, suint(from), int(prev))
 			if err != nil {
 				ret <- err
 				return
@@ -502,12 +762,12 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 // Each migration is then written to the ret channel.
 // If an error occurs during reading, that error is written to the ret channel, too.
 // Once readUp is done reading it will close the ret channel.
-func (m *Migrate) readUp(from int, limit int, ret chan<- interface{}) {
+func (m *Migrate) readUp(ctx context.Context, from int, limit int, ret chan<- interface{}) {
 	defer close(ret)
 
 	// check if from version exists
 	if from >= 0 {
-		if m.versionExists(suint(from)) != nil {
+		if m.versionExists(ctx, suint(from)) != nil {
 			ret <- os.ErrNotExist
 			return
 		}
@@ -520,19 +780,22 @@ func (m *Migrate) readUp(from int, limit int, ret chan<- interface{}) {
 
 	count := 0
 	for count < limit || limit == -1 {
-		if m.stop() {
+		if m.stop(ctx) {
+			if err := ctx.Err(); err != nil {
+				ret <- err
+			}
 			return
 		}
 
 		// apply first migration if from is nil version
 		if from == -1 {
-			firstVersion, err := m.sourceDrv.First()
+			firstVersion, err := m.sourceFirst(ctx)
 			if err != nil {
 				ret <- err
 				return
 			}
 
-			migr, err := m.newMigration(firstVersion, int(firstVersion))
+			migr, err := m.newMigration(ctx, firstVersion, int(firstVersion))
 			if err != nil {
 				ret <- err
 				return
@@ -546,7 +809,7 @@ func (m *Migrate) readUp(from int, limit int, ret chan<- interface{}) {
 		}
 
 		// apply next migration
-		next, err := m.sourceDrv.Next(suint(from))
+		next, err := m.sourceNext(ctx, suint(from))
 		if os.IsNotExist(err) {
 			// no limit, but no migrations applied?
 			if limit == -1 && count == 0 {
@@ -576,7 +839,7 @@ func (m *Migrate) readUp(from int, limit int, ret chan<- interface{}) {
 			return
 		}
 
-		migr, err := m.newMigration(next, int(next))
+		migr, err := m.newMigration(ctx, next, int(next))
 		if err != nil {
 			ret <- err
 			return
@@ -594,12 +857,12 @@ func (m *Migrate) readUp(from int, limit int, ret chan<- interface{}) {
 // Each migration is then written to the ret channel.
 // If an error occurs during reading, that error is written to the ret channel, too.
 // Once readDown is done reading it will close the ret channel.
-func (m *Migrate) readDown(from int, limit int, ret chan<- interface{}) {
+func (m *Migrate) readDown(ctx context.Context, from int, limit int, ret chan<- interface{}) {
 	defer close(ret)
 
 	// check if from version exists
 	if from >= 0 {
-		if m.versionExists(suint(from)) != nil {
+		if m.versionExists(ctx, suint(from)) != nil {
 			ret <- os.ErrNotExist
 			return
 		}
@@ -624,21 +887,24 @@ func (m *Migrate) readDown(from int, limit int, ret chan<- interface{}) {
 
 	count := 0
 	for count < limit || limit == -1 {
-		if m.stop() {
+		if m.stop(ctx) {
+			if err := ctx.Err(); err != nil {
+				ret <- err
+			}
 			return
 		}
 
-		prev, err := m.sourceDrv.Prev(suint(from))
+		prev, err := m.sourcePrev(ctx, suint(from))
 		if os.IsNotExist(err) {
 			// no limit or haven't reached limit, apply "first" migration
 			if limit == -1 || limit-count > 0 {
-				firstVersion, err := m.sourceDrv.First()
+				firstVersion, err := m.sourceFirst(ctx)
 				if err != nil {
 					ret <- err
 					return
 				}
 
-				migr, err := m.newMigration(firstVersion, -1)
+				migr, err := m.newMigration(ctx, firstVersion, -1)
 				if err != nil {
 					ret <- err
 					return
@@ -658,7 +924,7 @@ func (m *Migrate) readDown(from int, limit int, ret chan<- interface{}) {
 			return
 		}
 
-		migr, err := m.newMigration(suint(from), int(prev))
+		migr, err := m.newMigration(ctx, suint(from), int(prev))
 		if err != nil {
 			ret <- err
 			return
@@ -671,17 +937,46 @@ func (m *Migrate) readDown(from int, limit int, ret chan<- interface{}) {
 	}
 }
 
+// readAndRun runs read in a goroutine and the migrations it sends with
+// runMigrations. When runMigrations returns, i.e. because a migration
+// failed, read is cancelled and the remaining migrations are discarded,
+// so the reader doesn't outlive the call.
+func (m *Migrate) readAndRun(ctx context.Context, read func(ctx context.Context, ret chan<- interface{})) error {
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ret := make(chan interface{}, m.PrefetchMigrations)
+	go read(readCtx, ret)
+	err := m.runMigrations(ctx, ret)
+
+	// stop the reader and wait until it closes ret
+	cancel()
+	for r := range ret {
+		if migr, ok := r.(*Migration); ok {
+			migr.discard()
+		}
+	}
+	return err
+}
+
 // runMigrations reads *Migration and error from a channel. Any other type
 // sent on this channel will result in a panic. Each migration is then
 // proxied to the database driver and run against the database.
 // Before running a newly received migration it will check if it's supposed
 // to stop execution because it might have received a stop signal on the
-// GracefulStop channel.
-func (m *Migrate) runMigrations(ret <-chan interface{}) error {
+// GracefulStop channel, or because the database driver lost its lock.
+func (m *Migrate) runMigrations(ctx context.Context, ret <-chan interface{}) error {
 	for r := range ret {
 
-		if m.stop() {
-			return nil
+		if m.stop(ctx) {
+			return ctx.Err()
+		}
+
+		if m.lockLost() {
+			if migr, ok := r.(*Migration); ok {
+				migr.discard()
+			}
+			return database.ErrLockLost
 		}
 
 		switch r.(type) {
@@ -691,29 +986,36 @@ func (m *Migrate) runMigrations(ret <-chan interface{}) error {
 		case *Migration:
 			migr := r.(*Migration)
 
-			// set version with dirty state
-			if err := m.databaseDrv.SetVersion(migr.TargetVersion, true); err != nil {
+			if err := m.beforeEach(migr); err != nil {
+				m.onError(migr, err)
 				return err
 			}
 
-			if migr.Body != nil {
-				m.logVerbosePrintf("Read and execute %v\n", migr.LogString())
-				if err := m.databaseDrv.Run(migr.BufferedBody); err != nil {
-					return err
-				}
+			ml, isMigrationLogger := m.Log.(MigrationLogger)
+			if isMigrationLogger {
+				ml.StartMigration(migr)
 			}
 
-			// set clean state
-			if err := m.databaseDrv.SetVersion(migr.TargetVersion, false); err != nil {
+			if err := m.runMigration(ctx, migr); err != nil {
+				err = ErrMigrationFailed{
+					Version:    migr.Version,
+					Identifier: migr.Identifier,
+					Direction:  migr.direction(),
+					Err:        err,
+				}
+				m.onError(migr, err)
 				return err
 			}
 
 			endTime := time.Now()
+
 			readTime := migr.FinishedReading.Sub(migr.StartedBuffering)
 			runTime := endTime.Sub(migr.FinishedReading)
 
 			// log either verbose or normal
-			if m.Log != nil {
+			if isMigrationLogger {
+				ml.FinishMigration(migr, readTime, runTime)
+			} else if m.Log != nil {
 				if m.Log.Verbose() {
 					m.logPrintf("Finished %v (read %v, ran %v)\n", migr.LogString(), readTime, runTime)
 				} else {
@@ -721,6 +1023,11 @@ func (m *Migrate) runMigrations(ret <-chan interface{}) error {
 				}
 			}
 
+			if err := m.afterEach(migr, readTime, runTime); err != nil {
+				m.onError(migr, err)
+				return err
+			}
+
 		default:
 			panic("unknown type")
 		}
@@ -728,11 +1035,170 @@ func (m *Migrate) runMigrations(ret <-chan interface{}) error {
 	return nil
 }
 
+// runMigration runs a single migration against the database, sets its
+// target version and records it in the migration history.
+// If the database driver implements database.TransactionalDriver,
+// all of it is done in a single transaction, unless the migration starts
+// with NoTransactionHeader. Otherwise the version is marked dirty while
+// the migration is running. When running atomic migrations, everything
+// already happens in the transaction started by atomic.
+func (m *Migrate) runMigration(ctx context.Context, migr *Migration) error {
+	// stop buffering if the migration fails before its body is read
+	defer migr.discard()
+
+	startTime := time.Now()
+
+	var body *bufio.Reader
+	if migr.Body != nil {
+		r, err := m.expandVars(migr.BufferedBody)
+		if err != nil {
+			return err
+		}
+		body = bufio.NewReader(r)
+
+		if m.isAtomic {
+			if hasNoTransactionHeader(body) {
+				return fmt.Errorf("can't run in a transaction, but running atomic migrations")
+			}
+
+		} else if d, ok := m.databaseDrv.(database.TransactionalDriver); ok && migr.goFunc == nil && !hasNoTransactionHeader(body) {
+			// the history can only be part of the transaction
+			// if the driver lets us run it
+			if a, ok := m.databaseDrv.(database.AtomicDriver); ok {
+				m.logVerbosePrintf("Begin transaction for %v\n", migr.LogString())
+				return m.inTransaction(ctx, a, func() error {
+					return m.runMigrationBody(ctx, migr, body, startTime)
+				})
+			}
+
+			m.logVerbosePrintf("Read and execute %v in transaction\n", migr.LogString())
+			if err := d.RunWithVersion(ctx, body, migr.TargetVersion); err != nil {
+				return err
+			}
+			return m.appendHistory(ctx, migr, startTime, time.Now())
+		}
+	}
+
+	return m.runMigrationBody(ctx, migr, body, startTime)
+}
+
+// runMigrationBody runs the Go migration or body of migr, marking the
+// version dirty while it is running, and records it in the migration history.
+func (m *Migrate) runMigrationBody(ctx context.Context, migr *Migration, body *bufio.Reader, startTime time.Time) error {
+	// set version with dirty state
+	if err := m.databaseSetVersion(ctx, migr.TargetVersion, true); err != nil {
+		return err
+	}
+
+	if migr.goFunc != nil {
+		if err := m.runGoMigration(ctx, migr); err != nil {
+			return err
+		}
+	} else if body != nil {
+		m.logVerbosePrintf("Read and execute %v\n", migr.LogString())
+		if err := m.databaseRun(ctx, body); err != nil {
+			return err
+		}
+	}
+
+	// set clean state
+	if err := m.databaseSetVersion(ctx, migr.TargetVersion, false); err != nil {
+		return err
+	}
+	return m.appendHistory(ctx, migr, startTime, time.Now())
+}
+
+// atomic calls fn as a batch of migrations, see Hooks. If Atomic is set,
+// fn is called within a single database transaction, which is committed
+// if fn succeeds and rolled back otherwise.
+func (m *Migrate) atomic(ctx context.Context, fn func() error) error {
+	return m.withHooks(func() error {
+		return m.transaction(ctx, fn)
+	})
+}
+
+// transaction runs fn in a single transaction if Atomic is set.
+func (m *Migrate) transaction(ctx context.Context, fn func() error) error {
+	if !m.Atomic {
+		return fn()
+	}
+
+	d, ok := m.databaseDrv.(database.AtomicDriver)
+	if !ok {
+		return ErrAtomicNotSupported
+	}
+
+	return m.inTransaction(ctx, d, func() error {
+		m.isAtomic = true
+		defer func() {
+			m.isAtomic = false
+		}()
+		return fn()
+	})
+}
+
+// inTransaction calls fn within a transaction of d, which is committed
+// if fn succeeds and rolled back otherwise.
+func (m *Migrate) inTransaction(ctx context.Context, d database.AtomicDriver, fn func() error) error {
+	if err := d.Begin(ctx); err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		if rollbackErr := d.Rollback(); rollbackErr != nil {
+			return NewMultiError(err, rollbackErr)
+		}
+		return err
+	}
+
+	return d.Commit()
+}
+
+// appendHistory records migr in the migration history, if the database
+// driver keeps one.
+func (m *Migrate) appendHistory(ctx context.Context, migr *Migration, startTime, endTime time.Time) error {
+	h, ok := m.databaseDrv.(database.HistoryDriver)
+	if !ok {
+		return nil
+	}
+
+	return m.databaseAppendHistory(ctx, h, database.HistoryEntry{
+		Version:    migr.Version,
+		Direction:  migr.direction(),
+		Identifier: migr.Identifier,
+		Checksum:   migr.Checksum,
+		StartedAt:  startTime,
+		FinishedAt: endTime,
+		Duration:   endTime.Sub(startTime),
+		AppliedBy:  m.appliedBy(),
+	})
+}
+
+// appliedBy returns m.AppliedBy or user@hostname if not set.
+func (m *Migrate) appliedBy() string {
+	if len(m.AppliedBy) > 0 {
+		return m.AppliedBy
+	}
+
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	m.AppliedBy = fmt.Sprintf("%v@%v", username, hostname)
+	return m.AppliedBy
+}
+
 // versionExists checks the source if either the up or down migration for
 // the specified migration version exists.
-func (m *Migrate) versionExists(version uint) error {
+func (m *Migrate) versionExists(ctx context.Context, version uint) error {
 	// try up migration first
-	up, _, err := m.sourceDrv.ReadUp(version)
+	up, _, err := m.sourceReadUp(ctx, version)
 	if err == nil {
 		defer up.Close()
 	}
@@ -743,7 +1209,7 @@ func (m *Migrate) versionExists(version uint) error {
 	}
 
 	// then try down migration
-	down, _, err := m.sourceDrv.ReadDown(version)
+	down, _, err := m.sourceReadDown(ctx, version)
 	if err == nil {
 		defer down.Close()
 	}
@@ -757,9 +1223,9 @@ func (m *Migrate) versionExists(version uint) error {
 }
 
 // stop returns true if no more migrations should be run against the database
-// because a stop signal was received on the GracefulStop channel.
+// because a stop signal was received on the GracefulStop channel or ctx is done.
 // Calls are cheap and this function is not blocking.
-func (m *Migrate) stop() bool {
+func (m *Migrate) stop(ctx context.Context) bool {
 	if m.isGracefulStop {
 		return true
 	}
@@ -769,6 +1235,25 @@ func (m *Migrate) stop() bool {
 		m.isGracefulStop = true
 		return true
 
+	case <-ctx.Done():
+		return true
+
+	default:
+		return false
+	}
+}
+
+// lockLost returns true if the database driver lost its lock
+// while it was held, see database.LockLostDriver.
+func (m *Migrate) lockLost() bool {
+	d, ok := m.databaseDrv.(database.LockLostDriver)
+	if !ok {
+		return false
+	}
+
+	select {
+	case <-d.LockLost():
+		return true
 	default:
 		return false
 	}
@@ -776,11 +1261,11 @@ func (m *Migrate) stop() bool {
 
 // newMigration is a helper func that returns a *Migration for the
 // specified version and targetVersion.
-func (m *Migrate) newMigration(version uint, targetVersion int) (*Migration, error) {
+func (m *Migrate) newMigration(ctx context.Context, version uint, targetVersion int) (*Migration, error) {
 	var migr *Migration
 
 	if targetVersion >= int(version) {
-		r, identifier, err := m.sourceDrv.ReadUp(version)
+		r, identifier, err := m.sourceReadUp(ctx, version)
 		if os.IsNotExist(err) {
 			// create "empty" migration
 			migr, err = NewMigration(nil, "", version, targetVersion)
@@ -800,7 +1285,7 @@ func (m *Migrate) newMigration(version uint, targetVersion int) (*Migration, err
 		}
 
 	} else {
-		r, identifier, err := m.sourceDrv.ReadDown(version)
+		r, identifier, err := m.sourceReadDown(ctx, version)
 		if os.IsNotExist(err) {
 			// create "empty" migration
 			migr, err = NewMigration(nil, "", version, targetVersion)
@@ -820,6 +1305,14 @@ func (m *Migrate) newMigration(version uint, targetVersion int) (*Migration, err
 		}
 	}
 
+	if g, ok := m.GoMigrations.get(version); ok && migr.Body != nil {
+		if targetVersion >= int(version) {
+			migr.goFunc = g.up
+		} else {
+			migr.goFunc = g.down
+		}
+	}
+
 	if m.PrefetchMigrations > 0 && migr.Body != nil {
 		m.logVerbosePrintf("Start buffering %v\n", migr.LogString())
 	} else {
@@ -831,7 +1324,10 @@ func (m *Migrate) newMigration(version uint, targetVersion int) (*Migration, err
 
 // lock is a thread safe helper function to lock the database.
 // It should be called as late as possible when running migrations.
-func (m *Migrate) lock() error {
+// While the lock is held by someone else, i.e. another process running
+// migrations, it tries again with backoff until LockTimeout expires.
+// It gives up waiting for the lock if ctx is done.
+func (m *Migrate) lock(ctx context.Context) error {
 	m.isLockedMu.Lock()
 	defer m.isLockedMu.Unlock()
 
@@ -839,45 +1335,84 @@ func (m *Migrate) lock() error {
 		return ErrLocked
 	}
 
-	// create done channel, used in the timeout goroutine
-	done := make(chan bool, 1)
-	defer func() {
-		done <- true
+	lockCtx, cancel := context.WithTimeout(ctx, m.LockTimeout)
+	defer cancel()
+
+	// try to acquire the lock in a goroutine,
+	// as drivers without LockContext may block
+	errchan := make(chan error, 1)
+	go func() {
+		errchan <- m.lockRetry(lockCtx)
 	}()
 
-	// use errchan to signal error back to this context
-	errchan := make(chan error, 2)
+	// wait until we either acquire the lock or time out
+	select {
+	case err := <-errchan:
+		if err == nil {
+			m.isLocked = true
+			return nil
+		}
+		if lockCtx.Err() == nil {
+			return err
+		}
 
-	// start timeout goroutine
-	timeout := time.After(m.LockTimeout)
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			case <-timeout:
-				errchan <- ErrLockTimeout
-				return
+	case <-lockCtx.Done():
+		// release the lock if it is acquired after giving up
+		go func() {
+			if err := <-errchan; err == nil {
+				m.databaseDrv.Unlock()
 			}
+		}()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrLockTimeout
+}
+
+// lockRetry acquires the lock, trying again with exponential backoff
+// while it is held by someone else, until ctx is done.
+func (m *Migrate) lockRetry(ctx context.Context) error {
+	interval := m.LockRetryInterval
+	for {
+		err := m.databaseLock(ctx)
+		if !isLockHeld(err) || interval <= 0 {
+			return err
+		}
+		m.logVerbosePrintf("Waiting for lock: %v\n", err)
+
+		// add jitter, so processes waiting for the same lock don't try at once
+		wait := interval/2 + time.Duration(rand.Int63n(int64(interval/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
 		}
-	}()
 
-	// now try to acquire the lock
-	go func() {
-		if err := m.databaseDrv.Lock(); err != nil {
-			errchan <- err
-		} else {
-			errchan <- nil
+		if interval *= 2; interval > maxLockRetryInterval {
+			interval = maxLockRetryInterval
 		}
-		return
-	}()
+	}
+}
 
-	// wait until we either recieve ErrLockTimeout or error from Lock operation
-	err := <-errchan
-	if err == nil {
-		m.isLocked = true
+// isLockHeld reports if err means that the lock is held by someone else.
+// database.ErrLocked isn't, the driver holds the lock itself then.
+// Errors are unwrapped by hand, errors.As needs Go 1.13.
+func isLockHeld(err error) bool {
+	switch e := err.(type) {
+	case database.ErrLockHeld:
+		return true
+	case *database.Error:
+		return isLockHeld(e.OrigErr)
+	case MultiError:
+		for _, err := range e.Errs {
+			if isLockHeld(err) {
+				return true
+			}
+		}
 	}
-	return err
+	return false
 }
 
 // unlock is a thread safe helper function to unlock the database.
@@ -888,6 +1423,10 @@ func (m *Migrate) unlock() error {
 	defer m.isLockedMu.Unlock()
 
 	if err := m.databaseDrv.Unlock(); err != nil {
+		// a lost lock isn't held anymore
+		if err == database.ErrLockLost {
+			m.isLocked = false
+		}
 		// BUG: Can potentially create a deadlock. Add a timeout.
 		return err
 	}
@@ -899,7 +1438,7 @@ func (m *Migrate) unlock() error {
 // unlockErr calls unlock and returns a combined error
 // if a prevErr is not nil.
 func (m *Migrate) unlockErr(prevErr error) error {
-	if err := m.unlock(); err != nil {
+	if err := m.unlock(); err != nil && err != prevErr {
 		return NewMultiError(prevErr, err)
 	}
 	return prevErr
@@ -2,14 +2,25 @@ package migrate
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/mattes/migrate/database"
 	dStub "github.com/mattes/migrate/database/stub"
 	"github.com/mattes/migrate/source"
+	_ "github.com/mattes/migrate/source/file"
 	sStub "github.com/mattes/migrate/source/stub"
 )
 
@@ -412,43 +423,1247 @@ func TestUpAndDown(t *testing.T) {
 	equalDbSeq(t, 0, seq.add(M(7, 5), M(5, 4), M(4, 3), M(3, 1), M(1, -1)), dbDrv)
 }
 
+func TestUpMigrationFailed(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	m, _ := NewWithDatabaseInstance("stub://", "stub", &failStub{dbInst.(*dStub.Stub)})
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+
+	err := m.Up()
+	e, ok := err.(ErrMigrationFailed)
+	if !ok {
+		t.Fatalf("expected ErrMigrationFailed, got %v", err)
+	}
+	if e.Version != 1 || e.Direction != database.DirectionUp || e.Identifier != "1.up.stub" {
+		t.Fatalf("expected 1/up 1.up.stub, got %v/%v %v", e.Version, e.Direction, e.Identifier)
+	}
+	if dbErr, ok := e.Unwrap().(*database.Error); !ok || dbErr.Line != 1 {
+		t.Fatalf("expected *database.Error in line 1, got %v", e.Unwrap())
+	}
+}
+
+func TestHooks(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+
+	var calls []string
+	m.Hooks = Hooks{
+		BeforeAll: func() error {
+			calls = append(calls, "before all")
+			return nil
+		},
+		BeforeEach: func(migr *Migration) error {
+			calls = append(calls, "before "+migr.LogString())
+			return nil
+		},
+		AfterEach: func(migr *Migration, readTime, runTime time.Duration) error {
+			calls = append(calls, "after "+migr.LogString())
+			return nil
+		},
+		AfterAll: func(count int, duration time.Duration) error {
+			calls = append(calls, fmt.Sprintf("after all %v", count))
+			return nil
+		},
+		OnError: func(migr *Migration, err error) {
+			calls = append(calls, fmt.Sprintf("error %v", err))
+		},
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"before all", "before 1/u 1.up.stub", "after 1/u 1.up.stub",
+		"before 3/u 3.up.stub", "after 3/u 3.up.stub", "after all 2"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected %q, got %q", expected, calls)
+	}
+
+	// no hooks if there is nothing to migrate
+	calls = nil
+	if err := m.Migrate(3); err != ErrNoChange {
+		t.Fatalf("expected ErrNoChange, got %v", err)
+	}
+	if len(calls) != 0 {
+		t.Fatalf("expected no hooks, got %q", calls)
+	}
+
+	// a failing hook stops the batch
+	calls = nil
+	m.Hooks.BeforeEach = func(migr *Migration) error {
+		return fmt.Errorf("stop at %v", migr.Version)
+	}
+	if err := m.Steps(1); err == nil || err.Error() != "stop at 4" {
+		t.Fatalf("expected error of BeforeEach, got %v", err)
+	}
+	expected = []string{"before all", "error stop at 4"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected %q, got %q", expected, calls)
+	}
+}
+
+func TestGoMigration(t *testing.T) {
+	defer func() {
+		DefaultGoMigrations = NewGoMigrations()
+	}()
+
+	var calls []string
+	goFunc := func(name string) GoMigrationFunc {
+		return func(ctx context.Context, handle interface{}) error {
+			if _, ok := handle.(*dStub.Stub); !ok {
+				t.Errorf("expected *stub.Stub handle, got %T", handle)
+			}
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	RegisterGoMigration(2, goFunc("up 2"), goFunc("down 2"))
+	RegisterGoMigration(6, goFunc("up 6"), nil)
+	RegisterGoMigration(8, goFunc("up 8"), goFunc("down 8"))
+
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"up 2", "up 6", "up 8"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected %q, got %q", expected, calls)
+	}
+	if dbDrv.CurrentVersion != 8 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 8, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+
+	calls = nil
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"down 8", "down 2"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected %q, got %q", expected, calls)
+	}
+	if dbDrv.CurrentVersion != -1 || dbDrv.IsDirty {
+		t.Fatalf("expected clean nil version, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+
+	// a version can't be a Go migration and a migration in the source
+	RegisterGoMigration(3, goFunc("up 3"), nil)
+	if err := m.Up(); err == nil {
+		t.Fatal("expected error for version 3")
+	}
+}
+
+func TestGoMigrationsPerInstance(t *testing.T) {
+	noop := func(ctx context.Context, handle interface{}) error { return nil }
+	other := func(ctx context.Context, handle interface{}) error { return nil }
+
+	m, _ := New("stub://", "stub://?x-history-table=history")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.GoMigrations = NewGoMigrations()
+	m.GoMigrations.Register(2, noop, nil)
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if len(DefaultGoMigrations.sortedVersions()) != 0 {
+		t.Fatal("expected no Go migrations in DefaultGoMigrations")
+	}
+
+	empty, _ := checksum(strings.NewReader(""))
+	for _, e := range dbDrv.HistoryEntries {
+		if e.Version == 2 && (e.Checksum == "" || e.Checksum == empty) {
+			t.Fatalf("expected checksum of the Go migration, got %q", e.Checksum)
+		}
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// another function for the same version changes the checksum
+	m, _ = NewWithDatabaseInstance("stub://", "stub", dbDrv)
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.GoMigrations = NewGoMigrations()
+	m.GoMigrations.Register(2, other, nil)
+	if _, ok := m.Validate().(ErrChecksumMismatch); !ok {
+		t.Fatal("expected ErrChecksumMismatch for version 2")
+	}
+
+	// without Go migrations, version 2 is missing in the source
+	m.GoMigrations = nil
+	if v, err := m.mergedNext(context.Background(), 1); err != nil || v != 3 {
+		t.Fatalf("expected next version 3, got %v (%v)", v, err)
+	}
+}
+
+func TestUpContextCanceled(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := m.UpContext(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	equalDbSeq(t, 0, newMigSeq(), dbDrv)
+}
+
 func TestUpDirty(t *testing.T) {
 	m, _ := New("stub://", "stub://")
 	dbDrv := m.databaseDrv.(*dStub.Stub)
-	if err := dbDrv.SetVersion(0, true); err != nil {
+	if err := dbDrv.SetVersion(0, true); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.Up()
+	if _, ok := err.(ErrDirty); !ok {
+		t.Fatalf("expected ErrDirty, got %v", err)
+	}
+}
+
+func TestDownDirty(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+	if err := dbDrv.SetVersion(0, true); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.Down()
+	if _, ok := err.(ErrDirty); !ok {
+		t.Fatalf("expected ErrDirty, got %v", err)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Drop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dbDrv.MigrationSequence[len(dbDrv.MigrationSequence)-1] != dStub.DROP {
+		t.Fatalf("expected database to DROP, got sequence %v", dbDrv.MigrationSequence)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.AppliedBy = "tester"
+
+	if err := m.Steps(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Steps(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := m.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		version   uint
+		direction database.Direction
+	}{
+		{1, database.DirectionUp},
+		{3, database.DirectionUp},
+		{3, database.DirectionDown},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %v history entries, got %v", len(expected), len(history))
+	}
+	for i, v := range expected {
+		h := history[i]
+		if h.Version != v.version || h.Direction != v.direction {
+			t.Errorf("expected %v/%v, got %v/%v, in %v", v.version, v.direction, h.Version, h.Direction, i)
+		}
+		if h.AppliedBy != "tester" {
+			t.Errorf("expected AppliedBy tester, got %v, in %v", h.AppliedBy, i)
+		}
+		if h.FinishedAt.Before(h.StartedAt) || h.Duration != h.FinishedAt.Sub(h.StartedAt) {
+			t.Errorf("unexpected timing %v - %v (%v), in %v", h.StartedAt, h.FinishedAt, h.Duration, i)
+		}
+	}
+
+	// 3 has an up migration but no down migration
+	if len(history[1].Checksum) != 64 {
+		t.Errorf("expected sha256 checksum, got %q", history[1].Checksum)
+	}
+	if len(history[2].Checksum) != 0 {
+		t.Errorf("expected empty checksum, got %q", history[2].Checksum)
+	}
+}
+
+func TestHistoryNotEnabled(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	if _, err := m.History(); err != database.ErrNoHistory {
+		t.Fatalf("expected database.ErrNoHistory, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	up1 := &source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"}
+	up2 := &source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"}
+	migrations := source.NewMigrations()
+	migrations.Append(up1)
+	migrations.Append(up2)
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Up, Identifier: "CREATE 3"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	if err := m.Steps(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// change applied migrations in source
+	up1.Identifier = "CREATE 1 changed"
+	up2.Identifier = "CREATE 2 changed"
+
+	err := m.Validate()
+	e, ok := err.(ErrChecksumMismatch)
+	if !ok {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if len(e.Mismatches) != 2 || e.Mismatches[0].Version != 1 || e.Mismatches[1].Version != 2 {
+		t.Fatalf("expected versions 1 and 2 to mismatch, got %+v", e.Mismatches)
+	}
+
+	if err := m.Up(); err == nil {
+		t.Fatal("expected Up to fail")
+	} else if _, ok := err.(ErrChecksumMismatch); !ok {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if err := m.Steps(1); err == nil {
+		t.Fatal("expected Steps to fail")
+	}
+
+	// rolled back migrations are not validated
+	if err := m.Steps(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err == nil {
+		t.Fatal("expected Validate to fail")
+	} else if e := err.(ErrChecksumMismatch); len(e.Mismatches) != 1 || e.Mismatches[0].Version != 1 {
+		t.Fatalf("expected version 1 to mismatch, got %+v", e.Mismatches)
+	}
+
+	m.SkipValidation = true
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateSquashed(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Up, Identifier: "CREATE 3"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// squash 1 and 2
+	squashed := source.NewMigrations()
+	squashed.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "-- migrate:squashed\nCREATE 1\nCREATE 2"})
+	squashed.Append(&source.Migration{Version: 3, Direction: source.Up, Identifier: "CREATE 3"})
+	m.sourceDrv.(*sStub.Stub).Migrations = squashed
+
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	squashed.Append(&source.Migration{Version: 3, Direction: source.Down, Identifier: "DROP 3"})
+	if err := m.Steps(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatalf("expected to migrate up past squashed migration, got %v", err)
+	}
+}
+
+func TestValidateNoHistory(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+
+	if err := m.Validate(); err != database.ErrNoHistory {
+		t.Fatalf("expected database.ErrNoHistory, got %v", err)
+	}
+
+	// Up doesn't validate without history
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpRepeatable(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	views := &source.Migration{Direction: source.Repeatable, Identifier: "views", Raw: "CREATE VIEW v1"}
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(views)
+	migrations.Append(&source.Migration{Direction: source.Repeatable, Identifier: "functions", Raw: "CREATE FUNCTION f1"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"CREATE 1", "CREATE FUNCTION f1", "CREATE VIEW v1"}
+	if !reflect.DeepEqual(dbDrv.MigrationSequence, expected) {
+		t.Fatalf("expected %v, got %v", expected, dbDrv.MigrationSequence)
+	}
+
+	// unchanged repeatables don't run again
+	if err := m.Up(); err != ErrNoChange {
+		t.Fatalf("expected ErrNoChange, got %v", err)
+	}
+
+	views.Raw = "CREATE VIEW v2"
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, "CREATE VIEW v2")
+	if !reflect.DeepEqual(dbDrv.MigrationSequence, expected) {
+		t.Fatalf("expected %v, got %v", expected, dbDrv.MigrationSequence)
+	}
+
+	history, err := m.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := history[len(history)-1]
+	if last.Direction != database.DirectionRepeatable || last.Identifier != "views" || last.Version != 1 {
+		t.Fatalf("expected repeatable views at version 1, got %+v", last)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestUpRepeatableNoHistory(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Direction: source.Repeatable, Identifier: "views", Raw: "CREATE VIEW v1"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	// without a history, repeatables run every time
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"CREATE 1", "CREATE VIEW v1", "CREATE VIEW v1"}
+	if !reflect.DeepEqual(dbDrv.MigrationSequence, expected) {
+		t.Fatalf("expected %v, got %v", expected, dbDrv.MigrationSequence)
+	}
+	if dbDrv.CurrentVersion != 1 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 1, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+}
+
+func TestUpRepeatableInTransaction(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{HistoryTable: "history"})
+	dbDrv := &atomicStub{Stub: dbInst.(*dStub.Stub)}
+	m, _ := NewWithDatabaseInstance("stub://", "stub", dbDrv)
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Direction: source.Repeatable, Identifier: "views", Raw: "CREATE VIEW v1"})
+	migrations.Append(&source.Migration{Direction: source.Repeatable, Identifier: "functions", Raw: "CREATE FUNCTION f1"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	// every repeatable runs in a transaction of its own
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"begin", "commit", "begin", "commit"}
+	if !reflect.DeepEqual(dbDrv.Calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, dbDrv.Calls)
+	}
+	if len(dbDrv.HistoryEntries) != 3 {
+		t.Fatalf("expected 3 history entries, got %v", dbDrv.HistoryEntries)
+	}
+
+	// unless they run as atomic migrations
+	dbDrv.Calls = nil
+	migrations.Append(&source.Migration{Direction: source.Repeatable, Identifier: "procedures", Raw: "CREATE PROCEDURE p1"})
+	m.Atomic = true
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"begin", "commit"}
+	if !reflect.DeepEqual(dbDrv.Calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, dbDrv.Calls)
+	}
+}
+
+func TestVars(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE ${schema}.t1 {{ .suffix }} ${undefined}"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE ${schema}.t2 ${undefined}"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	m.Vars = map[string]string{"schema": "tenant_a", "suffix": "x"}
+	plan, err := m.PlanSteps(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(plan[0].BufferedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "CREATE tenant_a.t1 x ${undefined}" {
+		t.Fatalf("expected variables to be replaced in plan, got %q", body)
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"CREATE tenant_a.t1 x ${undefined}"}
+	if !reflect.DeepEqual(dbDrv.MigrationSequence, expected) {
+		t.Fatalf("expected %v, got %v", expected, dbDrv.MigrationSequence)
+	}
+
+	// checksums are computed before replacing variables
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	m.StrictVars = true
+	err = m.Up()
+	e, ok := err.(ErrMigrationFailed)
+	if !ok || e.Err != (ErrUndefinedVar{Name: "undefined"}) {
+		t.Fatalf("expected ErrUndefinedVar, got %v", err)
+	}
+}
+
+func TestUpOutOfOrder(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 4, Direction: source.Up, Identifier: "CREATE 4"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// merge migrations with lower versions
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Down, Identifier: "DROP 3"})
+
+	err := m.Up()
+	if e, ok := err.(ErrOutOfOrder); !ok {
+		t.Fatalf("expected ErrOutOfOrder, got %v", err)
+	} else if len(e.Versions) != 2 || e.Versions[0] != 2 || e.Versions[1] != 3 {
+		t.Fatalf("expected versions 2 and 3, got %v", e.Versions)
+	}
+	if _, ok := m.Steps(1).(ErrOutOfOrder); !ok {
+		t.Fatal("expected Steps to fail with ErrOutOfOrder")
+	}
+
+	m.AllowOutOfOrder = true
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "CREATE 4", "CREATE 2"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if dbDrv.CurrentVersion != 4 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 4, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+
+	if err := m.Up(); err != ErrNoChange {
+		t.Fatalf("expected ErrNoChange, got %v", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Down, Identifier: "DROP 1"})
+	migrations.Append(&source.Migration{Version: 4, Direction: source.Up, Identifier: "CREATE 4"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+	migrations.Append(&source.Migration{Version: 5, Direction: source.Down, Identifier: "DROP 5"})
+
+	type status struct {
+		version                                 uint
+		hasUp, hasDown, applied, current, dirty bool
+		missing                                 bool
+	}
+	tt := []struct {
+		name     string
+		setup    func()
+		expected []status
+	}{
+		{"applied and pending", func() {}, []status{
+			{1, true, true, true, false, false, false},
+			{2, true, false, false, false, false, false},
+			{4, true, false, true, true, false, false},
+			{5, false, true, false, false, false, false},
+		}},
+		{"missing in source", func() {
+			migrations := source.NewMigrations()
+			migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+			m.sourceDrv.(*sStub.Stub).Migrations = migrations
+			m.databaseDrv.(*dStub.Stub).IsDirty = true
+		}, []status{
+			{1, false, false, true, false, false, true},
+			{2, true, false, false, false, false, false},
+			{4, false, false, true, true, true, true},
+		}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.setup()
+			statuses, err := m.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]status, 0, len(statuses))
+			for _, s := range statuses {
+				got = append(got, status{s.Version, s.HasUp, s.HasDown, s.Applied, s.Current, s.Dirty, s.Missing})
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Migrate(4); err != nil {
+		t.Fatal(err)
+	}
+	seq := newMigSeq(M(1), M(3), M(4))
+
+	tt := []struct {
+		plan      func() ([]*Migration, error)
+		expectErr error
+		expectSeq migrationSequence
+	}{
+		{plan: m.PlanUp, expectSeq: newMigSeq(M(5), M(7))},
+		{plan: m.PlanDown, expectSeq: newMigSeq(M(4, 3), M(3, 1), M(1, -1))},
+		{plan: func() ([]*Migration, error) { return m.PlanSteps(1) }, expectSeq: newMigSeq(M(5))},
+		{plan: func() ([]*Migration, error) { return m.PlanSteps(-2) }, expectSeq: newMigSeq(M(4, 3), M(3, 1))},
+		{plan: func() ([]*Migration, error) { return m.PlanSteps(0) }, expectErr: ErrNoChange},
+		{plan: func() ([]*Migration, error) { return m.PlanMigrate(7) }, expectSeq: newMigSeq(M(5), M(7))},
+		{plan: func() ([]*Migration, error) { return m.PlanMigrate(1) }, expectSeq: newMigSeq(M(4, 3), M(3, 1))},
+		{plan: func() ([]*Migration, error) { return m.PlanMigrate(4) }, expectErr: ErrNoChange},
+		{plan: func() ([]*Migration, error) { return m.PlanMigrate(2) }, expectErr: os.ErrNotExist},
+	}
+
+	for i, v := range tt {
+		migrations, err := v.plan()
+		if err != v.expectErr {
+			t.Errorf("expected err %v, got %v, in %v", v.expectErr, err, i)
+		}
+		if v.expectErr == nil {
+			equalMigSeq(t, i, v.expectSeq, migrations)
+		}
+
+		// database must not be touched
+		equalDbSeq(t, i, seq, dbDrv)
+		if dbDrv.CurrentVersion != 4 || dbDrv.IsLocked {
+			t.Fatalf("expected unlocked version 4, got %v (locked %v), in %v", dbDrv.CurrentVersion, dbDrv.IsLocked, i)
+		}
+	}
+}
+
+func TestPlanBody(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	plan, err := m.PlanUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 {
+		t.Fatalf("expected 2 migrations, got %v", len(plan))
+	}
+	for i, expected := range []string{"CREATE 1", "CREATE 2"} {
+		body, err := ioutil.ReadAll(plan[i].BufferedBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != expected {
+			t.Errorf("expected body %q, got %q", expected, body)
+		}
+	}
+}
+
+func TestPlanUpRepeatable(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Direction: source.Repeatable, Identifier: "views", Raw: "CREATE VIEW ${name}"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	m.Vars = map[string]string{"name": "v1"}
+
+	plan, err := m.PlanUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || plan[0].Repeatable || !plan[1].Repeatable {
+		t.Fatalf("expected version 1 and repeatable views, got %v", plan)
+	}
+	if s := plan[1].LogString(); s != "R views" || plan[1].TargetVersion != 1 {
+		t.Fatalf("expected R views at version 1, got %v (=> %v)", s, plan[1].TargetVersion)
+	}
+	body, err := ioutil.ReadAll(plan[1].BufferedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "CREATE VIEW v1" {
+		t.Fatalf("expected body CREATE VIEW v1, got %q", body)
+	}
+
+	// PlanSteps leaves repeatables out, like Steps
+	if plan, err := m.PlanSteps(1); err != nil || len(plan) != 1 {
+		t.Fatalf("expected version 1, got %v (%v)", plan, err)
+	}
+
+	// after Up, there is nothing left to plan
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.PlanUp(); err != ErrNoChange {
+		t.Fatalf("expected ErrNoChange, got %v", err)
+	}
+}
+
+func TestPlanDirty(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+	if err := dbDrv.SetVersion(0, true); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := m.PlanUp()
+	if _, ok := err.(ErrDirty); !ok {
+		t.Fatalf("expected ErrDirty, got %v", err)
+	}
+}
+
+func TestPlanUndefinedVarStopsReader(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE ${undefined}"})
+	for v := uint(2); v <= 100; v++ {
+		migrations.Append(&source.Migration{Version: v, Direction: source.Up, Identifier: fmt.Sprintf("CREATE %v", v)})
+	}
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	m.StrictVars = true
+	m.PrefetchMigrations = 1
+
+	goroutines := runtime.NumGoroutine()
+	if _, err := m.PlanUpContext(context.Background()); err != (ErrUndefinedVar{Name: "undefined"}) {
+		t.Fatalf("expected ErrUndefinedVar, got %v", err)
+	}
+
+	// the reader and the buffering goroutines are stopped
+	for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+		if i == 100 {
+			t.Fatalf("expected %v goroutines, got %v", goroutines, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.StrictVars = false
+	if _, err := m.PlanUpContext(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// txStub is a database stub that implements database.TransactionalDriver.
+type txStub struct {
+	*dStub.Stub
+	RunWithVersionSequence []string
+}
+
+func (s *txStub) RunWithVersion(ctx context.Context, migration io.Reader, version int) error {
+	if err := s.Run(migration); err != nil {
+		return err
+	}
+	s.RunWithVersionSequence = append(s.RunWithVersionSequence, string(s.LastRunMigration))
+	return s.SetVersion(version, false)
+}
+
+func TestUpTransactional(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	dbDrv := &txStub{Stub: dbInst.(*dStub.Stub)}
+	m, _ := NewWithDatabaseInstance("stub://", "stub", dbDrv)
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "-- migrate:no-transaction\nCREATE 2"})
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Up})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "-- migrate:no-transaction\nCREATE 2", ""}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if len(dbDrv.RunWithVersionSequence) != 2 || dbDrv.RunWithVersionSequence[0] != "CREATE 1" || dbDrv.RunWithVersionSequence[1] != "" {
+		t.Fatalf("expected 1 and 3 to run in transaction, got %v", dbDrv.RunWithVersionSequence)
+	}
+	if dbDrv.CurrentVersion != 3 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 3, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+}
+
+// atomicStub is a database stub that implements database.AtomicDriver.
+// Rollback restores the version and migration sequence from Begin.
+type atomicStub struct {
+	*dStub.Stub
+	Calls []string
+
+	version  int
+	dirty    bool
+	sequence []string
+}
+
+func (s *atomicStub) Begin(ctx context.Context) error {
+	s.Calls = append(s.Calls, "begin")
+	s.version, s.dirty = s.CurrentVersion, s.IsDirty
+	s.sequence = append([]string{}, s.MigrationSequence...)
+	return nil
+}
+
+func (s *atomicStub) Commit() error {
+	s.Calls = append(s.Calls, "commit")
+	return nil
+}
+
+func (s *atomicStub) Rollback() error {
+	s.Calls = append(s.Calls, "rollback")
+	s.CurrentVersion, s.IsDirty = s.version, s.dirty
+	s.MigrationSequence = s.sequence
+	return nil
+}
+
+func TestUpAtomic(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	dbDrv := &atomicStub{Stub: dbInst.(*dStub.Stub)}
+	m, _ := NewWithDatabaseInstance("stub://", "stub", dbDrv)
+	m.Atomic = true
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "-- migrate:no-transaction\nCREATE 2"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	if err := m.Up(); err == nil {
+		t.Fatal("expected error for migration with no-transaction header")
+	}
+	if len(dbDrv.Calls) != 2 || dbDrv.Calls[0] != "begin" || dbDrv.Calls[1] != "rollback" {
+		t.Fatalf("expected begin and rollback, got %v", dbDrv.Calls)
+	}
+	if dbDrv.CurrentVersion != -1 || dbDrv.IsDirty || len(dbDrv.MigrationSequence) != 0 {
+		t.Fatalf("expected nothing to be applied, got version %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+
+	migrations = source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	dbDrv.Calls = nil
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if len(dbDrv.Calls) != 2 || dbDrv.Calls[0] != "begin" || dbDrv.Calls[1] != "commit" {
+		t.Fatalf("expected begin and commit, got %v", dbDrv.Calls)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "CREATE 2"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if dbDrv.CurrentVersion != 2 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 2, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+}
+
+// failStub is a database stub whose Run always fails.
+type failStub struct {
+	*dStub.Stub
+}
+
+func (s *failStub) Run(migration io.Reader) error {
+	return &database.Error{OrigErr: fmt.Errorf("syntax error"), Err: "migration failed", Line: 1}
+}
+
+func TestUpFailedStopsReader(t *testing.T) {
+	migrations := source.NewMigrations()
+	for v := uint(1); v <= 100; v++ {
+		migrations.Append(&source.Migration{Version: v, Direction: source.Up, Identifier: fmt.Sprintf("CREATE %v", v)})
+	}
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	m, _ := NewWithDatabaseInstance("stub://", "stub", &failStub{Stub: dbInst.(*dStub.Stub)})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	m.PrefetchMigrations = 1
+
+	goroutines := runtime.NumGoroutine()
+	if err := m.Up(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// the reader and the buffering goroutines are stopped
+	for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+		if i == 100 {
+			t.Fatalf("expected %v goroutines, got %v", goroutines, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUpAtomicNotSupported(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.Atomic = true
+	if err := m.Up(); err != ErrAtomicNotSupported {
+		t.Fatalf("expected ErrAtomicNotSupported, got %v", err)
+	}
+}
+
+// txHistoryStub is an atomicStub that implements database.TransactionalDriver
+// and whose history fails to record the version in failVersion.
+type txHistoryStub struct {
+	*atomicStub
+	failVersion uint
+}
+
+func (s *txHistoryStub) RunWithVersion(ctx context.Context, migration io.Reader, version int) error {
+	return fmt.Errorf("expected the migration to run in a transaction started by Begin")
+}
+
+func (s *txHistoryStub) AppendHistory(entry database.HistoryEntry) error {
+	if entry.Version == s.failVersion {
+		return fmt.Errorf("history insert failed")
+	}
+	return s.Stub.AppendHistory(entry)
+}
+
+func TestUpHistoryInTransaction(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{HistoryTable: "schema_history"})
+	dbDrv := &txHistoryStub{atomicStub: &atomicStub{Stub: dbInst.(*dStub.Stub)}, failVersion: 3}
+	m, _ := NewWithDatabaseInstance("stub://", "stub", dbDrv)
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+
+	// the migration is rolled back together with its history entry
+	if err := m.Up(); err == nil {
+		t.Fatal("expected history error")
+	}
+	expected := []string{"begin", "commit", "begin", "rollback"}
+	if !reflect.DeepEqual(dbDrv.Calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, dbDrv.Calls)
+	}
+	if dbDrv.CurrentVersion != 1 || dbDrv.IsDirty || len(dbDrv.MigrationSequence) != 1 {
+		t.Fatalf("expected clean version 1, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+	if len(dbDrv.HistoryEntries) != 1 || dbDrv.HistoryEntries[0].Version != 1 {
+		t.Fatalf("expected history entry for version 1, got %v", dbDrv.HistoryEntries)
+	}
+}
+
+// txAtomicStub is an atomicStub that implements database.TxHandler.
+type txAtomicStub struct {
+	*atomicStub
+}
+
+func (s *txAtomicStub) TxHandle() interface{} {
+	return s.atomicStub
+}
+
+func TestGoMigrationAtomic(t *testing.T) {
+	var handles []interface{}
+	goMigrations := NewGoMigrations()
+	goMigrations.Register(2, func(ctx context.Context, handle interface{}) error {
+		handles = append(handles, handle)
+		return nil
+	}, nil)
+
+	// without the transaction, the Go migration can't run
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	dbDrv := &atomicStub{Stub: dbInst.(*dStub.Stub)}
+	m, _ := NewWithDatabaseInstance("stub://", "stub", dbDrv)
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.GoMigrations = goMigrations
+	m.Atomic = true
+	if err := m.Steps(2); err == nil {
+		t.Fatal("expected error for Go migration without transaction")
+	}
+	if len(handles) != 0 {
+		t.Fatalf("expected Go migration not to run, got %v", handles)
+	}
+	if !reflect.DeepEqual(dbDrv.Calls, []string{"begin", "rollback"}) {
+		t.Fatalf("expected begin and rollback, got %v", dbDrv.Calls)
+	}
+	if dbDrv.CurrentVersion != -1 || dbDrv.IsDirty || len(dbDrv.MigrationSequence) != 0 {
+		t.Fatalf("expected nothing to be applied, got version %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+
+	// the Go migration gets the transaction
+	dbInst, _ = dStub.WithInstance(nil, &dStub.Config{})
+	txDrv := &txAtomicStub{&atomicStub{Stub: dbInst.(*dStub.Stub)}}
+	m, _ = NewWithDatabaseInstance("stub://", "stub", txDrv)
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.GoMigrations = goMigrations
+	m.Atomic = true
+	if err := m.Steps(2); err != nil {
+		t.Fatal(err)
+	}
+	if len(handles) != 1 || handles[0] != txDrv.atomicStub {
+		t.Fatalf("expected the transaction as handle, got %v", handles)
+	}
+	if !reflect.DeepEqual(txDrv.Calls, []string{"begin", "commit"}) {
+		t.Fatalf("expected begin and commit, got %v", txDrv.Calls)
+	}
+	if txDrv.CurrentVersion != 2 || txDrv.IsDirty {
+		t.Fatalf("expected clean version 2, got %v (dirty %v)", txDrv.CurrentVersion, txDrv.IsDirty)
+	}
+}
+
+type migrationLoggerStub struct {
+	events []string
+}
+
+func (l *migrationLoggerStub) Printf(format string, v ...interface{}) {
+	l.events = append(l.events, "printf")
+}
+
+func (l *migrationLoggerStub) Verbose() bool {
+	return false
+}
+
+func (l *migrationLoggerStub) StartMigration(migr *Migration) {
+	l.events = append(l.events, fmt.Sprintf("start %v", migr.LogString()))
+}
+
+func (l *migrationLoggerStub) FinishMigration(migr *Migration, readTime, runTime time.Duration) {
+	l.events = append(l.events, fmt.Sprintf("finish %v %v", migr.LogString(), migr.BytesRead))
+}
+
+func TestMigrationLogger(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Down, Identifier: "DROP 1"})
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Up, Identifier: "CREATE 3"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	l := &migrationLoggerStub{}
+	m.Log = l
+
+	if err := m.Steps(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Steps(-2); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"start 1/u 1.up.stub", "finish 1/u 1.up.stub 8",
+		"start 3/u 3.up.stub", "finish 3/u 3.up.stub 8",
+		"start 3/d <empty>", "finish 3/d <empty> 0",
+		"start 1/d 1.down.stub", "finish 1/d 1.down.stub 6",
+	}
+	if !reflect.DeepEqual(l.events, expected) {
+		t.Fatalf("expected %q, got %q", expected, l.events)
+	}
+}
+
+func TestRedo(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+	seq := newMigSeq()
+
+	if err := m.Redo(1); err != ErrNilVersion {
+		t.Fatalf("expected ErrNilVersion, got %v", err)
+	}
+
+	if err := m.Steps(3); err != nil {
 		t.Fatal(err)
 	}
+	seq.add(M(1), M(3), M(4))
 
-	err := m.Up()
-	if _, ok := err.(ErrDirty); !ok {
-		t.Fatalf("expected ErrDirty, got %v", err)
+	tt := []struct {
+		n         int
+		expectErr error
+		expectSeq migrationSequence
+	}{
+		{n: 0, expectErr: ErrNoChange, expectSeq: seq.add()},
+		{n: 1, expectErr: nil, expectSeq: seq.add(M(4, 3), M(4))},
+		{n: 2, expectErr: nil, expectSeq: seq.add(M(4, 3), M(3, 1), M(3), M(4))},
+		{n: 5, expectErr: ErrShortLimit{2}, expectSeq: seq.add(M(4, 3), M(3, 1), M(1, -1), M(1), M(3), M(4))},
+	}
+
+	for i, v := range tt {
+		if err := m.Redo(v.n); err != v.expectErr {
+			t.Fatalf("expected err %v, got %v, in %v", v.expectErr, err, i)
+		}
+
+		version, dirty, err := m.Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 4 || dirty {
+			t.Fatalf("expected clean version 4, got %v (dirty %v), in %v", version, dirty, i)
+		}
+
+		equalDbSeq(t, i, v.expectSeq, dbDrv)
 	}
 }
 
-func TestDownDirty(t *testing.T) {
+func TestVerifyReversible(t *testing.T) {
 	m, _ := New("stub://", "stub://")
 	dbDrv := m.databaseDrv.(*dStub.Stub)
-	if err := dbDrv.SetVersion(0, true); err != nil {
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Down, Identifier: "DROP 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Down, Identifier: "DROP 2"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	if err := m.VerifyReversible(); err != nil {
 		t.Fatal(err)
 	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "DROP 1", "CREATE 1", "CREATE 2", "DROP 2", "CREATE 2"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if dbDrv.CurrentVersion != 2 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 2, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
 
-	err := m.Down()
-	if _, ok := err.(ErrDirty); !ok {
-		t.Fatalf("expected ErrDirty, got %v", err)
+	if err := m.VerifyReversible(); err != ErrNoChange {
+		t.Fatalf("expected ErrNoChange, got %v", err)
 	}
 }
 
-func TestDrop(t *testing.T) {
+func TestVerifyReversibleNoOpDown(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Down, Identifier: "DROP 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Down, Identifier: "SELECT 1"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	err := m.VerifyReversible()
+	if e, ok := err.(ErrIrreversible); !ok || e.Version != 2 {
+		t.Fatalf("expected ErrIrreversible for version 2, got %v", err)
+	}
+}
+
+func TestVerifyReversibleMissingDown(t *testing.T) {
 	m, _ := New("stub://", "stub://")
 	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
 	dbDrv := m.databaseDrv.(*dStub.Stub)
 
-	if err := m.Drop(); err != nil {
+	err := m.VerifyReversible()
+	if e, ok := err.(ErrIrreversible); !ok || e.Version != 3 {
+		t.Fatalf("expected ErrIrreversible for version 3, got %v", err)
+	}
+	equalDbSeq(t, 0, newMigSeq(M(1), M(1, -1), M(1), M(3)), dbDrv)
+}
+
+func TestBaseline(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-history-table=schema_history")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Baseline(2); !os.IsNotExist(err) {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+
+	if err := m.Baseline(4); err != nil {
 		t.Fatal(err)
 	}
+	if len(dbDrv.MigrationSequence) != 0 {
+		t.Fatalf("expected no migrations to run, got %v", dbDrv.MigrationSequence)
+	}
+	if dbDrv.CurrentVersion != 4 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 4, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
 
-	if dbDrv.MigrationSequence[len(dbDrv.MigrationSequence)-1] != dStub.DROP {
-		t.Fatalf("expected database to DROP, got sequence %v", dbDrv.MigrationSequence)
+	history, err := m.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].Version != 1 || history[1].Version != 3 || history[2].Version != 4 {
+		t.Fatalf("expected history for versions 1, 3 and 4, got %+v", history)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected baseline to validate, got %v", err)
+	}
+
+	if err := m.Baseline(4); err != (ErrHasVersion{4}) {
+		t.Fatalf("expected ErrHasVersion, got %v", err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	equalDbSeq(t, 0, newMigSeq(M(5), M(7)), dbDrv)
+}
+
+func TestDump(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "CREATE 2"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := m.Dump(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "CREATE 1\nCREATE 2\n" {
+		t.Fatalf("unexpected dump %q", b.String())
+	}
+}
+
+func TestDumpNotSupported(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	m, _ := NewWithDatabaseInstance("stub://", "stub", struct{ database.Driver }{dbInst})
+	if err := m.Dump(ioutil.Discard); err != ErrDumpNotSupported {
+		t.Fatalf("expected ErrDumpNotSupported, got %v", err)
 	}
 }
 
@@ -674,7 +1889,7 @@ func TestRead(t *testing.T) {
 
 	for i, v := range tt {
 		ret := make(chan interface{})
-		go m.read(v.from, v.to, ret)
+		go m.read(context.Background(), v.from, v.to, ret)
 		migrations, err := migrationsFromChannel(ret)
 
 		if (v.expectErr == os.ErrNotExist && !os.IsNotExist(err)) ||
@@ -751,7 +1966,7 @@ func TestReadUp(t *testing.T) {
 
 	for i, v := range tt {
 		ret := make(chan interface{})
-		go m.readUp(v.from, v.limit, ret)
+		go m.readUp(context.Background(), v.from, v.limit, ret)
 		migrations, err := migrationsFromChannel(ret)
 
 		if (v.expectErr == os.ErrNotExist && !os.IsNotExist(err)) ||
@@ -828,7 +2043,7 @@ func TestReadDown(t *testing.T) {
 
 	for i, v := range tt {
 		ret := make(chan interface{})
-		go m.readDown(v.from, v.limit, ret)
+		go m.readDown(context.Background(), v.from, v.limit, ret)
 		migrations, err := migrationsFromChannel(ret)
 
 		if (v.expectErr == os.ErrNotExist && !os.IsNotExist(err)) ||
@@ -842,17 +2057,339 @@ func TestReadDown(t *testing.T) {
 	}
 }
 
+// mustWriteMigrations writes files to a temporary directory
+// and returns a file source URL for it.
+func mustWriteMigrations(t *testing.T, files map[string]string) (url string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return "file://" + dir, func() { os.RemoveAll(dir) }
+}
+
+func TestForEachSchema(t *testing.T) {
+	sourceUrl, cleanup := mustWriteMigrations(t, map[string]string{
+		"1_a.up.sql": "CREATE 1",
+		"7_b.up.sql": "CREATE 7",
+	})
+	defer cleanup()
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+
+	schemas := Schemas{Names: []string{"a", "b", "c", "d"}}
+	results, err := ForEachSchema(sourceUrl, "stub://", schemas, 2, func(schema string, m *Migrate) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		if schema == "b" {
+			return fmt.Errorf("failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+		return m.Up()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 schemas at the same time, got %v", maxRunning)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %+v", results)
+	}
+	for i, r := range results {
+		if r.Schema != schemas.Names[i] {
+			t.Errorf("expected schema %v, got %v", schemas.Names[i], r.Schema)
+		}
+		if r.Schema == "b" {
+			if r.Err == nil || r.Version != database.NilVersion {
+				t.Errorf("expected b to fail without version, got %+v", r)
+			}
+		} else if r.Err != nil || r.Version != 7 {
+			t.Errorf("expected %v to be migrated to version 7, got %+v", r.Schema, r)
+		}
+	}
+
+	if _, err := ForEachSchema(sourceUrl, "stub://", Schemas{Pattern: "tenant_%"}, 2, nil); err == nil {
+		t.Error("expected error, stub doesn't support schemas")
+	}
+	if _, err := ForEachSchema(sourceUrl, "stub://", Schemas{}, 2, nil); err != ErrNoSchemas {
+		t.Errorf("expected ErrNoSchemas, got %v", err)
+	}
+}
+
+func TestForEachDatabase(t *testing.T) {
+	sourceUrl, cleanup := mustWriteMigrations(t, map[string]string{
+		"1_a.up.sql": "CREATE 1",
+		"3_b.up.sql": "CREATE 3",
+	})
+	defer cleanup()
+
+	urls := []string{"stub://1", "stub://2", "stub://3"}
+	fn := func(databaseUrl string, m *Migrate) error {
+		if databaseUrl == "stub://2" {
+			return fmt.Errorf("failed")
+		}
+		if err := m.Up(); err != nil {
+			return err
+		}
+		// every database runs the migrations
+		expected := []string{"CREATE 1", "CREATE 3"}
+		if seq := m.databaseDrv.(*dStub.Stub).MigrationSequence; !reflect.DeepEqual(seq, expected) {
+			return fmt.Errorf("expected %v, got %v", expected, seq)
+		}
+		return nil
+	}
+
+	results, err := ForEachDatabase(sourceUrl, urls, 2, false, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r.DatabaseUrl != urls[i] {
+			t.Errorf("expected %v, got %v", urls[i], r.DatabaseUrl)
+		}
+		if i == 1 && r.Err == nil {
+			t.Errorf("expected %v to fail", r.DatabaseUrl)
+		} else if i != 1 && (r.Err != nil || r.Version != 3) {
+			t.Errorf("expected %v to be migrated to version 3, got %+v", r.DatabaseUrl, r)
+		}
+	}
+
+	// fail fast
+	results, err = ForEachDatabase(sourceUrl, urls, 1, true, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err != ErrSkipped {
+		t.Errorf("expected 3 to be skipped after 2 failed, got %+v", results)
+	}
+}
+
 func TestLock(t *testing.T) {
 	m, _ := New("stub://", "stub://")
-	if err := m.lock(); err != nil {
+	if err := m.lock(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := m.lock(); err == nil {
+	if err := m.lock(context.Background()); err == nil {
 		t.Fatal("should be locked already")
 	}
 }
 
+func TestForceUnlock(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-lock-table=schema_lock")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.LockTimeout = 100 * time.Millisecond
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	// a lock left behind by a crashed process
+	now := time.Now()
+	crashed := database.LockInfo{LockId: "schema_lock", Owner: "crashed", Hostname: "worker-1", Pid: 42, AcquiredAt: now, HeartbeatAt: now}
+	if _, err := dbDrv.LockStore.InsertLock(context.Background(), crashed); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != ErrLockTimeout {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+
+	holder, err := m.LockHolder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder == nil || holder.Hostname != "worker-1" || holder.Pid != 42 {
+		t.Fatalf("expected lock held by pid 42 on worker-1, got %v", holder)
+	}
+
+	holder, err = m.ForceUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder == nil || holder.Owner != "crashed" {
+		t.Fatalf("expected the crashed lock to be removed, got %v", holder)
+	}
+	if holder, err := m.ForceUnlock(); err != nil || holder != nil {
+		t.Fatalf("expected no lock, got %v, %v", holder, err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := m.LockHolder(); err != nil || holder != nil {
+		t.Fatalf("expected the lock to be released, got %v, %v", holder, err)
+	}
+}
+
+func TestUpLockLost(t *testing.T) {
+	m, _ := New("stub://", "stub://?x-lock-table=schema_lock&x-lock-stale-after=40ms")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	// the lock is removed while the first migration runs
+	m.Hooks.AfterEach = func(migr *Migration, readTime, runTime time.Duration) error {
+		if migr.Version == 1 {
+			if _, err := dbDrv.ForceUnlock(context.Background()); err != nil {
+				return err
+			}
+			<-dbDrv.LockLost()
+		}
+		return nil
+	}
+
+	if err := m.Up(); err != database.ErrLockLost {
+		t.Fatalf("expected ErrLockLost, got %v", err)
+	}
+	if dbDrv.CurrentVersion != 1 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 1, got %v (dirty %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+
+	// the lost lock isn't held anymore
+	m.Hooks.AfterEach = nil
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if dbDrv.CurrentVersion != 7 {
+		t.Fatalf("expected version 7, got %v", dbDrv.CurrentVersion)
+	}
+}
+
+func TestForceUnlockNotSupported(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	m, _ := NewWithDatabaseInstance("stub://", "stub", struct{ database.Driver }{dbInst})
+	if _, err := m.ForceUnlock(); err != ErrNoLockTable {
+		t.Fatalf("expected ErrNoLockTable, got %v", err)
+	}
+}
+
+// heldStub is a database driver whose lock is held by someone else
+// for the first held calls to Lock, and which fails with lockErr then.
+type heldStub struct {
+	*dStub.Stub
+	mu      sync.Mutex
+	held    int
+	lockErr error
+	// onRelease is called when the lock is released by someone else
+	onRelease func()
+}
+
+// set sets held and lockErr. Lock may still be called
+// by the goroutine of a timed out lock.
+func (s *heldStub) set(held int, lockErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.held = held
+	s.lockErr = lockErr
+}
+
+func (s *heldStub) Lock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held > 0 {
+		s.held--
+		if s.held == 0 && s.onRelease != nil {
+			s.onRelease()
+		}
+		return database.ErrLockHeld{Holder: "pid 42 on worker-1"}
+	}
+	if s.lockErr != nil {
+		return s.lockErr
+	}
+	return s.Stub.Lock()
+}
+
+func TestLockRetry(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	dbDrv := &heldStub{Stub: dbInst.(*dStub.Stub), held: 3}
+	m, _ := NewWithDatabaseInstance("stub://", "stub", dbDrv)
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.LockRetryInterval = time.Millisecond
+
+	// another process migrates up while holding the lock
+	dbDrv.onRelease = func() {
+		dbDrv.SetVersion(7, false)
+	}
+
+	// the version is read after acquiring the lock
+	if err := m.Up(); err != ErrNoChange {
+		t.Fatalf("expected ErrNoChange, got %v", err)
+	}
+	if dbDrv.held != 0 || len(dbDrv.MigrationSequence) != 0 {
+		t.Fatalf("expected to wait for the lock and run nothing, got %v", dbDrv.MigrationSequence)
+	}
+}
+
+func TestLockRetryTimeout(t *testing.T) {
+	dbInst, _ := dStub.WithInstance(nil, &dStub.Config{})
+	dbDrv := &heldStub{Stub: dbInst.(*dStub.Stub), held: 1 << 30}
+	m, _ := NewWithDatabaseInstance("stub://", "stub", dbDrv)
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.LockTimeout = 50 * time.Millisecond
+	m.LockRetryInterval = time.Millisecond
+
+	if err := m.Up(); err != ErrLockTimeout {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+
+	// other errors aren't retried
+	lockErr := fmt.Errorf("connection refused")
+	dbDrv.set(0, lockErr)
+	if err := m.Up(); err != lockErr {
+		t.Fatalf("expected lock error, got %v", err)
+	}
+
+	// a lock already held by the driver itself isn't retried either
+	dbDrv.set(0, database.ErrLocked)
+	if err := m.Up(); err != database.ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	// without retries, a held lock fails right away
+	dbDrv.set(1<<30, nil)
+	m.LockRetryInterval = 0
+	if err := m.Up(); !isLockHeld(err) {
+		t.Fatalf("expected ErrLockHeld, got %v", err)
+	}
+}
+
+func TestIsLockHeld(t *testing.T) {
+	held := database.ErrLockHeld{Holder: "pid 42 on worker-1"}
+	tt := []struct {
+		err  error
+		held bool
+	}{
+		{held, true},
+		{&database.Error{OrigErr: held, Err: "try lock failed"}, true},
+		{NewMultiError(fmt.Errorf("connection reset"), held), true},
+		{NewMultiError(&database.Error{OrigErr: held}), true},
+		{database.ErrLocked, false},
+		{&database.Error{OrigErr: database.ErrLocked}, false},
+		{NewMultiError(database.ErrLocked, fmt.Errorf("connection reset")), false},
+		{fmt.Errorf("connection refused"), false},
+		{nil, false},
+	}
+	for i, v := range tt {
+		if held := isLockHeld(v.err); held != v.held {
+			t.Errorf("%v: expected %v for %v, got %v", i, v.held, v.err, held)
+		}
+	}
+}
+
 func migrationsFromChannel(ret chan interface{}) ([]*Migration, error) {
 	slice := make([]*Migration, 0)
 	for r := range ret {
@@ -909,7 +2446,7 @@ func M(version uint, targetVersion ...int) *Migration {
 
 	m, _ := New("stub://", "stub://")
 	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
-	migr, err := m.newMigration(version, ts)
+	migr, err := m.newMigration(context.Background(), version, ts)
 	if err != nil {
 		panic(err)
 	}
@@ -2,11 +2,29 @@ package migrate
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"strings"
 	"time"
+
+	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/source"
 )
 
+// NoTransactionHeader opts a migration out of running in a transaction,
+// if it is found in the leading comment lines of the migration. Use it for
+// statements that can't run inside a transaction, i.e. CREATE INDEX CONCURRENTLY
+// in postgres. See database.TransactionalDriver. source.NoTransactionMarker,
+// written by parsers, has the same effect.
+var NoTransactionHeader = "-- migrate:no-transaction"
+
+// SquashedHeader marks a migration that replaces all migrations up to
+// and including its version, i.e. one written by `migrate squash`.
+// Applied migrations up to its version are not validated anymore.
+var SquashedHeader = "-- migrate:squashed"
+
 // DefaultBufferSize sets the in memory buffer size (in Bytes) for every
 // pre-read migration (see DefaultPrefetchMigrations).
 var DefaultBufferSize = uint(100000)
@@ -54,6 +72,17 @@ type Migration struct {
 
 	// BytesRead holds the number of Bytes read from the migration source.
 	BytesRead int64
+
+	// Checksum holds the hex encoded SHA-256 checksum of the migration source.
+	// It is computed while buffering and set once the source is fully read.
+	Checksum string
+
+	// Repeatable is set for repeatable migrations, which have no version
+	// of their own. Version and TargetVersion are the version they run at.
+	Repeatable bool
+
+	// goFunc is run instead of the body for Go migrations.
+	goFunc GoMigrationFunc
 }
 
 // NewMigration returns a new Migration and sets the body, identifier,
@@ -109,6 +138,9 @@ func (m *Migration) String() string {
 
 // LogString returns a string describing this migration to humans.
 func (m *Migration) LogString() string {
+	if m.Repeatable {
+		return fmt.Sprintf("R %v", m.Identifier)
+	}
 	directionStr := "u"
 	if m.TargetVersion < int(m.Version) {
 		directionStr = "d"
@@ -116,6 +148,25 @@ func (m *Migration) LogString() string {
 	return fmt.Sprintf("%v/%v %v", m.Version, directionStr, m.Identifier)
 }
 
+// direction returns if this is an up, a down or a repeatable migration.
+func (m *Migration) direction() database.Direction {
+	if m.Repeatable {
+		return database.DirectionRepeatable
+	}
+	if m.TargetVersion < int(m.Version) {
+		return database.DirectionDown
+	}
+	return database.DirectionUp
+}
+
+// discard closes BufferedBody of a migration that won't be run,
+// so Buffer stops waiting for it to be read.
+func (m *Migration) discard() {
+	if c, ok := m.BufferedBody.(io.Closer); ok {
+		c.Close()
+	}
+}
+
 // Buffer buffers Body up to BufferSize.
 // Calling this function blocks. Call with goroutine.
 func (m *Migration) Buffer() error {
@@ -125,7 +176,9 @@ func (m *Migration) Buffer() error {
 
 	m.StartedBuffering = time.Now()
 
-	b := bufio.NewReaderSize(m.Body, int(m.BufferSize))
+	// hash the body while it is streamed through the buffer
+	h := sha256.New()
+	b := bufio.NewReaderSize(io.TeeReader(m.Body, h), int(m.BufferSize))
 
 	// start reading from body, peek won't move the read pointer though
 	// poor man's solution?
@@ -137,11 +190,13 @@ func (m *Migration) Buffer() error {
 	// something starts reading from m.Buffer
 	n, err := b.WriteTo(m.bufferWriter)
 	if err != nil {
+		m.Body.Close()
 		return err
 	}
 
 	m.FinishedReading = time.Now()
 	m.BytesRead = n
+	m.Checksum = hex.EncodeToString(h.Sum(nil))
 
 	// close bufferWriter so Buffer knows that there is no
 	// more data coming
@@ -152,3 +207,33 @@ func (m *Migration) Buffer() error {
 
 	return nil
 }
+
+// hasNoTransactionHeader returns true if NoTransactionHeader or
+// source.NoTransactionMarker is found in the leading comment lines of body.
+// It peeks into body without advancing the read pointer.
+func hasNoTransactionHeader(body *bufio.Reader) bool {
+	return hasHeader(body, NoTransactionHeader, source.NoTransactionMarker)
+}
+
+// hasHeader returns true if any of headers is found in the leading comment
+// lines of body. It peeks into body without advancing the read pointer.
+func hasHeader(body *bufio.Reader, headers ...string) bool {
+	// Peek returns all available bytes, even if body is shorter
+	b, _ := body.Peek(body.Size())
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			return false
+		}
+		for _, header := range headers {
+			if line == header {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,10 +1,12 @@
 package migrate
 
 import (
+	"bufio"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"strings"
+	"testing"
 )
 
 func ExampleNewMigration() {
@@ -54,3 +56,36 @@ func ExampleNewMigration_nilVersion() {
 	// Output:
 	// 1486686016/d drop_users_table
 }
+
+func TestHasNoTransactionHeader(t *testing.T) {
+	tt := []struct {
+		body   string
+		expect bool
+	}{
+		{body: "", expect: false},
+		{body: "CREATE TABLE foo (id int);", expect: false},
+		{body: "-- migrate:no-transaction", expect: true},
+		{body: "-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY foo_idx ON foo (id);", expect: true},
+		{body: "\n  -- migrate:no-transaction  \nCREATE INDEX CONCURRENTLY foo_idx ON foo (id);", expect: true},
+		{body: "-- add index\n-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY foo_idx ON foo (id);", expect: true},
+		{body: "CREATE TABLE foo (id int);\n-- migrate:no-transaction", expect: false},
+		{body: "-- migrate:no-transaction-please", expect: false},
+		{body: "-- source:no-transaction\nCREATE INDEX CONCURRENTLY foo_idx ON foo (id);", expect: true},
+	}
+
+	for i, v := range tt {
+		body := bufio.NewReader(strings.NewReader(v.body))
+		if got := hasNoTransactionHeader(body); got != v.expect {
+			t.Errorf("expected %v, got %v, in %v", v.expect, got, i)
+		}
+
+		// body must still be readable from the start
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != v.body {
+			t.Errorf("expected body %q, got %q, in %v", v.body, b, i)
+		}
+	}
+}
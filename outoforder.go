@@ -0,0 +1,134 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+)
+
+// ErrOutOfOrder is returned when migrating up and the source has migrations
+// below the currently active version, that have not been applied yet.
+// Set AllowOutOfOrder to apply them.
+type ErrOutOfOrder struct {
+	Versions []uint
+}
+
+// Error implements the error interface.
+func (e ErrOutOfOrder) Error() string {
+	strs := make([]string, 0, len(e.Versions))
+	for _, v := range e.Versions {
+		strs = append(strs, fmt.Sprint(v))
+	}
+	return fmt.Sprintf("unapplied migrations below current version: %v", strings.Join(strs, ", "))
+}
+
+// outOfOrder returns all versions from the source below curVersion that
+// haven't been applied according to the migration history, in ascending order.
+// Versions older than the oldest version in the history are ignored,
+// so enabling the history for an existing database doesn't report
+// migrations applied before. Returns nil if the database doesn't keep
+// a migration history.
+func (m *Migrate) outOfOrder(ctx context.Context, curVersion int) ([]uint, error) {
+	if curVersion < 0 {
+		return nil, nil
+	}
+
+	history, err := m.History()
+	if err == database.ErrNoHistory {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	oldest, ok := oldestVersion(history)
+	if !ok {
+		return nil, nil
+	}
+
+	applied := appliedMigrations(history)
+	versions := make([]uint, 0)
+
+	v, err := m.sourceFirst(ctx)
+	for err == nil && int(v) < curVersion {
+		if _, ok := applied[v]; !ok && v >= oldest {
+			versions = append(versions, v)
+		}
+		v, err = m.sourceNext(ctx, v)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// oldestVersion returns the oldest version of an up or down
+// migration in the history.
+func oldestVersion(history []database.HistoryEntry) (version uint, ok bool) {
+	for _, h := range history {
+		if h.Direction == database.DirectionRepeatable {
+			continue
+		}
+		if !ok || h.Version < version {
+			version, ok = h.Version, true
+		}
+	}
+	return version, ok
+}
+
+// checkOutOfOrder returns the out-of-order versions that should be applied
+// before migrating up from curVersion. It returns ErrOutOfOrder if there are
+// any, but AllowOutOfOrder is not set.
+func (m *Migrate) checkOutOfOrder(ctx context.Context, curVersion int) ([]uint, error) {
+	versions, err := m.outOfOrder(ctx, curVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) > 0 && !m.AllowOutOfOrder {
+		return nil, ErrOutOfOrder{versions}
+	}
+	return versions, nil
+}
+
+// readOutOfOrder reads the up migrations for versions and keeps the
+// currently active version curVersion as target version.
+// Each migration is then written to the ret channel.
+// If an error occurs during reading, that error is written to the ret channel, too.
+// Once readOutOfOrder is done reading it will close the ret channel.
+func (m *Migrate) readOutOfOrder(ctx context.Context, versions []uint, curVersion int, ret chan<- interface{}) {
+	defer close(ret)
+
+	for _, v := range versions {
+		if m.stop(ctx) {
+			if err := ctx.Err(); err != nil {
+				ret <- err
+			}
+			return
+		}
+
+		migr, err := m.newMigration(ctx, v, curVersion)
+		if err != nil {
+			ret <- err
+			return
+		}
+
+		ret <- migr
+		go migr.Buffer()
+	}
+}
+
+// runOutOfOrder applies the up migrations for versions without changing
+// the currently active version curVersion.
+func (m *Migrate) runOutOfOrder(ctx context.Context, versions []uint, curVersion int) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	return m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+		m.readOutOfOrder(ctx, versions, curVersion, ret)
+	})
+}
@@ -0,0 +1,217 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+)
+
+// PlanMigrate returns the migrations Migrate(version) would run, in order,
+// without running them. It only reads the currently active version from
+// the database and doesn't acquire the database lock.
+// The BufferedBody of each returned migration is read into memory,
+// with its variables replaced, see Vars.
+func (m *Migrate) PlanMigrate(version uint) ([]*Migration, error) {
+	return m.PlanMigrateContext(context.Background(), version)
+}
+
+// PlanMigrateContext is like PlanMigrate. If ctx is done, it stops
+// reading migrations and returns ctx.Err().
+func (m *Migrate) PlanMigrateContext(ctx context.Context, version uint) ([]*Migration, error) {
+	curVersion, err := m.planVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.readAndCollect(ctx, func(ctx context.Context, ret chan<- interface{}) {
+		m.read(ctx, curVersion, int(version), ret)
+	})
+}
+
+// PlanSteps returns the migrations Steps(n) would run, in order,
+// without running them. See PlanMigrate.
+func (m *Migrate) PlanSteps(n int) ([]*Migration, error) {
+	return m.PlanStepsContext(context.Background(), n)
+}
+
+// PlanStepsContext is like PlanSteps. See PlanMigrateContext for how ctx
+// is handled.
+func (m *Migrate) PlanStepsContext(ctx context.Context, n int) ([]*Migration, error) {
+	if n == 0 {
+		return nil, ErrNoChange
+	}
+
+	curVersion, err := m.planVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		return m.readAndCollect(ctx, func(ctx context.Context, ret chan<- interface{}) {
+			m.readDown(ctx, curVersion, -n, ret)
+		})
+	}
+
+	return m.planUp(ctx, curVersion, n)
+}
+
+// PlanUp returns the migrations Up would run, in order,
+// without running them. See PlanMigrate. Repeatable migrations
+// that would run are last, see Migration.Repeatable.
+func (m *Migrate) PlanUp() ([]*Migration, error) {
+	return m.PlanUpContext(context.Background())
+}
+
+// PlanUpContext is like PlanUp. See PlanMigrateContext for how ctx
+// is handled.
+func (m *Migrate) PlanUpContext(ctx context.Context) ([]*Migration, error) {
+	curVersion, err := m.planVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.planUp(ctx, curVersion, -1)
+}
+
+// PlanDown returns the migrations Down would run, in order,
+// without running them. See PlanMigrate.
+func (m *Migrate) PlanDown() ([]*Migration, error) {
+	return m.PlanDownContext(context.Background())
+}
+
+// PlanDownContext is like PlanDown. See PlanMigrateContext for how ctx
+// is handled.
+func (m *Migrate) PlanDownContext(ctx context.Context) ([]*Migration, error) {
+	curVersion, err := m.planVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.readAndCollect(ctx, func(ctx context.Context, ret chan<- interface{}) {
+		m.readDown(ctx, curVersion, -1, ret)
+	})
+}
+
+// planUp returns the out-of-order migrations followed by the
+// up migrations from curVersion limitted by limit, just like they
+// would be run by Up or Steps. Without a limit, like Up, they are
+// followed by the repeatable migrations that are new or changed.
+func (m *Migrate) planUp(ctx context.Context, curVersion int, limit int) ([]*Migration, error) {
+	if err := m.validateBeforeUp(ctx); err != nil {
+		return nil, err
+	}
+
+	outOfOrder, err := m.checkOutOfOrder(ctx, curVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := m.readAndCollect(ctx, func(ctx context.Context, ret chan<- interface{}) {
+		m.readOutOfOrder(ctx, outOfOrder, curVersion, ret)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	up, err := m.readAndCollect(ctx, func(ctx context.Context, ret chan<- interface{}) {
+		m.readUp(ctx, curVersion, limit, ret)
+	})
+	if err != nil && err != ErrNoChange {
+		return nil, err
+	}
+	migrations = append(migrations, up...)
+
+	if limit == -1 {
+		version := curVersion
+		if len(up) > 0 {
+			version = up[len(up)-1].TargetVersion
+		}
+		repeatables, _, err := m.pendingRepeatables(ctx, version)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, repeatables...)
+	}
+
+	if len(migrations) == 0 {
+		return nil, ErrNoChange
+	}
+	return migrations, nil
+}
+
+// planVersion returns the currently active version without
+// acquiring the database lock. It returns ErrDirty if the database is dirty.
+func (m *Migrate) planVersion(ctx context.Context) (int, error) {
+	curVersion, dirty, err := m.databaseVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty {
+		return 0, ErrDirty{curVersion}
+	}
+
+	return curVersion, nil
+}
+
+// readAndCollect runs read in a goroutine and collects the migrations it
+// sends with collectMigrations. Like readAndRun, it cancels read and
+// discards the remaining migrations when collectMigrations returns.
+func (m *Migrate) readAndCollect(ctx context.Context, read func(ctx context.Context, ret chan<- interface{})) ([]*Migration, error) {
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ret := make(chan interface{}, m.PrefetchMigrations)
+	go read(readCtx, ret)
+	migrations, err := m.collectMigrations(ctx, ret)
+	// stop the reader and wait until it closes ret
+	cancel()
+	for r := range ret {
+		if migr, ok := r.(*Migration); ok {
+			migr.discard()
+		}
+	}
+	return migrations, err
+}
+
+// collectMigrations reads *Migration and error from a channel, like
+// runMigrations does, but reads each migration body into memory
+// instead of running it against the database.
+func (m *Migrate) collectMigrations(ctx context.Context, ret <-chan interface{}) ([]*Migration, error) {
+	migrations := make([]*Migration, 0)
+	for r := range ret {
+		if err := ctx.Err(); err != nil {
+			if migr, ok := r.(*Migration); ok {
+				migr.discard()
+			}
+			return nil, err
+		}
+
+		switch r.(type) {
+		case error:
+			return nil, r.(error)
+
+		case *Migration:
+			migr := r.(*Migration)
+
+			if migr.Body != nil {
+				r, err := m.expandVars(migr.BufferedBody)
+				if err != nil {
+					migr.discard()
+					return nil, err
+				}
+				body, err := ioutil.ReadAll(r)
+				if err != nil {
+					migr.discard()
+					return nil, err
+				}
+				migr.BufferedBody = bytes.NewReader(body)
+			}
+
+			migrations = append(migrations, migr)
+
+		default:
+			panic("unknown type")
+		}
+	}
+	return migrations, nil
+}
@@ -0,0 +1,214 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/mattes/migrate/database"
+)
+
+// ErrIrreversible is returned by VerifyReversible if a migration
+// can't be rolled back and applied again.
+type ErrIrreversible struct {
+	Version uint
+	Err     error
+}
+
+// Error implements the error interface.
+func (e ErrIrreversible) Error() string {
+	return fmt.Sprintf("migration %v is not reversible: %v", e.Version, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e ErrIrreversible) Unwrap() error {
+	return e.Err
+}
+
+// Redo rolls back the last n applied migrations and applies them again.
+// If there are less than n migrations to roll back, all of them are
+// redone and ErrShortLimit is returned.
+// Checksums are not validated, so Redo can be used to reapply
+// migrations that changed in the source.
+func (m *Migrate) Redo(n int) error {
+	return m.RedoContext(context.Background(), n)
+}
+
+// RedoContext is like Redo.
+func (m *Migrate) RedoContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return ErrNoChange
+	}
+
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+
+	curVersion, dirty, err := m.databaseVersion(ctx)
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	if dirty {
+		return m.unlockErr(ErrDirty{curVersion})
+	}
+
+	if curVersion == database.NilVersion {
+		return m.unlockErr(ErrNilVersion)
+	}
+
+	err = m.atomic(ctx, func() error {
+		downErr := m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+			m.readDown(ctx, curVersion, n, ret)
+		})
+		if _, ok := downErr.(ErrShortLimit); downErr != nil && !ok {
+			return downErr
+		}
+
+		downVersion, _, err := m.databaseVersion(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+			m.read(ctx, downVersion, curVersion, ret)
+		})
+		if err != nil {
+			return err
+		}
+		return downErr
+	})
+	return m.unlockErr(err)
+}
+
+// VerifyReversible applies all up migrations one at a time. Right after
+// a migration has been applied, it is rolled back and applied again, which
+// fails if the down migration doesn't reverse the up migration.
+// If the database driver implements database.Dumper, the schema dump after
+// rolling back must equal the dump before applying the migration. Otherwise,
+// only the version is checked, so a down migration that does nothing passes.
+// It returns ErrIrreversible for the first migration that can't be
+// reversed, or ErrNoChange if there is nothing to apply.
+// Run it against a scratch database, it leaves all migrations applied.
+func (m *Migrate) VerifyReversible() error {
+	return m.VerifyReversibleContext(context.Background())
+}
+
+// VerifyReversibleContext is like VerifyReversible.
+func (m *Migrate) VerifyReversibleContext(ctx context.Context) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+
+	curVersion, dirty, err := m.databaseVersion(ctx)
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	if dirty {
+		return m.unlockErr(ErrDirty{curVersion})
+	}
+
+	verified := 0
+	err = m.withHooks(func() error {
+		for !m.stop(ctx) {
+			var next uint
+			if curVersion == database.NilVersion {
+				next, err = m.sourceFirst(ctx)
+			} else {
+				next, err = m.sourceNext(ctx, suint(curVersion))
+			}
+			if os.IsNotExist(err) {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			before, err := m.dumpSchema(ctx)
+			if err != nil {
+				return err
+			}
+
+			if err := m.runSteps(ctx, curVersion, 1); err != nil {
+				return err
+			}
+
+			if err := m.verifyReversible(ctx, curVersion, int(next), before); err != nil {
+				return ErrIrreversible{next, err}
+			}
+
+			curVersion = int(next)
+			verified++
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	if verified == 0 {
+		return m.unlockErr(ErrNoChange)
+	}
+	return m.unlock()
+}
+
+// verifyReversible rolls back the migration to version from prevVersion
+// and applies it again. before is the schema dump at prevVersion, or nil
+// if the database driver can't dump its schema.
+func (m *Migrate) verifyReversible(ctx context.Context, prevVersion int, version int, before []byte) error {
+	r, _, err := m.sourceReadDown(ctx, suint(version))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no down migration")
+	} else if err != nil {
+		return err
+	}
+	r.Close()
+
+	if err := m.runSteps(ctx, version, -1); err != nil {
+		return err
+	}
+
+	downVersion, _, err := m.databaseVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if downVersion != prevVersion {
+		return fmt.Errorf("rolled back to version %v instead of %v", downVersion, prevVersion)
+	}
+
+	after, err := m.dumpSchema(ctx)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(before, after) {
+		return fmt.Errorf("schema after rolling back differs from the schema at version %v", prevVersion)
+	}
+
+	return m.runSteps(ctx, prevVersion, 1)
+}
+
+// dumpSchema returns the schema dump of the database, or nil if the
+// database driver doesn't implement database.Dumper.
+func (m *Migrate) dumpSchema(ctx context.Context) ([]byte, error) {
+	d, ok := m.databaseDrv.(database.Dumper)
+	if !ok {
+		return nil, nil
+	}
+	var b bytes.Buffer
+	if err := d.Dump(ctx, &b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// runSteps applies n up or -n down migrations from curVersion.
+func (m *Migrate) runSteps(ctx context.Context, curVersion int, n int) error {
+	return m.readAndRun(ctx, func(ctx context.Context, ret chan<- interface{}) {
+		if n > 0 {
+			m.readUp(ctx, curVersion, n, ret)
+		} else {
+			m.readDown(ctx, curVersion, -n, ret)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/source"
+)
+
+// runRepeatables runs all repeatable migrations of the source that are new
+// or changed since they last ran, see pendingRepeatables. Their checksums
+// are recorded in the migration history. It returns the number of
+// repeatable migrations that ran.
+func (m *Migrate) runRepeatables(ctx context.Context) (int, error) {
+	version, _, err := m.databaseVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending, h, err := m.pendingRepeatables(ctx, version)
+	if err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	for _, migr := range pending {
+		if m.stop(ctx) {
+			return ran, ctx.Err()
+		}
+		if m.lockLost() {
+			return ran, database.ErrLockLost
+		}
+
+		m.logVerbosePrintf("Read and execute %v\n", migr.LogString())
+		entry := database.HistoryEntry{
+			Version:    migr.Version,
+			Direction:  database.DirectionRepeatable,
+			Identifier: migr.Identifier,
+			Checksum:   migr.Checksum,
+			StartedAt:  time.Now(),
+			AppliedBy:  m.appliedBy(),
+		}
+		if err := m.runRepeatable(ctx, h, entry, bufio.NewReader(migr.BufferedBody), version); err != nil {
+			return ran, ErrMigrationFailed{
+				Version:    migr.Version,
+				Identifier: migr.Identifier,
+				Direction:  database.DirectionRepeatable,
+				Err:        err,
+			}
+		}
+
+		m.logPrintf("%v (%v)\n", migr.LogString(), time.Now().Sub(entry.StartedAt))
+		ran++
+	}
+	return ran, nil
+}
+
+// pendingRepeatables returns the repeatable migrations of the source that
+// are new or changed since they last ran, in the order returned by the
+// source, to be run at version. Their BufferedBody is read into memory,
+// with its variables replaced, see Vars. If the database doesn't keep a
+// migration history, all of them are returned and h is nil.
+func (m *Migrate) pendingRepeatables(ctx context.Context, version int) (pending []*Migration, h database.HistoryDriver, err error) {
+	rd, ok := m.sourceDrv.(source.RepeatableDriver)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	identifiers, err := rd.Repeatables()
+	if err != nil || len(identifiers) == 0 {
+		return nil, nil, err
+	}
+
+	// the latest checksum of each repeatable migration
+	checksums := make(map[string]string)
+	h, ok = m.databaseDrv.(database.HistoryDriver)
+	if ok {
+		history, err := m.databaseHistory(ctx, h)
+		if err == database.ErrNoHistory {
+			h = nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		for _, e := range history {
+			if e.Direction == database.DirectionRepeatable {
+				checksums[e.Identifier] = e.Checksum
+			}
+		}
+	}
+
+	for _, identifier := range identifiers {
+		r, err := rd.ReadRepeatable(identifier)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sum, err := checksum(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		if sum == checksums[identifier] {
+			continue
+		}
+
+		expanded, err := m.expandVars(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		expandedBody, err := ioutil.ReadAll(expanded)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pending = append(pending, &Migration{
+			Identifier:    identifier,
+			Version:       historyVersion(version),
+			TargetVersion: version,
+			Repeatable:    true,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			BufferedBody:  bytes.NewReader(expandedBody),
+			BytesRead:     int64(len(body)),
+			Checksum:      sum,
+		})
+	}
+	return pending, h, nil
+}
+
+// runRepeatable runs the body of a repeatable migration and records entry
+// in the migration history, unless h is nil. Unless running atomic
+// migrations, it runs in a transaction if the database driver supports it,
+// otherwise version is marked dirty while it is running.
+func (m *Migrate) runRepeatable(ctx context.Context, h database.HistoryDriver, entry database.HistoryEntry, body *bufio.Reader, version int) error {
+	noTransaction := hasNoTransactionHeader(body)
+	if m.isAtomic && noTransaction {
+		return fmt.Errorf("can't run in a transaction, but running atomic migrations")
+	}
+
+	run := func() error {
+		if err := m.databaseRun(ctx, body); err != nil {
+			return err
+		}
+		return m.appendRepeatable(ctx, h, entry)
+	}
+
+	if m.isAtomic {
+		return run()
+	}
+
+	if !noTransaction {
+		if a, ok := m.databaseDrv.(database.AtomicDriver); ok {
+			m.logVerbosePrintf("Begin transaction for R %v\n", entry.Identifier)
+			return m.inTransaction(ctx, a, run)
+		}
+		if d, ok := m.databaseDrv.(database.TransactionalDriver); ok {
+			if err := d.RunWithVersion(ctx, body, version); err != nil {
+				return err
+			}
+			return m.appendRepeatable(ctx, h, entry)
+		}
+	}
+
+	if err := m.databaseSetVersion(ctx, version, true); err != nil {
+		return err
+	}
+	if err := m.databaseRun(ctx, body); err != nil {
+		return err
+	}
+	if err := m.databaseSetVersion(ctx, version, false); err != nil {
+		return err
+	}
+	return m.appendRepeatable(ctx, h, entry)
+}
+
+// appendRepeatable records entry in the migration history, unless h is nil.
+func (m *Migrate) appendRepeatable(ctx context.Context, h database.HistoryDriver, entry database.HistoryEntry) error {
+	if h == nil {
+		return nil
+	}
+	entry.FinishedAt = time.Now()
+	entry.Duration = entry.FinishedAt.Sub(entry.StartedAt)
+	return m.databaseAppendHistory(ctx, h, entry)
+}
+
+// historyVersion returns the version recorded in the migration history
+// for repeatable migrations run at version.
+func historyVersion(version int) uint {
+	if version == database.NilVersion {
+		return 0
+	}
+	return uint(version)
+}
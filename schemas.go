@@ -0,0 +1,123 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mattes/migrate/database"
+)
+
+var (
+	ErrNoSchemas       = fmt.Errorf("no schemas selected")
+	ErrAmbiguousSchema = fmt.Errorf("only one of Names, Query or Pattern can be set")
+)
+
+// Schemas selects the schemas of a database for ForEachSchema.
+// Exactly one of its fields must be set.
+type Schemas struct {
+	// Names lists the schemas explicitly.
+	Names []string
+
+	// Query is run against the database and returns
+	// the schema names in its first column.
+	Query string
+
+	// Pattern matches schema names, with % and _ as
+	// wildcards like in SQL LIKE.
+	Pattern string
+}
+
+// list returns the names of the selected schemas of the database at url.
+func (s Schemas) list(ctx context.Context, url string) ([]string, error) {
+	set := 0
+	for _, ok := range []bool{len(s.Names) > 0, len(s.Query) > 0, len(s.Pattern) > 0} {
+		if ok {
+			set++
+		}
+	}
+	if set == 0 {
+		return nil, ErrNoSchemas
+	} else if set > 1 {
+		return nil, ErrAmbiguousSchema
+	}
+
+	if len(s.Names) > 0 {
+		return s.Names, nil
+	}
+
+	d, err := database.GetSchemaDriver(url)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Query) > 0 {
+		return d.QuerySchemas(ctx, url, s.Query)
+	}
+	return d.Schemas(ctx, url, s.Pattern)
+}
+
+// SchemaResult is the result of ForEachSchema for a single schema.
+type SchemaResult struct {
+	// Schema is the name of the schema.
+	Schema string
+
+	// Err is the error returned by opening the schema or by fn.
+	Err error
+
+	// Version is the active version of the schema afterwards,
+	// or database.NilVersion if it has none or it couldn't be read.
+	Version int
+	Dirty   bool
+
+	// Duration is the time spent on the schema.
+	Duration time.Duration
+}
+
+// ForEachSchema opens a Migrate instance for each schema of the database
+// at databaseUrl selected by schemas, and calls fn with it, i.e. to migrate
+// it up. Each schema has its own migrations table. At most parallel schemas
+// are handled at the same time. The source is opened once and shared by
+// all instances, which are closed after fn returns.
+// The database driver must implement database.SchemaDriver, unless the
+// schemas are listed explicitly.
+// It returns an error if the schemas can't be listed, and the result
+// of each schema otherwise, in the order of the schemas.
+func ForEachSchema(sourceUrl, databaseUrl string, schemas Schemas, parallel int, fn func(schema string, m *Migrate) error) ([]SchemaResult, error) {
+	return ForEachSchemaContext(context.Background(), sourceUrl, databaseUrl, schemas, parallel, fn)
+}
+
+// ForEachSchemaContext is like ForEachSchema. If ctx is done,
+// no more schemas are started and their results are ctx.Err().
+// fn should pass ctx on, i.e. to UpContext.
+func ForEachSchemaContext(ctx context.Context, sourceUrl, databaseUrl string, schemas Schemas, parallel int, fn func(schema string, m *Migrate) error) ([]SchemaResult, error) {
+	names, err := schemas.list(ctx, databaseUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, len(names))
+	for i, name := range names {
+		if urls[i], err = database.SchemaURL(databaseUrl, name); err != nil {
+			return nil, err
+		}
+	}
+
+	targets, err := forEachTarget(ctx, sourceUrl, urls, parallel, false, func(i int, m *Migrate) error {
+		return fn(names[i], m)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SchemaResult, len(targets))
+	for i, t := range targets {
+		results[i] = SchemaResult{
+			Schema:   names[i],
+			Err:      t.err,
+			Version:  t.version,
+			Dirty:    t.dirty,
+			Duration: t.duration,
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,126 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/mattes/migrate/source"
+)
+
+// sharedSource shares a source driver between the Migrate instances of
+// ForEachDatabase and ForEachSchema. Each migration is read from the
+// driver once and kept in memory. Close is a no-op, the driver is
+// closed after all instances are done.
+type sharedSource struct {
+	mu     sync.Mutex
+	drv    source.Driver
+	bodies map[string]sharedBody
+}
+
+type sharedBody struct {
+	body       []byte
+	identifier string
+	err        error
+}
+
+func newSharedSource(drv source.Driver) *sharedSource {
+	return &sharedSource{
+		drv:    drv,
+		bodies: make(map[string]sharedBody),
+	}
+}
+
+func (s *sharedSource) Open(url string) (source.Driver, error) {
+	return nil, fmt.Errorf("shared source can't be opened")
+}
+
+func (s *sharedSource) Close() error {
+	return nil
+}
+
+func (s *sharedSource) First() (version uint, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.drv.First()
+}
+
+func (s *sharedSource) Prev(version uint) (prevVersion uint, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.drv.Prev(version)
+}
+
+func (s *sharedSource) Next(version uint) (nextVersion uint, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.drv.Next(version)
+}
+
+func (s *sharedSource) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+	return s.read(version, true)
+}
+
+func (s *sharedSource) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
+	return s.read(version, false)
+}
+
+// read returns the up or down migration of version, reading it from
+// the driver if it hasn't been read yet.
+func (s *sharedSource) read(version uint, up bool) (io.ReadCloser, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := fmt.Sprintf("%v/%v", version, up)
+	b, ok := s.bodies[key]
+	if !ok {
+		read := s.drv.ReadUp
+		if !up {
+			read = s.drv.ReadDown
+		}
+
+		r, identifier, err := read(version)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, "", err
+			}
+			b = sharedBody{err: err}
+		} else {
+			body, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return nil, "", err
+			}
+			b = sharedBody{body: body, identifier: identifier}
+		}
+		s.bodies[key] = b
+	}
+
+	if b.err != nil {
+		return nil, "", b.err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b.body)), b.identifier, nil
+}
+
+// Repeatables implements source.RepeatableDriver,
+// if the driver implements it.
+func (s *sharedSource) Repeatables() (identifiers []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rd, ok := s.drv.(source.RepeatableDriver); ok {
+		return rd.Repeatables()
+	}
+	return nil, nil
+}
+
+func (s *sharedSource) ReadRepeatable(identifier string) (r io.ReadCloser, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rd, ok := s.drv.(source.RepeatableDriver); ok {
+		return rd.ReadRepeatable(identifier)
+	}
+	return nil, os.ErrNotExist
+}
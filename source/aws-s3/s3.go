@@ -23,6 +23,7 @@ type s3Driver struct {
 	s3client   s3iface.S3API
 	bucket     string
 	prefix     string
+	parser     source.Parser
 	migrations *source.Migrations
 }
 
@@ -31,6 +32,10 @@ func (s *s3Driver) Open(folder string) (source.Driver, error) {
 	if err != nil {
 		return nil, err
 	}
+	parser, err := source.ParserFromURL(folder)
+	if err != nil {
+		return nil, err
+	}
 	sess, err := session.NewSession()
 	if err != nil {
 		return nil, err
@@ -39,6 +44,7 @@ func (s *s3Driver) Open(folder string) (source.Driver, error) {
 		bucket:     u.Host,
 		prefix:     strings.Trim(u.Path, "/") + "/",
 		s3client:   s3.New(sess),
+		parser:     parser,
 		migrations: source.NewMigrations(),
 	}
 	err = driver.loadMigrations()
@@ -59,12 +65,14 @@ func (s *s3Driver) loadMigrations() error {
 	}
 	for _, object := range output.Contents {
 		_, fileName := path.Split(aws.StringValue(object.Key))
-		m, err := source.DefaultParse(fileName)
+		migrations, err := s.parser.Parse(fileName)
 		if err != nil {
 			continue
 		}
-		if !s.migrations.Append(m) {
-			return fmt.Errorf("unable to parse file %v", aws.StringValue(object.Key))
+		for _, m := range migrations {
+			if !s.migrations.Append(m) {
+				return fmt.Errorf("unable to parse file %v", aws.StringValue(object.Key))
+			}
 		}
 	}
 	return nil
@@ -112,6 +120,18 @@ func (s *s3Driver) ReadDown(version uint) (io.ReadCloser, string, error) {
 	return nil, "", os.ErrNotExist
 }
 
+func (s *s3Driver) Repeatables() ([]string, error) {
+	return s.migrations.Repeatables(), nil
+}
+
+func (s *s3Driver) ReadRepeatable(identifier string) (io.ReadCloser, error) {
+	if m, ok := s.migrations.Repeatable(identifier); ok {
+		r, _, err := s.open(m)
+		return r, err
+	}
+	return nil, os.ErrNotExist
+}
+
 func (s *s3Driver) open(m *source.Migration) (io.ReadCloser, string, error) {
 	key := path.Join(s.prefix, m.Raw)
 	object, err := s.s3client.GetObject(&s3.GetObjectInput{
@@ -121,5 +141,9 @@ func (s *s3Driver) open(m *source.Migration) (io.ReadCloser, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
-	return object.Body, m.Identifier, nil
+	body, err := s.parser.Read(m, object.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, m.Identifier, nil
 }
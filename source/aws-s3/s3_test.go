@@ -33,6 +33,7 @@ func Test(t *testing.T) {
 	driver := s3Driver{
 		bucket:     "some-bucket",
 		prefix:     "prod/migrations/",
+		parser:     source.DefaultParser,
 		migrations: source.NewMigrations(),
 		s3client:   &s3Client,
 	}
@@ -43,6 +44,46 @@ func Test(t *testing.T) {
 	st.Test(t, &driver)
 }
 
+func TestRepeatable(t *testing.T) {
+	s3Client := fakeS3{
+		bucket: "some-bucket",
+		objects: map[string]string{
+			"prod/migrations/1_foobar.up.sql": "1 up",
+			"prod/migrations/R__views.sql":    "CREATE VIEW v1",
+		},
+	}
+	driver := s3Driver{
+		bucket:     "some-bucket",
+		prefix:     "prod/migrations/",
+		parser:     source.DefaultParser,
+		migrations: source.NewMigrations(),
+		s3client:   &s3Client,
+	}
+	if err := driver.loadMigrations(); err != nil {
+		t.Fatal(err)
+	}
+
+	identifiers, err := driver.Repeatables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identifiers) != 1 || identifiers[0] != "views" {
+		t.Fatalf("expected repeatable views, got %v", identifiers)
+	}
+	r, err := driver.ReadRepeatable("views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "CREATE VIEW v1" {
+		t.Fatalf("expected CREATE VIEW v1, got %q", body)
+	}
+}
+
 type fakeS3 struct {
 	s3.S3
 	bucket  string
@@ -5,6 +5,7 @@
 package source
 
 import (
+	"context"
 	"fmt"
 	"io"
 	nurl "net/url"
@@ -72,6 +73,43 @@ type Driver interface {
 	ReadDown(version uint) (r io.ReadCloser, identifier string, err error)
 }
 
+// DriverContext is an optional interface a source driver can implement
+// to support cancellation and deadlines, i.e. when reading migrations
+// from a remote location. If a driver implements it, Migrate prefers
+// these functions over the ones defined in Driver.
+type DriverContext interface {
+	// FirstContext is like First.
+	FirstContext(ctx context.Context) (version uint, err error)
+
+	// PrevContext is like Prev.
+	PrevContext(ctx context.Context, version uint) (prevVersion uint, err error)
+
+	// NextContext is like Next.
+	NextContext(ctx context.Context, version uint) (nextVersion uint, err error)
+
+	// ReadUpContext is like ReadUp. ctx should be used for fetching
+	// the migration body, too.
+	ReadUpContext(ctx context.Context, version uint) (r io.ReadCloser, identifier string, err error)
+
+	// ReadDownContext is like ReadDown. ctx should be used for fetching
+	// the migration body, too.
+	ReadDownContext(ctx context.Context, version uint) (r io.ReadCloser, identifier string, err error)
+}
+
+// RepeatableDriver is an optional interface a source driver can implement
+// to support repeatable migrations, which are run again by migrate up
+// whenever their content changes, i.e. for views and functions.
+type RepeatableDriver interface {
+	// Repeatables returns the identifiers of all repeatable migrations
+	// in the order they should be run.
+	Repeatables() (identifiers []string, err error)
+
+	// ReadRepeatable returns the body of the repeatable migration
+	// with identifier. If there is none, it must return os.ErrNotExist.
+	// Do not start reading, just return the ReadCloser!
+	ReadRepeatable(identifier string) (r io.ReadCloser, err error)
+}
+
 // Open returns a new driver instance.
 func Open(url string) (Driver, error) {
 	u, err := nurl.Parse(url)
@@ -19,6 +19,7 @@ func init() {
 type File struct {
 	url        string
 	path       string
+	parser     source.Parser
 	migrations *source.Migrations
 }
 
@@ -49,6 +50,11 @@ func (f *File) Open(url string) (source.Driver, error) {
 		p = abs
 	}
 
+	parser, err := source.ParserFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+
 	// scan directory
 	files, err := ioutil.ReadDir(p)
 	if err != nil {
@@ -58,17 +64,20 @@ func (f *File) Open(url string) (source.Driver, error) {
 	nf := &File{
 		url:        url,
 		path:       p,
+		parser:     parser,
 		migrations: source.NewMigrations(),
 	}
 
 	for _, fi := range files {
 		if !fi.IsDir() {
-			m, err := source.DefaultParse(fi.Name())
+			migrations, err := parser.Parse(fi.Name())
 			if err != nil {
 				continue // ignore files that we can't parse
 			}
-			if !nf.migrations.Append(m) {
-				return nil, fmt.Errorf("unable to parse file %v", fi.Name())
+			for _, m := range migrations {
+				if !nf.migrations.Append(m) {
+					return nil, fmt.Errorf("unable to parse file %v", fi.Name())
+				}
 			}
 		}
 	}
@@ -106,7 +115,7 @@ func (f *File) Next(version uint) (nextVersion uint, err error) {
 
 func (f *File) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
 	if m, ok := f.migrations.Up(version); ok {
-		r, err := os.Open(path.Join(f.path, m.Raw))
+		r, err := f.open(m)
 		if err != nil {
 			return nil, "", err
 		}
@@ -117,7 +126,7 @@ func (f *File) ReadUp(version uint) (r io.ReadCloser, identifier string, err err
 
 func (f *File) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
 	if m, ok := f.migrations.Down(version); ok {
-		r, err := os.Open(path.Join(f.path, m.Raw))
+		r, err := f.open(m)
 		if err != nil {
 			return nil, "", err
 		}
@@ -125,3 +134,23 @@ func (f *File) ReadDown(version uint) (r io.ReadCloser, identifier string, err e
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), f.path, os.ErrNotExist}
 }
+
+func (f *File) Repeatables() (identifiers []string, err error) {
+	return f.migrations.Repeatables(), nil
+}
+
+func (f *File) ReadRepeatable(identifier string) (r io.ReadCloser, err error) {
+	if m, ok := f.migrations.Repeatable(identifier); ok {
+		return f.open(m)
+	}
+	return nil, &os.PathError{fmt.Sprintf("read repeatable %v", identifier), f.path, os.ErrNotExist}
+}
+
+// open returns the body of m, as read by the parser.
+func (f *File) open(m *source.Migration) (io.ReadCloser, error) {
+	r, err := os.Open(path.Join(f.path, m.Raw))
+	if err != nil {
+		return nil, err
+	}
+	return f.parser.Read(m, r)
+}
@@ -142,6 +142,34 @@ func TestOpenWithDuplicateVersion(t *testing.T) {
 	}
 }
 
+func TestOpenWithParser(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestOpenWithParser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mustWriteFile(t, tmpDir, "1_foobar.sql", "-- migrate:up\n1 up\n-- migrate:down\n1 down\n")
+
+	f := &File{}
+	d, err := f.Open("file://" + tmpDir + "?x-parser=dbmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _, err := d.ReadDown(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "1 down\n" {
+		t.Fatalf("expected down section, got %q", body)
+	}
+}
+
 func TestClose(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "TestOpen")
 	if err != nil {
@@ -34,10 +34,18 @@ type Github struct {
 	pathOwner  string
 	pathRepo   string
 	path       string
+	parser     source.Parser
 	migrations *source.Migrations
 }
 
 type Config struct {
+	// Parser maps the files in the repository to migrations.
+	// It defaults to source.DefaultParser.
+	Parser source.Parser
+
+	// Repeatable enables repeatable migrations. Otherwise their files
+	// are ignored, see source.WithoutRepeatables.
+	Repeatable bool
 }
 
 func (g *Github) Open(url string) (source.Driver, error) {
@@ -60,9 +68,15 @@ func (g *Github) Open(url string) (source.Driver, error) {
 		Password: password,
 	}
 
+	parser, err := source.ParserFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+
 	gn := &Github{
 		client:     github.NewClient(tr.Client()),
 		url:        url,
+		parser:     parser,
 		migrations: source.NewMigrations(),
 	}
 
@@ -87,8 +101,15 @@ func (g *Github) Open(url string) (source.Driver, error) {
 func WithInstance(client *github.Client, config *Config) (source.Driver, error) {
 	gn := &Github{
 		client:     client,
+		parser:     source.DefaultParser,
 		migrations: source.NewMigrations(),
 	}
+	if config != nil && config.Parser != nil {
+		gn.parser = config.Parser
+	}
+	if config == nil || !config.Repeatable {
+		gn.parser = source.WithoutRepeatables(gn.parser)
+	}
 	if err := gn.readDirectory(); err != nil {
 		return nil, err
 	}
@@ -105,12 +126,14 @@ func (g *Github) readDirectory() error {
 	}
 
 	for _, fi := range dirContents {
-		m, err := source.DefaultParse(*fi.Name)
+		migrations, err := g.parser.Parse(*fi.Name)
 		if err != nil {
 			continue // ignore files that we can't parse
 		}
-		if !g.migrations.Append(m) {
-			return fmt.Errorf("unable to parse file %v", *fi.Name)
+		for _, m := range migrations {
+			if !g.migrations.Append(m) {
+				return fmt.Errorf("unable to parse file %v", *fi.Name)
+			}
 		}
 	}
 
@@ -122,6 +145,10 @@ func (g *Github) Close() error {
 }
 
 func (g *Github) First() (version uint, er error) {
+	return g.FirstContext(context.Background())
+}
+
+func (g *Github) FirstContext(ctx context.Context) (version uint, er error) {
 	if v, ok := g.migrations.First(); !ok {
 		return 0, &os.PathError{"first", g.path, os.ErrNotExist}
 	} else {
@@ -130,6 +157,10 @@ func (g *Github) First() (version uint, er error) {
 }
 
 func (g *Github) Prev(version uint) (prevVersion uint, err error) {
+	return g.PrevContext(context.Background(), version)
+}
+
+func (g *Github) PrevContext(ctx context.Context, version uint) (prevVersion uint, err error) {
 	if v, ok := g.migrations.Prev(version); !ok {
 		return 0, &os.PathError{fmt.Sprintf("prev for version %v", version), g.path, os.ErrNotExist}
 	} else {
@@ -138,6 +169,10 @@ func (g *Github) Prev(version uint) (prevVersion uint, err error) {
 }
 
 func (g *Github) Next(version uint) (nextVersion uint, err error) {
+	return g.NextContext(context.Background(), version)
+}
+
+func (g *Github) NextContext(ctx context.Context, version uint) (nextVersion uint, err error) {
 	if v, ok := g.migrations.Next(version); !ok {
 		return 0, &os.PathError{fmt.Sprintf("next for version %v", version), g.path, os.ErrNotExist}
 	} else {
@@ -146,8 +181,12 @@ func (g *Github) Next(version uint) (nextVersion uint, err error) {
 }
 
 func (g *Github) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+	return g.ReadUpContext(context.Background(), version)
+}
+
+func (g *Github) ReadUpContext(ctx context.Context, version uint) (r io.ReadCloser, identifier string, err error) {
 	if m, ok := g.migrations.Up(version); ok {
-		file, _, _, err := g.client.Repositories.GetContents(context.Background(), g.pathOwner, g.pathRepo, path.Join(g.path, m.Raw), &github.RepositoryContentGetOptions{})
+		file, _, _, err := g.client.Repositories.GetContents(ctx, g.pathOwner, g.pathRepo, path.Join(g.path, m.Raw), &github.RepositoryContentGetOptions{})
 		if err != nil {
 			return nil, "", err
 		}
@@ -156,15 +195,23 @@ func (g *Github) ReadUp(version uint) (r io.ReadCloser, identifier string, err e
 			if err != nil {
 				return nil, "", err
 			}
-			return ioutil.NopCloser(bytes.NewReader([]byte(r))), m.Identifier, nil
+			body, err := g.parser.Read(m, ioutil.NopCloser(bytes.NewReader([]byte(r))))
+			if err != nil {
+				return nil, "", err
+			}
+			return body, m.Identifier, nil
 		}
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), g.path, os.ErrNotExist}
 }
 
 func (g *Github) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
+	return g.ReadDownContext(context.Background(), version)
+}
+
+func (g *Github) ReadDownContext(ctx context.Context, version uint) (r io.ReadCloser, identifier string, err error) {
 	if m, ok := g.migrations.Down(version); ok {
-		file, _, _, err := g.client.Repositories.GetContents(context.Background(), g.pathOwner, g.pathRepo, path.Join(g.path, m.Raw), &github.RepositoryContentGetOptions{})
+		file, _, _, err := g.client.Repositories.GetContents(ctx, g.pathOwner, g.pathRepo, path.Join(g.path, m.Raw), &github.RepositoryContentGetOptions{})
 		if err != nil {
 			return nil, "", err
 		}
@@ -173,8 +220,33 @@ func (g *Github) ReadDown(version uint) (r io.ReadCloser, identifier string, err
 			if err != nil {
 				return nil, "", err
 			}
-			return ioutil.NopCloser(bytes.NewReader([]byte(r))), m.Identifier, nil
+			body, err := g.parser.Read(m, ioutil.NopCloser(bytes.NewReader([]byte(r))))
+			if err != nil {
+				return nil, "", err
+			}
+			return body, m.Identifier, nil
 		}
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), g.path, os.ErrNotExist}
 }
+
+func (g *Github) Repeatables() (identifiers []string, err error) {
+	return g.migrations.Repeatables(), nil
+}
+
+func (g *Github) ReadRepeatable(identifier string) (r io.ReadCloser, err error) {
+	if m, ok := g.migrations.Repeatable(identifier); ok {
+		file, _, _, err := g.client.Repositories.GetContents(context.Background(), g.pathOwner, g.pathRepo, path.Join(g.path, m.Raw), &github.RepositoryContentGetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			r, err := file.GetContent()
+			if err != nil {
+				return nil, err
+			}
+			return g.parser.Read(m, ioutil.NopCloser(bytes.NewReader([]byte(r))))
+		}
+	}
+	return nil, &os.PathError{fmt.Sprintf("read repeatable %v", identifier), g.path, os.ErrNotExist}
+}
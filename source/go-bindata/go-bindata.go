@@ -22,6 +22,14 @@ func Resource(names []string, afn AssetFunc) *AssetSource {
 type AssetSource struct {
 	Names     []string
 	AssetFunc AssetFunc
+
+	// Parser maps the assets to migrations.
+	// It defaults to source.DefaultParser.
+	Parser source.Parser
+
+	// Repeatable enables repeatable migrations. Otherwise their assets
+	// are ignored, see source.WithoutRepeatables.
+	Repeatable bool
 }
 
 func init() {
@@ -31,6 +39,7 @@ func init() {
 type Bindata struct {
 	path        string
 	assetSource *AssetSource
+	parser      source.Parser
 	migrations  *source.Migrations
 }
 
@@ -51,17 +60,26 @@ func WithInstance(instance interface{}) (source.Driver, error) {
 	bn := &Bindata{
 		path:        "<go-bindata>",
 		assetSource: as,
+		parser:      source.DefaultParser,
 		migrations:  source.NewMigrations(),
 	}
+	if as.Parser != nil {
+		bn.parser = as.Parser
+	}
+	if !as.Repeatable {
+		bn.parser = source.WithoutRepeatables(bn.parser)
+	}
 
 	for _, fi := range as.Names {
-		m, err := source.DefaultParse(fi)
+		migrations, err := bn.parser.Parse(fi)
 		if err != nil {
 			continue // ignore files that we can't parse
 		}
 
-		if !bn.migrations.Append(m) {
-			return nil, fmt.Errorf("unable to parse file %v", fi)
+		for _, m := range migrations {
+			if !bn.migrations.Append(m) {
+				return nil, fmt.Errorf("unable to parse file %v", fi)
+			}
 		}
 	}
 
@@ -102,7 +120,11 @@ func (b *Bindata) ReadUp(version uint) (r io.ReadCloser, identifier string, err
 		if err != nil {
 			return nil, "", err
 		}
-		return ioutil.NopCloser(bytes.NewReader(body)), m.Identifier, nil
+		r, err := b.parser.Read(m, ioutil.NopCloser(bytes.NewReader(body)))
+		if err != nil {
+			return nil, "", err
+		}
+		return r, m.Identifier, nil
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), b.path, os.ErrNotExist}
 }
@@ -113,7 +135,26 @@ func (b *Bindata) ReadDown(version uint) (r io.ReadCloser, identifier string, er
 		if err != nil {
 			return nil, "", err
 		}
-		return ioutil.NopCloser(bytes.NewReader(body)), m.Identifier, nil
+		r, err := b.parser.Read(m, ioutil.NopCloser(bytes.NewReader(body)))
+		if err != nil {
+			return nil, "", err
+		}
+		return r, m.Identifier, nil
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), b.path, os.ErrNotExist}
 }
+
+func (b *Bindata) Repeatables() (identifiers []string, err error) {
+	return b.migrations.Repeatables(), nil
+}
+
+func (b *Bindata) ReadRepeatable(identifier string) (r io.ReadCloser, err error) {
+	if m, ok := b.migrations.Repeatable(identifier); ok {
+		body, err := b.assetSource.AssetFunc(m.Raw)
+		if err != nil {
+			return nil, err
+		}
+		return b.parser.Read(m, ioutil.NopCloser(bytes.NewReader(body)))
+	}
+	return nil, &os.PathError{fmt.Sprintf("read repeatable %v", identifier), b.path, os.ErrNotExist}
+}
@@ -1,8 +1,10 @@
 package bindata
 
 import (
+	"io/ioutil"
 	"testing"
 
+	"github.com/mattes/migrate/source"
 	"github.com/mattes/migrate/source/go-bindata/testdata"
 	st "github.com/mattes/migrate/source/testing"
 )
@@ -34,6 +36,48 @@ func TestWithInstance(t *testing.T) {
 	}
 }
 
+func TestRepeatable(t *testing.T) {
+	assets := map[string]string{
+		"1_foobar.up.sql": "1 up",
+		"R__views.sql":    "CREATE VIEW v1",
+	}
+	s := Resource([]string{"1_foobar.up.sql", "R__views.sql"},
+		func(name string) ([]byte, error) {
+			return []byte(assets[name]), nil
+		})
+
+	// repeatable migrations are ignored by default
+	d, err := WithInstance(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identifiers, _ := d.(source.RepeatableDriver).Repeatables(); len(identifiers) != 0 {
+		t.Fatalf("expected no repeatables, got %v", identifiers)
+	}
+
+	s.Repeatable = true
+	d, err = WithInstance(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := d.(source.RepeatableDriver)
+	if identifiers, _ := rd.Repeatables(); len(identifiers) != 1 || identifiers[0] != "views" {
+		t.Fatalf("expected repeatable views, got %v", identifiers)
+	}
+	r, err := rd.ReadRepeatable("views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "CREATE VIEW v1" {
+		t.Fatalf("expected CREATE VIEW v1, got %q", body)
+	}
+}
+
 func TestOpen(t *testing.T) {
 	b := &Bindata{}
 	_, err := b.Open("")
@@ -1,6 +1,7 @@
 package googlecloudstorage
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/url"
@@ -10,7 +11,6 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/mattes/migrate/source"
-	"golang.org/x/net/context"
 	"google.golang.org/api/iterator"
 )
 
@@ -21,6 +21,7 @@ func init() {
 type gcs struct {
 	bucket     *storage.BucketHandle
 	prefix     string
+	parser     source.Parser
 	migrations *source.Migrations
 }
 
@@ -29,6 +30,10 @@ func (g *gcs) Open(folder string) (source.Driver, error) {
 	if err != nil {
 		return nil, err
 	}
+	parser, err := source.ParserFromURL(folder)
+	if err != nil {
+		return nil, err
+	}
 	client, err := storage.NewClient(context.Background())
 	if err != nil {
 		return nil, err
@@ -36,6 +41,7 @@ func (g *gcs) Open(folder string) (source.Driver, error) {
 	driver := gcs{
 		bucket:     client.Bucket(u.Host),
 		prefix:     strings.Trim(u.Path, "/") + "/",
+		parser:     parser,
 		migrations: source.NewMigrations(),
 	}
 	err = driver.loadMigrations()
@@ -53,12 +59,14 @@ func (g *gcs) loadMigrations() error {
 	object, err := iter.Next()
 	for ; err == nil; object, err = iter.Next() {
 		_, fileName := path.Split(object.Name)
-		m, parseErr := source.DefaultParse(fileName)
+		migrations, parseErr := g.parser.Parse(fileName)
 		if parseErr != nil {
 			continue
 		}
-		if !g.migrations.Append(m) {
-			return fmt.Errorf("unable to parse file %v", object.Name)
+		for _, m := range migrations {
+			if !g.migrations.Append(m) {
+				return fmt.Errorf("unable to parse file %v", object.Name)
+			}
 		}
 	}
 	if err != iterator.Done {
@@ -72,6 +80,10 @@ func (g *gcs) Close() error {
 }
 
 func (g *gcs) First() (uint, error) {
+	return g.FirstContext(context.Background())
+}
+
+func (g *gcs) FirstContext(ctx context.Context) (uint, error) {
 	v, ok := g.migrations.First()
 	if !ok {
 		return 0, os.ErrNotExist
@@ -80,6 +92,10 @@ func (g *gcs) First() (uint, error) {
 }
 
 func (g *gcs) Prev(version uint) (uint, error) {
+	return g.PrevContext(context.Background(), version)
+}
+
+func (g *gcs) PrevContext(ctx context.Context, version uint) (uint, error) {
 	v, ok := g.migrations.Prev(version)
 	if !ok {
 		return 0, os.ErrNotExist
@@ -88,6 +104,10 @@ func (g *gcs) Prev(version uint) (uint, error) {
 }
 
 func (g *gcs) Next(version uint) (uint, error) {
+	return g.NextContext(context.Background(), version)
+}
+
+func (g *gcs) NextContext(ctx context.Context, version uint) (uint, error) {
 	v, ok := g.migrations.Next(version)
 	if !ok {
 		return 0, os.ErrNotExist
@@ -96,24 +116,48 @@ func (g *gcs) Next(version uint) (uint, error) {
 }
 
 func (g *gcs) ReadUp(version uint) (io.ReadCloser, string, error) {
+	return g.ReadUpContext(context.Background(), version)
+}
+
+func (g *gcs) ReadUpContext(ctx context.Context, version uint) (io.ReadCloser, string, error) {
 	if m, ok := g.migrations.Up(version); ok {
-		return g.open(m)
+		return g.open(ctx, m)
 	}
 	return nil, "", os.ErrNotExist
 }
 
 func (g *gcs) ReadDown(version uint) (io.ReadCloser, string, error) {
+	return g.ReadDownContext(context.Background(), version)
+}
+
+func (g *gcs) ReadDownContext(ctx context.Context, version uint) (io.ReadCloser, string, error) {
 	if m, ok := g.migrations.Down(version); ok {
-		return g.open(m)
+		return g.open(ctx, m)
 	}
 	return nil, "", os.ErrNotExist
 }
 
-func (g *gcs) open(m *source.Migration) (io.ReadCloser, string, error) {
+func (g *gcs) Repeatables() ([]string, error) {
+	return g.migrations.Repeatables(), nil
+}
+
+func (g *gcs) ReadRepeatable(identifier string) (io.ReadCloser, error) {
+	if m, ok := g.migrations.Repeatable(identifier); ok {
+		r, _, err := g.open(context.Background(), m)
+		return r, err
+	}
+	return nil, os.ErrNotExist
+}
+
+func (g *gcs) open(ctx context.Context, m *source.Migration) (io.ReadCloser, string, error) {
 	objectPath := path.Join(g.prefix, m.Raw)
-	reader, err := g.bucket.Object(objectPath).NewReader(context.Background())
+	reader, err := g.bucket.Object(objectPath).NewReader(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	body, err := g.parser.Read(m, reader)
 	if err != nil {
 		return nil, "", err
 	}
-	return reader, m.Identifier, nil
+	return body, m.Identifier, nil
 }
@@ -27,6 +27,7 @@ func Test(t *testing.T) {
 	driver := gcs{
 		bucket:     server.Client().Bucket("some-bucket"),
 		prefix:     "prod/migrations/",
+		parser:     source.DefaultParser,
 		migrations: source.NewMigrations(),
 	}
 	err := driver.loadMigrations()
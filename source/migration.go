@@ -4,12 +4,14 @@ import (
 	"sort"
 )
 
-// Direction is either up or down.
+// Direction is either up or down, or repeatable
+// for repeatable migrations.
 type Direction string
 
 const (
-	Down Direction = "down"
-	Up             = "up"
+	Down       Direction = "down"
+	Up                   = "up"
+	Repeatable Direction = "repeatable"
 )
 
 // Migration is a helper struct for source drivers that need to
@@ -23,7 +25,8 @@ type Migration struct {
 	// this migration in the source.
 	Identifier string
 
-	// Direction is either Up or Down.
+	// Direction is either Up or Down, or Repeatable. Repeatable
+	// migrations have no version.
 	Direction Direction
 
 	// Raw holds the raw location path to this migration in source.
@@ -34,14 +37,16 @@ type Migration struct {
 // Migrations wraps Migration and has an internal index
 // to keep track of Migration order.
 type Migrations struct {
-	index      uintSlice
-	migrations map[uint]map[Direction]*Migration
+	index       uintSlice
+	migrations  map[uint]map[Direction]*Migration
+	repeatables map[string]*Migration
 }
 
 func NewMigrations() *Migrations {
 	return &Migrations{
-		index:      make(uintSlice, 0),
-		migrations: make(map[uint]map[Direction]*Migration),
+		index:       make(uintSlice, 0),
+		migrations:  make(map[uint]map[Direction]*Migration),
+		repeatables: make(map[string]*Migration),
 	}
 }
 
@@ -50,6 +55,14 @@ func (i *Migrations) Append(m *Migration) (ok bool) {
 		return false
 	}
 
+	if m.Direction == Repeatable {
+		if _, dup := i.repeatables[m.Identifier]; dup {
+			return false
+		}
+		i.repeatables[m.Identifier] = m
+		return true
+	}
+
 	if i.migrations[m.Version] == nil {
 		i.migrations[m.Version] = make(map[Direction]*Migration)
 	}
@@ -114,6 +127,22 @@ func (i *Migrations) Down(version uint) (m *Migration, ok bool) {
 	return nil, false
 }
 
+// Repeatables returns the identifiers of all repeatable
+// migrations in ascending order.
+func (i *Migrations) Repeatables() []string {
+	identifiers := make([]string, 0, len(i.repeatables))
+	for identifier := range i.repeatables {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+	return identifiers
+}
+
+func (i *Migrations) Repeatable(identifier string) (m *Migration, ok bool) {
+	m, ok = i.repeatables[identifier]
+	return m, ok
+}
+
 func (i *Migrations) findPos(version uint) int {
 	if len(i.index) > 0 {
 		ix := i.index.Search(version)
@@ -20,8 +20,20 @@ var (
 //  123_name.down.ext
 var Regex = regexp.MustCompile(`^([0-9]+)_(.*)\.(` + string(Down) + `|` + string(Up) + `)\.(.*)$`)
 
-// Parse returns Migration for matching Regex pattern.
+// RepeatableRegex matches the following pattern of repeatable migrations:
+//  R__name.ext
+var RepeatableRegex = regexp.MustCompile(`^R__(.+)\.([^.]+)$`)
+
+// Parse returns Migration for matching Regex or RepeatableRegex pattern.
 func Parse(raw string) (*Migration, error) {
+	if m := RepeatableRegex.FindStringSubmatch(raw); len(m) == 3 {
+		return &Migration{
+			Identifier: m[1],
+			Direction:  Repeatable,
+			Raw:        raw,
+		}, nil
+	}
+
 	m := Regex.FindStringSubmatch(raw)
 	if len(m) == 5 {
 		versionUint64, err := strconv.ParseUint(m[1], 10, 64)
@@ -60,6 +60,29 @@ func TestParse(t *testing.T) {
 				Raw:        "20170412214116_date_foobar.up.sql",
 			},
 		},
+		{
+			name:      "R__views.sql",
+			expectErr: nil,
+			expectMigration: &Migration{
+				Identifier: "views",
+				Direction:  Repeatable,
+				Raw:        "R__views.sql",
+			},
+		},
+		{
+			name:      "R__user_functions.v2.sql",
+			expectErr: nil,
+			expectMigration: &Migration{
+				Identifier: "user_functions.v2",
+				Direction:  Repeatable,
+				Raw:        "R__user_functions.v2.sql",
+			},
+		},
+		{
+			name:            "R__.sql",
+			expectErr:       ErrParse,
+			expectMigration: nil,
+		},
 		{
 			name:            "-1_foobar.up.sql",
 			expectErr:       ErrParse,
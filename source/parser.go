@@ -0,0 +1,244 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	nurl "net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Parser maps the files of a source to migrations. Source drivers use
+// DefaultParser unless another parser is configured with the `x-parser`
+// URL parameter or their Config.
+type Parser interface {
+	// Parse returns the migrations stored in the file named raw.
+	// It returns ErrParse if raw doesn't follow the naming convention
+	// of the parser, the source driver ignores the file then.
+	Parse(raw string) ([]*Migration, error)
+
+	// Read returns the body of m, given the content r of the file m.Raw.
+	// It must close r if it doesn't return it.
+	Read(m *Migration, r io.ReadCloser) (io.ReadCloser, error)
+}
+
+var (
+	// DefaultParser parses files named 123_name.up.ext, 123_name.down.ext
+	// and R__name.ext with DefaultParse.
+	DefaultParser Parser = defaultParser{}
+
+	// FlywayParser parses files named V123__name.ext (up), U123__name.ext
+	// (down) and R__name.ext (repeatable). Versions must be integers.
+	FlywayParser Parser = flywayParser{}
+
+	// DbmateParser parses files named 123_name.ext, which hold the up and the
+	// down migration in sections starting with `-- migrate:up` and
+	// `-- migrate:down`. `transaction:false` after a section marker is
+	// translated to NoTransactionMarker.
+	DbmateParser Parser = dbmateParser{}
+)
+
+// NoTransactionMarker can be written by parsers to the leading comment lines
+// of a migration body, if the migration can't run in a transaction.
+// migrate treats it like its NoTransactionHeader, whatever that is set to.
+const NoTransactionMarker = "-- source:no-transaction"
+
+var parsersMu sync.RWMutex
+var parsers = map[string]Parser{
+	"default": DefaultParser,
+	"flyway":  FlywayParser,
+	"dbmate":  DbmateParser,
+}
+
+// RegisterParser makes a parser available by the provided name
+// for the `x-parser` URL parameter.
+// If RegisterParser is called twice with the same name or if parser is nil,
+// it panics.
+func RegisterParser(name string, parser Parser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	if parser == nil {
+		panic("RegisterParser parser is nil")
+	}
+	if _, dup := parsers[name]; dup {
+		panic("RegisterParser called twice for parser " + name)
+	}
+	parsers[name] = parser
+}
+
+// GetParser returns the parser registered by name.
+func GetParser(name string) (Parser, error) {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	parser, ok := parsers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown parser %v", name)
+	}
+	return parser, nil
+}
+
+// ParserFromURL returns the parser named by the `x-parser` parameter
+// of url, or DefaultParser if it isn't set. Files of repeatable migrations
+// are ignored, unless the `x-repeatable` parameter is true,
+// see WithoutRepeatables.
+func ParserFromURL(url string) (Parser, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := DefaultParser
+	if name := u.Query().Get("x-parser"); len(name) > 0 {
+		parser, err = GetParser(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	repeatable := false
+	if s := u.Query().Get("x-repeatable"); len(s) > 0 {
+		repeatable, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x-repeatable %v: %v", s, err)
+		}
+	}
+	if !repeatable {
+		parser = WithoutRepeatables(parser)
+	}
+	return parser, nil
+}
+
+// WithoutRepeatables returns a parser like parser, but it ignores the files
+// of repeatable migrations, i.e. R__name.ext. Sources use it unless
+// repeatable migrations are enabled, so these files aren't run by surprise.
+func WithoutRepeatables(parser Parser) Parser {
+	if _, ok := parser.(withoutRepeatables); ok {
+		return parser
+	}
+	return withoutRepeatables{parser}
+}
+
+type withoutRepeatables struct {
+	Parser
+}
+
+func (p withoutRepeatables) Parse(raw string) ([]*Migration, error) {
+	migrations, err := p.Parser.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	versioned := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		if m.Direction != Repeatable {
+			versioned = append(versioned, m)
+		}
+	}
+	if len(versioned) == 0 {
+		return nil, ErrParse
+	}
+	return versioned, nil
+}
+
+type defaultParser struct{}
+
+func (defaultParser) Parse(raw string) ([]*Migration, error) {
+	m, err := DefaultParse(raw)
+	if err != nil {
+		return nil, err
+	}
+	return []*Migration{m}, nil
+}
+
+func (defaultParser) Read(m *Migration, r io.ReadCloser) (io.ReadCloser, error) {
+	return r, nil
+}
+
+// FlywayRegex matches the following pattern:
+//  V123__name.ext
+//  U123__name.ext
+//  R__name.ext
+var FlywayRegex = regexp.MustCompile(`^(?:([VU])([0-9]+)|R)__(.+)\.([^.]+)$`)
+
+type flywayParser struct{}
+
+func (flywayParser) Parse(raw string) ([]*Migration, error) {
+	m := FlywayRegex.FindStringSubmatch(raw)
+	if len(m) != 5 {
+		return nil, ErrParse
+	}
+	if len(m[1]) == 0 {
+		return []*Migration{{Identifier: m[3], Direction: Repeatable, Raw: raw}}, nil
+	}
+
+	version, err := strconv.ParseUint(m[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	direction := Direction(Up)
+	if m[1] == "U" {
+		direction = Down
+	}
+	return []*Migration{{Version: uint(version), Identifier: m[3], Direction: direction, Raw: raw}}, nil
+}
+
+func (flywayParser) Read(m *Migration, r io.ReadCloser) (io.ReadCloser, error) {
+	return r, nil
+}
+
+// DbmateRegex matches the following pattern:
+//  123_name.ext
+var DbmateRegex = regexp.MustCompile(`^([0-9]+)_(.*)\.([^.]+)$`)
+
+var dbmateMarkerRegex = regexp.MustCompile(`^--\s*migrate:(up|down)\b(.*)$`)
+
+type dbmateParser struct{}
+
+func (dbmateParser) Parse(raw string) ([]*Migration, error) {
+	m := DbmateRegex.FindStringSubmatch(raw)
+	if len(m) != 4 {
+		return nil, ErrParse
+	}
+	version, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return []*Migration{
+		{Version: uint(version), Identifier: m[2], Direction: Up, Raw: raw},
+		{Version: uint(version), Identifier: m[2], Direction: Down, Raw: raw},
+	}, nil
+}
+
+// Read returns the section of the file for the direction of m.
+// A missing section is an empty migration.
+func (dbmateParser) Read(m *Migration, r io.ReadCloser) (io.ReadCloser, error) {
+	defer r.Close()
+
+	var body bytes.Buffer
+	var section string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if marker := dbmateMarkerRegex.FindStringSubmatch(strings.TrimSpace(line)); marker != nil {
+			section = marker[1]
+			if section == string(m.Direction) && strings.Contains(marker[2], "transaction:false") {
+				body.WriteString(NoTransactionMarker + "\n")
+			}
+			continue
+		}
+		if section == string(m.Direction) {
+			body.WriteString(line)
+			body.WriteString("\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(&body), nil
+}
@@ -0,0 +1,129 @@
+package source
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlywayParser(t *testing.T) {
+	tt := []struct {
+		name             string
+		expectErr        error
+		expectMigrations []*Migration
+	}{
+		{
+			name: "V1__foobar.sql",
+			expectMigrations: []*Migration{
+				{Version: 1, Identifier: "foobar", Direction: Up, Raw: "V1__foobar.sql"},
+			},
+		},
+		{
+			name: "U1__foobar.sql",
+			expectMigrations: []*Migration{
+				{Version: 1, Identifier: "foobar", Direction: Down, Raw: "U1__foobar.sql"},
+			},
+		},
+		{
+			name: "R__views.sql",
+			expectMigrations: []*Migration{
+				{Identifier: "views", Direction: Repeatable, Raw: "R__views.sql"},
+			},
+		},
+		{
+			name:      "V1.1__foobar.sql",
+			expectErr: ErrParse,
+		},
+		{
+			name:      "1_foobar.up.sql",
+			expectErr: ErrParse,
+		},
+	}
+
+	for i, v := range tt {
+		migrations, err := FlywayParser.Parse(v.name)
+		if err != v.expectErr {
+			t.Errorf("expected %v, got %v, in %v", v.expectErr, err, i)
+		}
+		if !reflect.DeepEqual(migrations, v.expectMigrations) {
+			t.Errorf("expected %+v, got %+v, in %v", v.expectMigrations, migrations, i)
+		}
+	}
+}
+
+func TestDbmateParser(t *testing.T) {
+	migrations, err := DbmateParser.Parse("1_foobar.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Migration{
+		{Version: 1, Identifier: "foobar", Direction: Up, Raw: "1_foobar.sql"},
+		{Version: 1, Identifier: "foobar", Direction: Down, Raw: "1_foobar.sql"},
+	}
+	if !reflect.DeepEqual(migrations, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, migrations)
+	}
+
+	if _, err := DbmateParser.Parse("1_foobar.up.sql"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if _, err := DbmateParser.Parse("foobar.sql"); err != ErrParse {
+		t.Errorf("expected ErrParse, got %v", err)
+	}
+
+	content := "-- migrate:up transaction:false\nCREATE 1;\n\n-- migrate:down\nDROP 1;\n"
+	tt := []struct {
+		migration *Migration
+		expected  string
+	}{
+		{migrations[0], NoTransactionMarker + "\nCREATE 1;\n\n"},
+		{migrations[1], "DROP 1;\n"},
+	}
+	for i, v := range tt {
+		r, err := DbmateParser.Read(v.migration, ioutil.NopCloser(strings.NewReader(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != v.expected {
+			t.Errorf("expected %q, got %q, in %v", v.expected, body, i)
+		}
+	}
+}
+
+func TestParserFromURL(t *testing.T) {
+	if p, err := ParserFromURL("file://migrations?x-repeatable=true"); err != nil || p != DefaultParser {
+		t.Errorf("expected DefaultParser, got %v, %v", p, err)
+	}
+	if p, err := ParserFromURL("file://migrations?x-parser=flyway&x-repeatable=1"); err != nil || p != FlywayParser {
+		t.Errorf("expected FlywayParser, got %v, %v", p, err)
+	}
+	if p, err := ParserFromURL("file://migrations"); err != nil || p != WithoutRepeatables(DefaultParser) {
+		t.Errorf("expected DefaultParser without repeatables, got %v, %v", p, err)
+	}
+	if _, err := ParserFromURL("file://migrations?x-parser=unknown"); err == nil {
+		t.Error("expected error for unknown parser")
+	}
+	if _, err := ParserFromURL("file://migrations?x-repeatable=maybe"); err == nil {
+		t.Error("expected error for invalid x-repeatable")
+	}
+}
+
+func TestWithoutRepeatables(t *testing.T) {
+	p := WithoutRepeatables(FlywayParser)
+	if _, err := p.Parse("R__views.sql"); err != ErrParse {
+		t.Errorf("expected ErrParse, got %v", err)
+	}
+	migrations, err := p.Parse("V1__foobar.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Migration{{Version: 1, Identifier: "foobar", Direction: Up, Raw: "V1__foobar.sql"}}
+	if !reflect.DeepEqual(migrations, expected) {
+		t.Errorf("expected %+v, got %+v", expected, migrations)
+	}
+}
@@ -83,3 +83,14 @@ func (s *Stub) ReadDown(version uint) (r io.ReadCloser, identifier string, err e
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read down version %v", version), s.Url, os.ErrNotExist}
 }
+
+func (s *Stub) Repeatables() (identifiers []string, err error) {
+	return s.Migrations.Repeatables(), nil
+}
+
+func (s *Stub) ReadRepeatable(identifier string) (r io.ReadCloser, err error) {
+	if m, ok := s.Migrations.Repeatable(identifier); ok {
+		return ioutil.NopCloser(bytes.NewBufferString(m.Raw)), nil
+	}
+	return nil, &os.PathError{fmt.Sprintf("read repeatable %v", identifier), s.Url, os.ErrNotExist}
+}
@@ -0,0 +1,162 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mattes/migrate/database"
+)
+
+// MigrationStatus describes a version in the source or a version
+// that has been applied to the database.
+type MigrationStatus struct {
+	// Version is the version of the migration.
+	Version uint
+
+	// Identifier is the identifier of the migration in the source, or the
+	// one recorded in the migration history if it's missing in the source.
+	Identifier string
+
+	// HasUp and HasDown are true if the source has an up or a down
+	// migration for this version.
+	HasUp   bool
+	HasDown bool
+
+	// Applied is true if the migration has been applied to the database.
+	Applied bool
+
+	// AppliedAt is the time when the migration has been applied, if it
+	// is recorded in the migration history.
+	AppliedAt time.Time
+
+	// Current is true for the currently active version.
+	Current bool
+
+	// Dirty is true for the currently active version, if the database is dirty.
+	Dirty bool
+
+	// Missing is true if the migration has been applied, but
+	// doesn't exist in the source anymore.
+	Missing bool
+}
+
+// Status returns the status of all migrations in the source and of
+// all applied migrations that are missing in the source, ordered by version.
+// If the database keeps a migration history, it is used to find migrations
+// below the currently active version that haven't been applied, otherwise
+// all of them are considered applied.
+// Status doesn't acquire the database lock.
+func (m *Migrate) Status() ([]MigrationStatus, error) {
+	return m.StatusContext(context.Background())
+}
+
+// StatusContext is like Status.
+func (m *Migrate) StatusContext(ctx context.Context) ([]MigrationStatus, error) {
+	curVersion, dirty, err := m.databaseVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := m.History()
+	if err != nil && err != database.ErrNoHistory {
+		return nil, err
+	}
+	applied := appliedMigrations(history)
+
+	// versions older than the oldest version in the history might have
+	// been applied before the history was enabled
+	oldest := -1
+	if v, ok := oldestVersion(history); ok {
+		oldest = int(v)
+	}
+
+	statuses := make([]MigrationStatus, 0)
+	inSource := make(map[uint]bool)
+
+	v, err := m.sourceFirst(ctx)
+	for err == nil {
+		if m.stop(ctx) {
+			return nil, ctx.Err()
+		}
+
+		s, statusErr := m.sourceStatus(ctx, v)
+		if statusErr != nil {
+			return nil, statusErr
+		}
+
+		entry, isApplied := applied[v]
+		if oldest < 0 || int(v) < oldest || int(v) == curVersion {
+			isApplied = int(v) <= curVersion
+		}
+		s.Applied = isApplied
+		s.AppliedAt = entry.StartedAt
+		s.Current = int(v) == curVersion
+		s.Dirty = s.Current && dirty
+
+		statuses = append(statuses, s)
+		inSource[v] = true
+
+		v, err = m.sourceNext(ctx, v)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// applied versions that have been removed from the source
+	for version, entry := range applied {
+		if !inSource[version] {
+			statuses = append(statuses, MigrationStatus{
+				Version:    version,
+				Identifier: entry.Identifier,
+				Applied:    true,
+				AppliedAt:  entry.StartedAt,
+				Current:    int(version) == curVersion,
+				Dirty:      int(version) == curVersion && dirty,
+				Missing:    true,
+			})
+			inSource[version] = true
+		}
+	}
+	if curVersion >= 0 && !inSource[suint(curVersion)] {
+		statuses = append(statuses, MigrationStatus{
+			Version: suint(curVersion),
+			Applied: true,
+			Current: true,
+			Dirty:   dirty,
+			Missing: true,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// sourceStatus returns the identifier of version and if it has
+// an up and a down migration in the source.
+func (m *Migrate) sourceStatus(ctx context.Context, version uint) (MigrationStatus, error) {
+	s := MigrationStatus{Version: version}
+
+	r, identifier, err := m.sourceReadUp(ctx, version)
+	if err == nil {
+		r.Close()
+		s.HasUp = true
+		s.Identifier = identifier
+	} else if !os.IsNotExist(err) {
+		return s, err
+	}
+
+	r, identifier, err = m.sourceReadDown(ctx, version)
+	if err == nil {
+		r.Close()
+		s.HasDown = true
+		if !s.HasUp {
+			s.Identifier = identifier
+		}
+	} else if !os.IsNotExist(err) {
+		return s, err
+	}
+
+	return s, nil
+}
@@ -36,6 +36,11 @@ func (m MultiError) Error() string {
 	return strings.Join(strs, " and ")
 }
 
+// Unwrap returns the errors.
+func (m MultiError) Unwrap() []error {
+	return m.Errs
+}
+
 // suint safely converts int to uint
 // see https://goo.gl/wEcqof
 // see https://goo.gl/pai7Dr
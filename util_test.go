@@ -20,6 +20,14 @@ func TestSuint(t *testing.T) {
 	}
 }
 
+func TestMultiErrorUnwrap(t *testing.T) {
+	err := NewMultiError(ErrNoChange, nil, ErrLocked)
+	errs := err.Unwrap()
+	if len(errs) != 2 || errs[0] != ErrNoChange || errs[1] != ErrLocked {
+		t.Fatalf("expected ErrNoChange and ErrLocked, got %v", errs)
+	}
+}
+
 func TestFilterCustomQuery(t *testing.T) {
 	n, err := nurl.Parse("foo://host?a=b&x-custom=foo&c=d")
 	if err != nil {
@@ -0,0 +1,180 @@
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+)
+
+// ChecksumMismatch describes an applied migration that changed
+// in the source after it has been applied to the database.
+type ChecksumMismatch struct {
+	// Version is the version of the migration.
+	Version uint
+
+	// Identifier is the identifier recorded in the migration history.
+	Identifier string
+
+	// Recorded is the checksum recorded in the migration history.
+	Recorded string
+
+	// Current is the checksum of the migration in the source.
+	// It is empty if the up migration doesn't exist in the source anymore.
+	Current string
+}
+
+// ErrChecksumMismatch is returned by Validate and Up if applied migrations
+// don't match the migrations in the source anymore.
+type ErrChecksumMismatch struct {
+	Mismatches []ChecksumMismatch
+}
+
+// Error implements the error interface.
+func (e ErrChecksumMismatch) Error() string {
+	strs := make([]string, 0)
+	for _, mm := range e.Mismatches {
+		if len(mm.Current) == 0 {
+			strs = append(strs, fmt.Sprintf("%v (%v) is missing in source", mm.Version, mm.Identifier))
+		} else {
+			strs = append(strs, fmt.Sprintf("%v (%v) changed since it was applied", mm.Version, mm.Identifier))
+		}
+	}
+	return fmt.Sprintf("applied migrations don't match source: %v", strings.Join(strs, ", "))
+}
+
+// Validate compares the checksums recorded in the migration history with
+// the up migrations in the source. It returns ErrChecksumMismatch if applied
+// migrations changed in the source since they have been applied.
+// It returns database.ErrNoHistory if the database doesn't keep a history.
+func (m *Migrate) Validate() error {
+	return m.ValidateContext(context.Background())
+}
+
+// ValidateContext is like Validate.
+func (m *Migrate) ValidateContext(ctx context.Context) error {
+	history, err := m.History()
+	if err != nil {
+		return err
+	}
+
+	applied := appliedMigrations(history)
+
+	// migrations replaced by a squashed migration can't be validated
+	squashed, isSquashed, err := m.squashedVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	versions := make([]uint, 0, len(applied))
+	for v := range applied {
+		if isSquashed && v <= squashed {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	mismatches := make([]ChecksumMismatch, 0)
+	for _, v := range versions {
+		entry := applied[v]
+
+		current, err := m.sourceChecksum(ctx, v)
+		if err != nil {
+			return err
+		}
+
+		if current != entry.Checksum {
+			mismatches = append(mismatches, ChecksumMismatch{
+				Version:    v,
+				Identifier: entry.Identifier,
+				Recorded:   entry.Checksum,
+				Current:    current,
+			})
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return ErrChecksumMismatch{mismatches}
+	}
+	return nil
+}
+
+// validateBeforeUp runs ValidateContext unless disabled. It doesn't fail
+// if the database doesn't keep a migration history.
+func (m *Migrate) validateBeforeUp(ctx context.Context) error {
+	if m.SkipValidation {
+		return nil
+	}
+	if err := m.ValidateContext(ctx); err != nil && err != database.ErrNoHistory {
+		return err
+	}
+	return nil
+}
+
+// sourceChecksum returns the hex encoded SHA-256 checksum of the up
+// migration for version. It returns an empty string if the up migration
+// doesn't exist.
+func (m *Migrate) sourceChecksum(ctx context.Context, version uint) (string, error) {
+	r, _, err := m.sourceReadUp(ctx, version)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	return checksum(r)
+}
+
+// checksum returns the hex encoded SHA-256 checksum of everything read from r.
+func checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// squashedVersion returns the version of the first migration in the source,
+// if it starts with SquashedHeader.
+func (m *Migrate) squashedVersion(ctx context.Context) (version uint, ok bool, err error) {
+	first, err := m.sourceFirst(ctx)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	r, _, err := m.sourceReadUp(ctx, first)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	defer r.Close()
+
+	return first, hasHeader(bufio.NewReader(r), SquashedHeader), nil
+}
+
+// appliedMigrations replays the migration history and returns the
+// latest up entry for every version that is currently applied.
+func appliedMigrations(history []database.HistoryEntry) map[uint]database.HistoryEntry {
+	applied := make(map[uint]database.HistoryEntry)
+	for _, h := range history {
+		switch h.Direction {
+		case database.DirectionUp:
+			applied[h.Version] = h
+		case database.DirectionDown:
+			delete(applied, h.Version)
+		}
+	}
+	return applied
+}
@@ -0,0 +1,67 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+)
+
+// varRegex matches the following variables, with name as first or
+// second submatch:
+//  ${name}
+//  {{.name}}
+var varRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\{\{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// ErrUndefinedVar is returned if StrictVars is set and
+// a migration uses a variable that isn't in Vars.
+type ErrUndefinedVar struct {
+	Name string
+}
+
+// Error implements the error interface.
+func (e ErrUndefinedVar) Error() string {
+	return fmt.Sprintf("undefined variable %v", e.Name)
+}
+
+// templating returns true if variables in migration bodies are replaced.
+func (m *Migrate) templating() bool {
+	return len(m.Vars) > 0 || m.StrictVars
+}
+
+// expandVars reads r into memory and replaces the variables in it with their
+// values from Vars, if templating is enabled. Otherwise it returns r.
+func (m *Migrate) expandVars(r io.Reader) (io.Reader, error) {
+	if !m.templating() {
+		return r, nil
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var undefined error
+	expanded := varRegex.ReplaceAllFunc(body, func(match []byte) []byte {
+		sub := varRegex.FindSubmatch(match)
+		name := string(sub[1])
+		if len(name) == 0 {
+			name = string(sub[2])
+		}
+
+		value, ok := m.Vars[name]
+		if !ok {
+			if m.StrictVars && undefined == nil {
+				undefined = ErrUndefinedVar{Name: name}
+			}
+			// leave undefined variables as they are
+			return match
+		}
+		return []byte(value)
+	})
+	if undefined != nil {
+		return nil, undefined
+	}
+	return bytes.NewReader(expanded), nil
+}